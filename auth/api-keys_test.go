@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/krishkalaria12/snap-serve/database"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open gorm: %v", err)
+	}
+
+	database.SetDB(db)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet database expectations: %v", err)
+		}
+		sqlDB.Close()
+	})
+	return mock
+}
+
+// expectAPIKeyLookup expects ResolveAPIKey to find key 1 of user 7, last used
+// at lastUsed
+func expectAPIKeyLookup(mock sqlmock.Sqlmock, lastUsed *time.Time) {
+	mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "key_hash", "last_used_at"}).
+			AddRow(1, 7, hashAPIKey("ss_test"), lastUsed))
+	mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "username"}).AddRow(7, "test"))
+}
+
+func TestResolveAPIKeyRecordsStaleUse(t *testing.T) {
+	mock := newMockDB(t)
+
+	lastUsed := time.Now().Add(-2 * apiKeyUseInterval)
+	expectAPIKeyLookup(mock, &lastUsed)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "api_keys" SET "last_used_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	user, err := ResolveAPIKey("ss_test")
+	if err != nil {
+		t.Fatalf("ResolveAPIKey: %v", err)
+	}
+	if user.ID != "7" {
+		t.Fatalf("user ID = %q, want 7", user.ID)
+	}
+}
+
+func TestResolveAPIKeySkipsRecentUse(t *testing.T) {
+	mock := newMockDB(t)
+
+	// No UPDATE is expected. sqlmock rejects one, which ResolveAPIKey only
+	// logs, so the log must stay empty.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lastUsed := time.Now().Add(-apiKeyUseInterval / 2)
+	expectAPIKeyLookup(mock, &lastUsed)
+
+	if _, err := ResolveAPIKey("ss_test"); err != nil {
+		t.Fatalf("ResolveAPIKey: %v", err)
+	}
+	if logged.Len() > 0 {
+		t.Fatalf("recent use was written again: %s", logged.String())
+	}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/krishkalaria12/snap-serve/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms selectable with PASSWORD_HASH_ALGORITHM
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+const bcryptCost = 10
+
+// Argon2id parameters, following the OWASP recommendation of 19 MiB of
+// memory, two passes and one thread
+const (
+	argon2Memory  = 19 * 1024
+	argon2Time    = 2
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+var errMalformedHash = errors.New("malformed password hash")
+
+// PasswordHasher hashes and verifies passwords with one algorithm
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash
+	Verify(password, hash string) (bool, error)
+	// Recognizes reports whether hash was produced by this algorithm
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether hash uses weaker parameters than Hash would
+	NeedsRehash(hash string) bool
+}
+
+var passwordHashers = map[string]PasswordHasher{
+	HashBcrypt:   bcryptHasher{cost: bcryptCost},
+	HashArgon2id: argon2idHasher{memory: argon2Memory, time: argon2Time, threads: argon2Threads},
+}
+
+// passwordHasher hashes new passwords, bcrypt unless PASSWORD_HASH_ALGORITHM
+// says otherwise
+var passwordHasher PasswordHasher = passwordHashers[HashBcrypt]
+
+// loadPasswordHasher reads PASSWORD_HASH_ALGORITHM
+func loadPasswordHasher() {
+	algorithm := strings.ToLower(config.ConfigDefault("PASSWORD_HASH_ALGORITHM", HashBcrypt))
+	hasher, ok := passwordHashers[algorithm]
+	if !ok {
+		log.Fatalf("PASSWORD_HASH_ALGORITHM must be %s or %s, got %q", HashBcrypt, HashArgon2id, algorithm)
+	}
+	passwordHasher = hasher
+}
+
+// HashPassword hashes password with the configured algorithm
+func HashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
+}
+
+// VerifyPassword checks password against a hash made by any supported
+// algorithm. needsRehash is true when the password matched but the hash
+// isn't what HashPassword would produce today, so callers can upgrade it.
+func VerifyPassword(password, hash string) (ok, needsRehash bool) {
+	for _, hasher := range passwordHashers {
+		if !hasher.Recognizes(hash) {
+			continue
+		}
+
+		ok, err := hasher.Verify(password, hash)
+		if err != nil || !ok {
+			return false, false
+		}
+		return true, hasher != passwordHasher || hasher.NeedsRehash(hash)
+	}
+	return false, false
+}
+
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hashed), err
+}
+
+func (h bcryptHasher) Verify(password, hash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h bcryptHasher) Recognizes(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func (h bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < h.cost
+}
+
+// argon2idHasher stores hashes in the PHC string format used by the
+// reference implementation: $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+type argon2idParams struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, argon2KeyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (h argon2idHasher) Verify(password, hash string) (bool, error) {
+	params, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+	return subtle.ConstantTimeCompare(key, params.key) == 1, nil
+}
+
+func (h argon2idHasher) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (h argon2idHasher) NeedsRehash(hash string) bool {
+	params, err := parseArgon2id(hash)
+	return err != nil || params.memory < h.memory || params.time < h.time
+}
+
+func parseArgon2id(hash string) (argon2idParams, error) {
+	var params argon2idParams
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, errMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, errMalformedHash
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return params, errMalformedHash
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, errMalformedHash
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(params.key) == 0 {
+		return params, errMalformedHash
+	}
+
+	return params, nil
+}
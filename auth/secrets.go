@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/go-pkgz/auth/v2/token"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+const (
+	MinSecretLength = 32
+	// MaxPreviousSecrets is how many retired secrets are still accepted
+	MaxPreviousSecrets = 2
+)
+
+var ErrSecretTooShort = errors.New("secret must be at least 32 characters")
+
+// jwtSecrets holds the secret new tokens are signed with along with recently
+// retired ones. Tokens signed with a retired secret keep validating until
+// they expire, so rotating the secret doesn't log everyone out.
+type jwtSecrets struct {
+	mu       sync.RWMutex
+	current  string
+	previous []string
+}
+
+var secrets jwtSecrets
+
+// loadSecrets reads JWT_SECRET and the comma separated JWT_PREVIOUS_SECRETS
+func loadSecrets() {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	secrets.current = config.Config("JWT_SECRET")
+	secrets.previous = nil
+	for _, secret := range strings.Split(config.ConfigDefault("JWT_PREVIOUS_SECRETS", ""), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets.previous = append(secrets.previous, secret)
+		}
+	}
+}
+
+func currentSecret() string {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	return secrets.current
+}
+
+func previousSecrets() []string {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	return append([]string(nil), secrets.previous...)
+}
+
+// Secrets returns the secret new tokens are signed with and the retired ones
+// still accepted
+func Secrets() (string, []string) {
+	secrets.mu.RLock()
+	defer secrets.mu.RUnlock()
+	return secrets.current, append([]string(nil), secrets.previous...)
+}
+
+// RotatedSecrets returns the secrets after making newSecret the signing
+// secret. The current one is retired, keeping the latest MaxPreviousSecrets.
+func RotatedSecrets(current string, previous []string, newSecret string) (string, []string, error) {
+	if len(newSecret) < MinSecretLength {
+		return "", nil, ErrSecretTooShort
+	}
+	if newSecret == current {
+		return current, previous, nil
+	}
+
+	previous = append([]string{current}, previous...)
+	if len(previous) > MaxPreviousSecrets {
+		previous = previous[:MaxPreviousSecrets]
+	}
+	return newSecret, previous, nil
+}
+
+// SetSecrets replaces the signing and retired secrets, e.g. with a rotation
+// stored by another instance
+func SetSecrets(current string, previous []string) {
+	secrets.mu.Lock()
+	defer secrets.mu.Unlock()
+
+	if current == secrets.current && slices.Equal(previous, secrets.previous) {
+		return
+	}
+	secrets.current = current
+	secrets.previous = append([]string(nil), previous...)
+
+	// A rotation may be answering a leaked secret, so tokens validated
+	// before it are checked again
+	validatedTokens.clear()
+}
+
+// ParseToken validates a token against the current secret, falling back to
+// the previous ones while a rotation is in progress. Recently validated
+// tokens are answered from the token cache.
+func ParseToken(tokenStr string) (token.Claims, error) {
+	if claims, ok := validatedTokens.get(tokenStr); ok {
+		if tokenRevoked(claims) {
+			return token.Claims{}, ErrTokenRevoked
+		}
+		return claims, nil
+	}
+
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return token.Claims{}, err
+	}
+	if tokenRevoked(claims) {
+		return token.Claims{}, ErrTokenRevoked
+	}
+
+	validatedTokens.put(tokenStr, claims)
+	return claims, nil
+}
+
+func parseToken(tokenStr string) (token.Claims, error) {
+	service := authService.TokenService()
+
+	claims, err := service.Parse(tokenStr)
+	if err == nil {
+		return claims, nil
+	}
+
+	for _, secret := range previousSecrets() {
+		opts := service.Opts
+		opts.SecretReader = token.SecretFunc(func(string) (string, error) {
+			return secret, nil
+		})
+
+		if claims, prevErr := token.NewService(opts).Parse(tokenStr); prevErr == nil {
+			return claims, nil
+		}
+	}
+
+	return token.Claims{}, err
+}
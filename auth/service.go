@@ -9,10 +9,8 @@ import (
 	"github.com/go-pkgz/auth/v2/avatar"
 	"github.com/go-pkgz/auth/v2/provider"
 	"github.com/go-pkgz/auth/v2/token"
-	"github.com/krishkalaria12/snap-serve/config"
 	"github.com/krishkalaria12/snap-serve/database"
 	"github.com/krishkalaria12/snap-serve/models"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -21,10 +19,12 @@ var authService *auth.Service
 
 // Initialize auth service
 func SetupAuthService() *auth.Service {
+	loadSecrets()
+	loadPasswordHasher()
+
 	options := auth.Opts{
 		SecretReader: token.SecretFunc(func(id string) (string, error) {
-			// Use environment variable in production
-			return config.Config("JWT_SECRET"), nil
+			return currentSecret(), nil
 		}),
 		TokenDuration:  time.Hour * 24,     // JWT token duration
 		CookieDuration: time.Hour * 24 * 7, // Cookie duration
@@ -70,18 +70,13 @@ func ValidateUserCredentials(identity, password string) (bool, error) {
 	}
 
 	// Check password
-	if !checkPasswordHash(password, user.Password) {
+	if ok, _ := VerifyPassword(password, user.Password); !ok {
 		return false, nil // Invalid password
 	}
 
 	return true, nil
 }
 
-func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
-}
-
 func isEmail(identity string) bool {
 	_, err := mail.ParseAddress(identity)
 	return err == nil
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-pkgz/auth/v2/token"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// Validated tokens are cached for up to tokenCacheTTL so clients firing many
+// requests with the same token don't pay for signature verification on each.
+// A JWT_CACHE_SIZE of 0 turns the cache off.
+var (
+	tokenCacheSize = config.ConfigInt("JWT_CACHE_SIZE", 10000)
+	tokenCacheTTL  = time.Duration(config.ConfigInt("JWT_CACHE_TTL_SECONDS", 60)) * time.Second
+)
+
+type cachedToken struct {
+	claims    token.Claims
+	expiresAt time.Time
+}
+
+// tokenCache maps the hash of a token to its claims, so raw tokens aren't
+// kept in memory
+type tokenCache struct {
+	mu      sync.RWMutex
+	entries map[[sha256.Size]byte]cachedToken
+}
+
+var validatedTokens = tokenCache{entries: map[[sha256.Size]byte]cachedToken{}}
+
+func (tc *tokenCache) get(tokenStr string) (token.Claims, bool) {
+	if tokenCacheSize <= 0 {
+		return token.Claims{}, false
+	}
+
+	tc.mu.RLock()
+	entry, ok := tc.entries[sha256.Sum256([]byte(tokenStr))]
+	tc.mu.RUnlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		return token.Claims{}, false
+	}
+	return entry.claims, true
+}
+
+// put caches claims until the cache TTL passes or the token itself expires,
+// whichever comes first
+func (tc *tokenCache) put(tokenStr string, claims token.Claims) {
+	if tokenCacheSize <= 0 {
+		return
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(tokenCacheTTL)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expiresAt) {
+		expiresAt = claims.ExpiresAt.Time
+	}
+	if !expiresAt.After(now) {
+		return
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if len(tc.entries) >= tokenCacheSize {
+		tc.evict(now)
+	}
+	tc.entries[sha256.Sum256([]byte(tokenStr))] = cachedToken{claims: claims, expiresAt: expiresAt}
+}
+
+// clear drops every cached token
+func (tc *tokenCache) clear() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	clear(tc.entries)
+}
+
+// dropUser drops the cached tokens of one user
+func (tc *tokenCache) dropUser(userID string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	for key, entry := range tc.entries {
+		if entry.claims.User != nil && entry.claims.User.ID == userID {
+			delete(tc.entries, key)
+		}
+	}
+}
+
+var ErrTokenRevoked = errors.New("token revoked")
+
+// revokedUsers holds the IDs of users whose tokens are no longer accepted,
+// cached or not. Like the cache it only lives in memory, which is enough for
+// deleted users: their IDs are never reused and they can't log in again.
+var revokedUsers sync.Map
+
+// RevokeUserTokens rejects every token of userID from now on
+func RevokeUserTokens(userID string) {
+	revokedUsers.Store(userID, struct{}{})
+	validatedTokens.dropUser(userID)
+}
+
+func tokenRevoked(claims token.Claims) bool {
+	if claims.User == nil {
+		return false
+	}
+	_, revoked := revokedUsers.Load(claims.User.ID)
+	return revoked
+}
+
+// evict drops expired entries, then arbitrary ones until there's room. The
+// caller must hold the write lock.
+func (tc *tokenCache) evict(now time.Time) {
+	for key, entry := range tc.entries {
+		if now.After(entry.expiresAt) {
+			delete(tc.entries, key)
+		}
+	}
+
+	for key := range tc.entries {
+		if len(tc.entries) < tokenCacheSize {
+			break
+		}
+		delete(tc.entries, key)
+	}
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-pkgz/auth/v2/token"
+	"github.com/golang-jwt/jwt/v5"
+	_ "github.com/krishkalaria12/snap-serve/internal/testenv"
+)
+
+func init() {
+	SetupAuthService()
+}
+
+// issueToken signs a token for userID the way Login does
+func issueToken(t testing.TB, userID string) string {
+	t.Helper()
+
+	tokenStr, err := authService.TokenService().Token(token.Claims{
+		User: &token.User{ID: userID, Name: "test"},
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    authService.TokenService().Issuer,
+			Audience:  []string{"snap-serve-app"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+	return tokenStr
+}
+
+func cached(tokenStr string) bool {
+	_, ok := validatedTokens.get(tokenStr)
+	return ok
+}
+
+func TestParseTokenCachesValidTokens(t *testing.T) {
+	tokenStr := issueToken(t, "101")
+
+	claims, err := ParseToken(tokenStr)
+	if err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+	if claims.User.ID != "101" {
+		t.Fatalf("user ID = %q, want 101", claims.User.ID)
+	}
+	if !cached(tokenStr) {
+		t.Fatal("token wasn't cached after validating")
+	}
+}
+
+func TestSetSecretsClearsTokenCache(t *testing.T) {
+	original := currentSecret()
+	t.Cleanup(func() {
+		secrets.mu.Lock()
+		secrets.current, secrets.previous = original, nil
+		secrets.mu.Unlock()
+	})
+
+	tokenStr := issueToken(t, "102")
+	if _, err := ParseToken(tokenStr); err != nil {
+		t.Fatalf("ParseToken: %v", err)
+	}
+
+	current, previous := Secrets()
+	current, previous, err := RotatedSecrets(current, previous, strings.Repeat("r", MinSecretLength))
+	if err != nil {
+		t.Fatalf("RotatedSecrets: %v", err)
+	}
+	SetSecrets(current, previous)
+	if cached(tokenStr) {
+		t.Fatal("token still cached after rotating the secret")
+	}
+
+	// The old secret is still accepted during the rotation
+	if _, err := ParseToken(tokenStr); err != nil {
+		t.Fatalf("ParseToken after rotation: %v", err)
+	}
+}
+
+func TestRevokeUserTokens(t *testing.T) {
+	revoked := issueToken(t, "103")
+	other := issueToken(t, "104")
+	for _, tokenStr := range []string{revoked, other} {
+		if _, err := ParseToken(tokenStr); err != nil {
+			t.Fatalf("ParseToken: %v", err)
+		}
+	}
+
+	RevokeUserTokens("103")
+
+	if cached(revoked) {
+		t.Fatal("revoked user's token is still cached")
+	}
+	if _, err := ParseToken(revoked); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("ParseToken of revoked user's token: err = %v, want ErrTokenRevoked", err)
+	}
+	if _, err := ParseToken(other); err != nil {
+		t.Fatalf("ParseToken of another user's token: %v", err)
+	}
+}
+
+// BenchmarkParseToken compares full signature verification with a cache
+// hit. Run with go test -bench ParseToken -benchmem ./auth
+func BenchmarkParseToken(b *testing.B) {
+	tokenStr := issueToken(b, "105")
+
+	b.Run("uncached", func(b *testing.B) {
+		size := tokenCacheSize
+		tokenCacheSize = 0
+		defer func() { tokenCacheSize = size }()
+
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := ParseToken(tokenStr); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		if _, err := ParseToken(tokenStr); err != nil {
+			b.Fatal(err)
+		}
+
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := ParseToken(tokenStr); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -1,15 +1,20 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
 
+// Config returns a required setting, exiting when it isn't set. The .env
+// file is optional, so settings can also come from the environment alone.
 func Config(envVar string) string {
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		fmt.Fprintf(os.Stderr, "Error loading .env file: %v\n", err)
 		panic(err)
 	}
@@ -22,3 +27,48 @@ func Config(envVar string) string {
 
 	return envVarValue
 }
+
+// ConfigDefault returns the value of an optional setting, falling back to
+// defaultValue when it isn't set
+func ConfigDefault(envVar, defaultValue string) string {
+	_ = godotenv.Load()
+
+	envVarValue := os.Getenv(envVar)
+	if envVarValue == "" {
+		return defaultValue
+	}
+
+	return envVarValue
+}
+
+// ConfigBool reads an optional boolean setting
+func ConfigBool(envVar string, defaultValue bool) bool {
+	envVarValue := ConfigDefault(envVar, "")
+	if envVarValue == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.ParseBool(envVarValue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s must be a boolean, got %q\n", envVar, envVarValue)
+		os.Exit(1)
+	}
+
+	return value
+}
+
+// ConfigInt reads an optional integer setting
+func ConfigInt(envVar string, defaultValue int) int {
+	envVarValue := ConfigDefault(envVar, "")
+	if envVarValue == "" {
+		return defaultValue
+	}
+
+	value, err := strconv.Atoi(envVarValue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s must be an integer, got %q\n", envVar, envVarValue)
+		os.Exit(1)
+	}
+
+	return value
+}
@@ -10,6 +10,7 @@ import (
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+	otelgorm "gorm.io/plugin/opentelemetry/tracing"
 )
 
 var (
@@ -25,6 +26,13 @@ func GetDB() *gorm.DB {
 	return instance
 }
 
+// SetDB makes GetDB return db instead of connecting, e.g. one backed by a
+// mock in tests. It isn't safe to call while requests are served.
+func SetDB(db *gorm.DB) {
+	once.Do(func() {})
+	instance = db
+}
+
 func connectDB() *gorm.DB {
 	dsn := config.Config("DATABASE_URL")
 
@@ -36,6 +44,12 @@ func connectDB() *gorm.DB {
 		panic(err)
 	}
 
+	// Queries run with a request's context become spans of its trace.
+	// Values are left out, they include password and API key hashes.
+	if err := db.Use(otelgorm.NewPlugin(otelgorm.WithoutMetrics(), otelgorm.WithoutQueryVariables())); err != nil {
+		log.Fatalf("Failed to set up database tracing: %v", err)
+	}
+
 	// Get the underlying SQL DB object for connection pooling
 	sqlDB, err := db.DB()
 	if err != nil {
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BackfillPause is how long a backfill waits between batches, leaving room
+// for regular traffic on the table
+const BackfillPause = 100 * time.Millisecond
+
+// migrationLockID is the Postgres advisory lock held while migrations run, so
+// instances starting together don't run the same migration twice
+const migrationLockID = 7_246_531
+
+// Migration is a one-off schema or data change that AutoMigrate can't do
+// safely on a large table. Migrate runs outside a transaction so it can use
+// CREATE INDEX CONCURRENTLY, and each migration runs at most once.
+type Migration struct {
+	ID      string
+	Migrate func(db *gorm.DB) error
+}
+
+type schemaMigration struct {
+	ID        string `gorm:"primaryKey"`
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// RunMigrations applies the migrations that haven't run yet, in order
+func RunMigrations(migrations []Migration) error {
+	db := GetDB()
+	if err := db.AutoMigrate(&schemaMigration{}); err != nil {
+		return err
+	}
+
+	// Advisory locks belong to a session, so everything runs on one connection
+	return db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockID).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockID)
+
+		for _, migration := range migrations {
+			var applied int64
+			if err := conn.Model(&schemaMigration{}).Where("id = ?", migration.ID).Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				continue
+			}
+
+			log.Printf("Running migration %s", migration.ID)
+			started := time.Now()
+			if err := migration.Migrate(conn); err != nil {
+				return fmt.Errorf("migration %s: %w", migration.ID, err)
+			}
+
+			if err := conn.Create(&schemaMigration{ID: migration.ID, AppliedAt: time.Now()}).Error; err != nil {
+				return err
+			}
+			log.Printf("Migration %s done in %s", migration.ID, time.Since(started).Round(time.Millisecond))
+		}
+
+		return nil
+	})
+}
+
+// CreateIndexConcurrently builds an index without blocking writes to the
+// table. A failed concurrent build leaves an invalid index behind, which is
+// dropped first so the build can be retried.
+func CreateIndexConcurrently(db *gorm.DB, name, table, definition string) error {
+	var invalid bool
+	err := db.Raw(`SELECT NOT i.indisvalid FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		WHERE c.relname = ?`, name).Scan(&invalid).Error
+	if err != nil {
+		return err
+	}
+
+	if invalid {
+		if err := db.Exec(fmt.Sprintf("DROP INDEX CONCURRENTLY IF EXISTS %s", name)).Error; err != nil {
+			return err
+		}
+	}
+
+	return db.Exec(fmt.Sprintf("CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s %s", name, table, definition)).Error
+}
+
+// BackfillInBatches runs "UPDATE table SET set" over rows matching where,
+// batchSize rows at a time, until no rows match. Each batch is its own short
+// statement so locks are only held briefly. where must stop matching a row
+// once it's been updated; args fill the placeholders in set, then in where.
+func BackfillInBatches(db *gorm.DB, table, set, where string, batchSize int, args ...interface{}) error {
+	query := fmt.Sprintf("UPDATE %s SET %s WHERE id IN (SELECT id FROM %s WHERE %s LIMIT %d)",
+		table, set, table, where, batchSize)
+
+	var total int64
+	for {
+		result := db.Exec(query, args...)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			break
+		}
+
+		total += result.RowsAffected
+		log.Printf("Backfilled %d rows of %s", total, table)
+		time.Sleep(BackfillPause)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// Retries for saves that hit a transient failure, with the backoff doubling
+// after each attempt
+var (
+	saveRetries      = config.ConfigInt("DB_SAVE_RETRIES", 3)
+	saveRetryBackoff = time.Duration(config.ConfigInt("DB_SAVE_RETRY_BACKOFF_MS", 100)) * time.Millisecond
+)
+
+// retryableStates are Postgres error codes for failures that can succeed
+// on a second attempt, as opposed to e.g. constraint violations
+var retryableStates = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections
+	"57P01": true, // admin_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// IsRetryableError reports whether a database error is likely to be a blip,
+// such as a dropped connection, rather than a problem with the query itself
+func IsRetryableError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// Class 08 is connection exceptions
+		return retryableStates[pgErr.Code] || pgErr.Code[:2] == "08"
+	}
+
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) ||
+		pgconn.SafeToRetry(err) ||
+		pgconn.Timeout(err)
+}
+
+// WithRetry runs save, running it again with backoff while it fails with a
+// retryable error. save must be safe to repeat, e.g. a single transaction.
+func WithRetry(save func() error) error {
+	backoff := saveRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := save()
+		if err == nil || attempt >= saveRetries || !IsRetryableError(err) {
+			return err
+		}
+
+		log.Printf("Retrying database save after transient error (attempt %d of %d): %v", attempt+1, saveRetries, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/auth"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errLastAdmin = errors.New("cannot demote the last admin")
+
+func GetMaintenanceMode(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Maintenance mode status",
+		"data":    fiber.Map{"read_only": middleware.IsReadOnly()},
+	})
+}
+
+func SetMaintenanceMode(c *fiber.Ctx) error {
+	type MaintenanceInput struct {
+		ReadOnly *bool `json:"read_only"`
+	}
+
+	var input MaintenanceInput
+	if err := c.BodyParser(&input); err != nil || input.ReadOnly == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "read_only is required",
+			"data":    nil,
+		})
+	}
+
+	if err := recordAudit(middleware.DB(c).WithContext(c.UserContext()), c, auditActor(c), models.AuditMaintenanceSet, fmt.Sprintf("read_only:%t", *input.ReadOnly)); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to change maintenance mode",
+			"data":    nil,
+		})
+	}
+	middleware.SetReadOnly(*input.ReadOnly)
+
+	message := "Maintenance mode disabled"
+	if *input.ReadOnly {
+		message = "Maintenance mode enabled, mutations are rejected"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": message,
+		"data":    fiber.Map{"read_only": *input.ReadOnly},
+	})
+}
+
+// RotateJWTSecret switches token signing to a new secret. Tokens signed with
+// the previous secrets stay valid until they expire. The secrets are stored in
+// the settings table, so they survive restarts and other instances switch on
+// their next settings refresh.
+func RotateJWTSecret(c *fiber.Ctx) error {
+	type RotateSecretInput struct {
+		Secret string `json:"secret"`
+	}
+
+	var input RotateSecretInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if len(input.Secret) < auth.MinSecretLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": auth.ErrSecretTooShort.Error(),
+			"data":    nil,
+		})
+	}
+
+	// The rotation is only applied once it's stored and on record. Locking
+	// the row keeps concurrent rotations from dropping one another's secret.
+	var rotated storedSecrets
+	err := middleware.DB(c).WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		var stored storedSecrets
+		var row models.Setting
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", settingJWTSecrets).Take(&row).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			stored.Current, stored.Previous = auth.Secrets()
+		case err != nil:
+			return err
+		default:
+			if err := json.Unmarshal([]byte(row.Value), &stored); err != nil {
+				return err
+			}
+		}
+
+		rotated.Current, rotated.Previous, err = auth.RotatedSecrets(stored.Current, stored.Previous, input.Secret)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(rotated)
+		if err != nil {
+			return err
+		}
+
+		row = models.Setting{Key: settingJWTSecrets, Value: string(value), UpdatedBy: auditActor(c)}
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at", "updated_by"}),
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, auditActor(c), models.AuditJWTRotate, "jwt_secret")
+	})
+	if err != nil {
+		log.Printf("Failed to rotate JWT secret: %v", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to rotate JWT secret",
+			"data":    nil,
+		})
+	}
+
+	auth.SetSecrets(rotated.Current, rotated.Previous)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "JWT secret rotated",
+		"data":    nil,
+	})
+}
+
+// SetUserRole promotes or demotes a user. The last remaining admin can't be
+// demoted, so the service always has someone able to manage it.
+func SetUserRole(c *fiber.Ctx) error {
+	type RoleInput struct {
+		Role string `json:"role"`
+	}
+
+	var input RoleInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if !slices.Contains(models.Roles, input.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("role must be one of: %s", strings.Join(models.Roles, ", ")),
+			"data":    nil,
+		})
+	}
+
+	var user models.User
+	err := middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, c.Params("id")).Error; err != nil {
+			return err
+		}
+
+		if user.Role == models.RoleAdmin && input.Role != models.RoleAdmin {
+			// Lock every admin row so two concurrent demotions can't both
+			// see another admin left
+			var admins []uint
+			if err := tx.Raw("SELECT id FROM users WHERE role = ? AND deleted_at IS NULL FOR UPDATE", models.RoleAdmin).Scan(&admins).Error; err != nil {
+				return err
+			}
+			if len(admins) <= 1 {
+				return errLastAdmin
+			}
+		}
+
+		if err := tx.Model(&user).Update("role", input.Role).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, auditActor(c), models.AuditRoleChange, fmt.Sprintf("user:%d role:%s", user.ID, input.Role))
+	})
+
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "User not found",
+			"data":    nil,
+		})
+	case errors.Is(err, errLastAdmin):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Cannot demote the last admin",
+			"data":    nil,
+		})
+	case err != nil:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update role",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Role updated",
+		"data":    fiber.Map{"id": user.ID, "username": user.Username, "role": user.Role},
+	})
+}
@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/auth"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+)
+
+const (
+	MaxAPIKeysPerUser = 10
+	maxAPIKeyName     = 100
+)
+
+// CreateAPIKey issues a new API key for the logged in user. The key is only
+// returned here; afterwards just its prefix is shown.
+func CreateAPIKey(c *fiber.Ctx) error {
+	type APIKeyInput struct {
+		Name string `json:"name"`
+	}
+
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input APIKeyInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if len(input.Name) > maxAPIKeyName {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("name must be at most %d characters", maxAPIKeyName),
+			"data":    nil,
+		})
+	}
+
+	db := middleware.DB(c)
+
+	var active int64
+	if err := db.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Count(&active).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create API key",
+			"data":    nil,
+		})
+	}
+	if active >= MaxAPIKeysPerUser {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("At most %d active API keys are allowed, revoke one first", MaxAPIKeysPerUser),
+			"data":    nil,
+		})
+	}
+
+	key, apiKey, err := auth.NewAPIKey(userID, input.Name)
+	if err == nil {
+		err = db.Create(&apiKey).Error
+	}
+	if err == nil {
+		err = recordAudit(db, c, &userID, models.AuditAPIKeyCreate, fmt.Sprintf("api_key:%d", apiKey.ID))
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to create API key",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"status":  "success",
+		"message": "API key created, store it now as it won't be shown again",
+		"data": fiber.Map{
+			"id":         apiKey.ID,
+			"name":       apiKey.Name,
+			"key":        key,
+			"prefix":     apiKey.Prefix,
+			"created_at": apiKey.CreatedAt,
+		},
+	})
+}
+
+// ListAPIKeys lists the logged in user's API keys, including revoked ones
+func ListAPIKeys(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var keys []models.APIKey
+	if err := middleware.DB(c).Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to fetch API keys",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "API keys fetched",
+		"data":    keys,
+	})
+}
+
+// RevokeAPIKey revokes one of the logged in user's API keys. Revoked keys are
+// kept so their last use stays visible.
+func RevokeAPIKey(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	db := middleware.DB(c)
+	result := db.Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", c.Params("id"), userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to revoke API key",
+			"data":    nil,
+		})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "API key not found",
+			"data":    nil,
+		})
+	}
+
+	if err := recordAudit(db, c, &userID, models.AuditAPIKeyRevoke, fmt.Sprintf("api_key:%s", c.Params("id"))); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to revoke API key",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "API key revoked",
+		"data":    nil,
+	})
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+// recordAudit writes an audit entry using db, which may be a transaction so
+// the entry only lands if the audited change does
+func recordAudit(db *gorm.DB, c *fiber.Ctx, actorID *uint, action, target string) error {
+	return db.Create(&models.AuditLog{
+		ActorID: actorID,
+		Action:  action,
+		Target:  target,
+		IP:      c.IP(),
+	}).Error
+}
+
+// auditActor returns the logged in user for an audit entry on an
+// authenticated route
+func auditActor(c *fiber.Ctx) *uint {
+	if userID, err := middleware.CheckUserLoggedIn(c); err == nil {
+		return &userID
+	}
+	return nil
+}
+
+// GetAuditLogs lists audit entries, newest first, filtered by actor_id,
+// action and since (RFC 3339)
+func GetAuditLogs(c *fiber.Ctx) error {
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return paginationError(c, err)
+	}
+
+	db := database.GetDB()
+	query := db.Model(&models.AuditLog{})
+	if actorID := c.QueryInt("actor_id"); actorID > 0 {
+		query = query.Where("actor_id = ?", actorID)
+	}
+	if action := c.Query("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+	if since := c.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "since must be an RFC 3339 timestamp",
+				"data":    nil,
+			})
+		}
+		query = query.Where("created_at >= ?", t)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	var entries []models.AuditLog
+	if err := pagination.apply(query.Order("created_at DESC, id DESC")).Find(&entries).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Audit log retrieved",
+		"data": fiber.Map{
+			"entries":    entries,
+			"pagination": pagination.meta(total),
+		},
+	})
+}
@@ -2,6 +2,8 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"log"
 	"strconv"
 	"time"
 
@@ -10,8 +12,8 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/krishkalaria12/snap-serve/auth"
 	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
 	"github.com/krishkalaria12/snap-serve/models"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 	"net/mail"
 )
@@ -45,9 +47,19 @@ func getUserByUsername(username string) (*models.User, error) {
 	return &user, nil
 }
 
-func checkPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+// rehashPassword upgrades a user's stored hash to the configured algorithm
+// after a successful login. Failing to do so only delays the upgrade to the
+// next login, so errors are logged rather than failing the request.
+func rehashPassword(db *gorm.DB, user *models.User, password string) {
+	hash, err := hashPassword(password)
+	if err != nil {
+		log.Printf("Failed to rehash password of user %d: %v", user.ID, err)
+		return
+	}
+
+	if err := db.Model(user).Update("password", hash).Error; err != nil {
+		log.Printf("Failed to store rehashed password of user %d: %v", user.ID, err)
+	}
 }
 
 
@@ -75,26 +87,10 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	// Validate credentials using auth service  
-	valid, err := auth.ValidateUserCredentials(input.Identity, input.Password)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"message": "Database error",
-			"status":  "error",
-			"data":    nil,
-		})
-	}
-
-	if !valid {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Invalid identity or password",
-			"status":  "error",
-			"data":    nil,
-		})
-	}
-
-	// Get user model for response
+	// Look the user up once and verify the password once, so the result also
+	// decides whether the stored hash needs upgrading
 	var userModel *models.User
+	var err error
 	if isEmail(input.Identity) {
 		userModel, err = getUserByEmail(input.Identity)
 	} else {
@@ -109,21 +105,24 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
-	if userModel == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"message": "Invalid identity or password",
-			"status":  "error",
-			"data":    nil,
-		})
+	var valid, needsRehash bool
+	if userModel != nil {
+		valid, needsRehash = auth.VerifyPassword(input.Password, userModel.Password)
 	}
 
-	if !checkPasswordHash(input.Password, userModel.Password) {
+	if !valid {
+		if err := recordAudit(middleware.DB(c), c, nil, models.AuditLoginFailed, "identity:"+input.Identity); err != nil {
+			log.Printf("Failed to record audit entry: %v", err)
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"status":  "error",
 			"message": "Invalid identity or password",
+			"status":  "error",
 			"data":    nil,
 		})
 	}
+	if needsRehash {
+		rehashPassword(middleware.DB(c), userModel, input.Password)
+	}
 
 	// Create JWT token using go-pkgz/auth
 	user := token.User{
@@ -157,6 +156,10 @@ func Login(c *fiber.Ctx) error {
 		})
 	}
 
+	if err := recordAudit(middleware.DB(c), c, &userModel.ID, models.AuditLogin, fmt.Sprintf("user:%d", userModel.ID)); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+
 	// Set JWT cookie (optional, for web clients)
 	c.Cookie(&fiber.Cookie{
 		Name:     "JWT",
@@ -198,3 +201,66 @@ func Logout(c *fiber.Ctx) error {
 		"data":    nil,
 	})
 }
+
+// VerifyToken checks a JWT and returns who it belongs to and when it expires,
+// for gateways that only need to validate a token. Tokens of users that have
+// since been deleted are rejected.
+func VerifyToken(c *fiber.Ctx) error {
+	tokenStr := middleware.TokenFromRequest(c)
+	if tokenStr == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Token is required",
+			"status":  "error",
+			"data":    nil,
+		})
+	}
+
+	claims, err := auth.ParseToken(tokenStr)
+	if err != nil || claims.User == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"message": "Invalid token",
+			"status":  "error",
+			"data":    nil,
+		})
+	}
+
+	var userModel models.User
+	if err := database.GetDB().First(&userModel, claims.User.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"message": "Invalid token",
+				"status":  "error",
+				"data":    nil,
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"message": "Database error",
+			"status":  "error",
+			"data":    nil,
+		})
+	}
+
+	data := fiber.Map{
+		"user": fiber.Map{
+			"id":       userModel.ID,
+			"username": userModel.Username,
+			"email":    userModel.Email,
+			"name":     userModel.FullName,
+			"role":     userModel.Role,
+		},
+		"issuer":   claims.Issuer,
+		"audience": claims.Audience,
+	}
+	if claims.ExpiresAt != nil {
+		data["expires_at"] = claims.ExpiresAt.UTC()
+	}
+	if claims.IssuedAt != nil {
+		data["issued_at"] = claims.IssuedAt.UTC()
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"message": "Token is valid",
+		"status":  "success",
+		"data":    data,
+	})
+}
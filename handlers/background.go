@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// jpegBackground is what transparent areas are flattened onto when an image
+// is encoded to JPEG, which has no alpha channel. Without it they'd come out
+// black. Set with JPEG_BACKGROUND as a hex color, white by default.
+var jpegBackground = loadJPEGBackground()
+
+func loadJPEGBackground() color.Color {
+	background, err := parseHexColor(config.ConfigDefault("JPEG_BACKGROUND", "ffffff"))
+	if err != nil {
+		log.Fatalf("Invalid JPEG_BACKGROUND: %v", err)
+	}
+	return background
+}
+
+// backgroundFilter composites the image over a solid color, leaving it fully
+// opaque
+type backgroundFilter struct {
+	color color.Color
+}
+
+func (f backgroundFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f backgroundFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(f.color), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Over)
+}
+
+// flattenForJPEG puts images with any transparency onto jpegBackground.
+// Opaque images are returned as they are.
+func flattenForJPEG(img image.Image) image.Image {
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && opaque.Opaque() {
+		return img
+	}
+	return drawFilters(img, []gift.Filter{backgroundFilter{color: jpegBackground}})
+}
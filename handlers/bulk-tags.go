@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm/clause"
+)
+
+// BulkTagRequest adds and removes tags on many images at once
+type BulkTagRequest struct {
+	ImageIDs []uint   `json:"image_ids"`
+	Add      []string `json:"add"`
+	Remove   []string `json:"remove"`
+}
+
+// BulkTagResult is the outcome for one image of a bulk tag request
+type BulkTagResult struct {
+	ID     uint        `json:"id"`
+	Status string      `json:"status"`
+	Tags   models.Tags `json:"tags,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// Statuses of a BulkTagResult
+const (
+	BulkTagUpdated   = "updated"
+	BulkTagUnchanged = "unchanged"
+	BulkTagNotFound  = "not_found"
+	BulkTagFailed    = "failed"
+)
+
+// applyTags returns tags with add appended and remove taken out
+func applyTags(tags, add, remove models.Tags) models.Tags {
+	result := models.Tags{}
+	for _, tag := range append(slices.Clone(tags), add...) {
+		if !slices.Contains(remove, tag) && !slices.Contains(result, tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// BulkTagImages adds and removes tags on a list of the user's images in one
+// transaction. Images that aren't found or would end up with too many tags
+// are reported per ID without affecting the others.
+func BulkTagImages(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input BulkTagRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if len(input.ImageIDs) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "image_ids is required",
+			"data":    nil,
+		})
+	}
+
+	slices.Sort(input.ImageIDs)
+	ids := slices.Compact(input.ImageIDs)
+	if len(ids) > maxBatchSize() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("too many images (max %d)", maxBatchSize()),
+			"data":    nil,
+		})
+	}
+
+	add, err := normalizeTags(input.Add)
+	var remove models.Tags
+	if err == nil {
+		remove, err = normalizeTags(input.Remove)
+	}
+	if err == nil && len(add) == 0 && len(remove) == 0 {
+		err = fmt.Errorf("nothing to update, expected add or remove")
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	db := middleware.DB(c)
+
+	// Lock the rows so concurrent tag edits can't overwrite each other
+	var images []models.Image
+	if err := db.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ? AND user_id = ?", ids, userID).
+		Find(&images).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	byID := make(map[uint]*models.Image, len(images))
+	for i := range images {
+		byID[images[i].ID] = &images[i]
+	}
+
+	results := make([]BulkTagResult, 0, len(ids))
+	updated := 0
+	for _, id := range ids {
+		img := byID[id]
+		if img == nil {
+			results = append(results, BulkTagResult{ID: id, Status: BulkTagNotFound, Error: "Image not found"})
+			continue
+		}
+
+		tags := applyTags(img.Tags, add, remove)
+		if len(tags) > MaxTags {
+			results = append(results, BulkTagResult{ID: id, Status: BulkTagFailed, Tags: img.Tags, Error: fmt.Sprintf("too many tags (max %d)", MaxTags)})
+			continue
+		}
+		if slices.Equal(tags, img.Tags) {
+			results = append(results, BulkTagResult{ID: id, Status: BulkTagUnchanged, Tags: tags})
+			continue
+		}
+
+		if err := db.Model(img).Update("tags", tags).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to update image tags",
+				"data":    nil,
+			})
+		}
+		results = append(results, BulkTagResult{ID: id, Status: BulkTagUpdated, Tags: tags})
+		updated++
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Tags updated on %d of %d images", updated, len(ids)),
+		"data": fiber.Map{
+			"results": results,
+		},
+	})
+}
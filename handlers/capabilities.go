@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// formatSignatures are the leading bytes of image formats a decoder may be
+// registered for
+var formatSignatures = map[string]string{
+	"jpeg": "\xff\xd8\xff",
+	"png":  "\x89PNG\r\n\x1a\n",
+	"gif":  "GIF89a",
+	"webp": "RIFF\x00\x00\x00\x00WEBPVP8",
+	"bmp":  "BM",
+	"tiff": "II*\x00",
+}
+
+// outputFormats are the formats processed images are encoded to, less any
+// whose content type ALLOWED_CONTENT_TYPES leaves out
+var outputFormats = allowedFormats(FormatJPEG, FormatWebP, FormatPNG)
+
+// inputFormats lists the formats a decoder is compiled in for. A format
+// without a decoder fails with image.ErrFormat, while a registered one only
+// fails on the truncated header.
+func inputFormats() []string {
+	formats := []string{}
+	for format, signature := range formatSignatures {
+		_, _, err := image.DecodeConfig(bytes.NewReader([]byte(signature)))
+		if !errors.Is(err, image.ErrFormat) {
+			formats = append(formats, format)
+		}
+	}
+	sort.Strings(formats)
+
+	return formats
+}
+
+// GetCapabilities describes what this deployment supports, so clients can
+// adapt instead of assuming
+func GetCapabilities(c *fiber.Ctx) error {
+	filters := make([]string, 0, len(supportedFilters))
+	for name := range supportedFilters {
+		filters = append(filters, name)
+	}
+	sort.Strings(filters)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Server capabilities",
+		"data": fiber.Map{
+			"input_formats":    inputFormats(),
+			"output_formats":   outputFormats,
+			"filters":          filters,
+			"max_width":        maxImageWidth(),
+			"max_height":       maxImageHeight(),
+			"max_batch_size":   maxBatchSize(),
+			"max_upload_bytes": MaxUploadBytes,
+			"generation": fiber.Map{
+				"enabled":        generationConfigured(),
+				"daily_limit":    dailyGenerationLimit(),
+				"max_variations": MaxGenerationVariations,
+			},
+		},
+	})
+}
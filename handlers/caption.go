@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/disintegration/gift"
+)
+
+const (
+	MaxCaptionLength = 100
+	MinCaptionSize   = 8
+	MaxCaptionSize   = 200
+)
+
+// captionFilter burns a line of text, such as a date or a description, into
+// a corner of the image. Unlike the watermark it's drawn at a fixed size and
+// fully opaque so it stays readable.
+type captionFilter struct {
+	text     string
+	position string
+	color    color.Color
+	size     float64
+}
+
+// parseCaption reads a caption in the form 'position:color:size:text'. The
+// text comes last so it can contain colons, e.g. a time of day.
+func parseCaption(param string) (*captionFilter, error) {
+	parts := strings.SplitN(param, ":", 4)
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("value must be in format 'position:color:size:text'")
+	}
+
+	if !overlayPositions[parts[0]] {
+		return nil, fmt.Errorf("position must be top-left, top-right, bottom-left, bottom-right or center")
+	}
+
+	col, err := parseHexColor(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := parseIntParam(parts[2], "size")
+	if err != nil {
+		return nil, err
+	}
+	if size < MinCaptionSize || size > MaxCaptionSize {
+		return nil, fmt.Errorf("size must be between %d and %d", MinCaptionSize, MaxCaptionSize)
+	}
+
+	text := sanitizeCaption(parts[3])
+	if text == "" {
+		return nil, fmt.Errorf("text is required")
+	}
+	if len([]rune(text)) > MaxCaptionLength {
+		return nil, fmt.Errorf("text too long (max %d characters)", MaxCaptionLength)
+	}
+
+	return &captionFilter{
+		text:     text,
+		position: parts[0],
+		color:    col,
+		size:     float64(size),
+	}, nil
+}
+
+// sanitizeCaption collapses whitespace and drops control characters, which
+// the font can't draw anyway
+func sanitizeCaption(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// parseHexColor reads a color written as 'rrggbb' or 'rgb', without the
+// leading '#' since that would end the query string
+func parseHexColor(value string) (color.Color, error) {
+	value = strings.TrimPrefix(value, "#")
+	if len(value) == 3 {
+		value = string([]byte{value[0], value[0], value[1], value[1], value[2], value[2]})
+	}
+
+	if len(value) != 6 {
+		return nil, fmt.Errorf("color must be a hex value like 'ffffff'")
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("color must be a hex value like 'ffffff'")
+	}
+
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 255}, nil
+}
+
+func (f *captionFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f *captionFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	caption, err := renderText(f.text, f.size, f.color)
+	if err != nil {
+		return
+	}
+
+	bounds := dst.Bounds()
+	margin := min(bounds.Dx(), bounds.Dy()) / 50
+	rect := placeOverlay(bounds, caption.Bounds().Size(), f.position, margin)
+	drawOverlay(dst, rect, caption, 1)
+}
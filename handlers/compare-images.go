@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+type CompareRequest struct {
+	ImageUrl string            `json:"image_url"`
+	Filters  []FilterOperation `json:"filters"`
+}
+
+// sideBySide places before and after next to each other on a white canvas,
+// scaling before to the height of after so the pair lines up
+func sideBySide(before, after image.Image) image.Image {
+	height := after.Bounds().Dy()
+	if before.Bounds().Dy() != height {
+		g := gift.New(gift.Resize(0, height, gift.LanczosResampling))
+		scaled := image.NewRGBA(g.Bounds(before.Bounds()))
+		g.Draw(scaled, before)
+		before = scaled
+	}
+
+	beforeWidth := before.Bounds().Dx()
+	canvas := image.NewRGBA(image.Rect(0, 0, beforeWidth+after.Bounds().Dx(), height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(canvas, image.Rect(0, 0, beforeWidth, height), before, before.Bounds().Min, draw.Over)
+	draw.Draw(canvas, image.Rect(beforeWidth, 0, canvas.Bounds().Dx(), height), after, after.Bounds().Min, draw.Over)
+
+	return canvas
+}
+
+// storeComparisonImage encodes, uploads and records one image of a comparison
+func storeComparisonImage(ctx context.Context, img image.Image, opts OutputOptions, filename string, sourceID, userID uint) (UploadResult, error) {
+	var reader *bytes.Reader
+	var err error
+	processingPool.run(func() {
+		reader, err = tracedEncodeImage(ctx, img, opts)
+	})
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	url, attrs, err := uploader.UploadProcessedFile(ctx, reader, filename, ObjectMetadata{
+		OwnerID:       userID,
+		Source:        SourceCompare,
+		SourceImageID: sourceID,
+	})
+	if err != nil {
+		return UploadResult{}, err
+	}
+
+	result := UploadResult{
+		URL:        url,
+		Filename:   filename,
+		ObjectPath: attrs.Name,
+		Size:       attrs.Size,
+		Width:      img.Bounds().Dx(),
+		Height:     img.Bounds().Dy(),
+		Format:     opts.Format,
+		Source:     SourceCompare,
+		SourceID:   sourceID,
+	}
+	return result, uploadImageToDB(ctx, result, userID)
+}
+
+// CompareImage processes one stored image and returns the original URL next
+// to the processed result, plus a single side-by-side image when
+// ?composite=true, for filter preview UIs
+func CompareImage(c *fiber.Ctx) error {
+	userId, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input CompareRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if input.ImageUrl == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "image_url is required",
+			"data":    nil,
+		})
+	}
+
+	var filters []gift.Filter
+	if len(input.Filters) > 0 {
+		filters, err = parseFilterOperations(input.Filters)
+	} else {
+		filters, err = queryFilters(c)
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	filters = withWatermark(filters, c.Query("watermark"))
+
+	outputOpts, err := parseOutputOptions(c.Queries())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	original, err := loadImage(c.UserContext(), input.ImageUrl)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Failed to load image: %v", err),
+			"data":    nil,
+		})
+	}
+
+	var processed image.Image
+	processingPool.run(func() {
+		processed, err = processImage(original.Image, filters)
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to process image",
+			"data":    nil,
+		})
+	}
+
+	timestamp := time.Now().UnixNano()
+	result, err := storeComparisonImage(c.UserContext(), processed, outputOpts, fmt.Sprintf("compare_%d%s", timestamp, outputOpts.extension()), original.SourceID, userId)
+	if err != nil {
+		return storageErrorResponse(c, err, "Failed to store processed image")
+	}
+
+	data := fiber.Map{
+		"original_url":  input.ImageUrl,
+		"processed_url": clientURL(result.URL),
+		"width":         result.Width,
+		"height":        result.Height,
+		"format":        result.Format,
+	}
+
+	if c.QueryBool("composite") {
+		var composite image.Image
+		processingPool.run(func() {
+			composite = sideBySide(original.Image, processed)
+		})
+
+		compositeResult, err := storeComparisonImage(c.UserContext(), composite, outputOpts, fmt.Sprintf("compare_%d_side_by_side%s", timestamp, outputOpts.extension()), original.SourceID, userId)
+		if err != nil {
+			return storageErrorResponse(c, err, "Failed to store comparison image")
+		}
+		data["composite_url"] = clientURL(compositeResult.URL)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Comparison created",
+		"data":    data,
+	})
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Values of ?disposition= on download endpoints
+const (
+	DispositionAttachment = "attachment"
+	DispositionInline     = "inline"
+)
+
+// contentTypeExtensions are the file extensions downloads of each content
+// type are saved with
+var contentTypeExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/tiff": ".tiff",
+}
+
+// contentTypeOf is the content type of a file named with one of
+// contentTypeExtensions, or "" to let storage detect it from the content
+func contentTypeOf(filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	for contentType, known := range contentTypeExtensions {
+		if known == ext {
+			return contentType
+		}
+	}
+	return ""
+}
+
+// parseDisposition validates ?disposition=, which defaults to attachment
+func parseDisposition(param string) (string, error) {
+	switch param {
+	case "", DispositionAttachment:
+		return DispositionAttachment, nil
+	case DispositionInline:
+		return DispositionInline, nil
+	}
+	return "", fmt.Errorf("disposition must be %s or %s", DispositionAttachment, DispositionInline)
+}
+
+// downloadFilename is the name a stored image is saved as: its original
+// filename, with the extension corrected when the stored bytes were
+// converted to another format
+func downloadFilename(filename, contentType string) string {
+	filename = strings.TrimSpace(path.Base(strings.ReplaceAll(filename, `\`, "/")))
+	if filename == "." || filename == "/" {
+		filename = ""
+	}
+
+	ext, known := contentTypeExtensions[contentType]
+	if !known {
+		return filename
+	}
+
+	base := strings.TrimSuffix(filename, path.Ext(filename))
+	if base == "" {
+		base = "image"
+	}
+	if current := strings.ToLower(path.Ext(filename)); current == ext || (ext == ".jpg" && current == ".jpeg") {
+		return filename
+	}
+	return base + ext
+}
+
+// contentDisposition builds a Content-Disposition header with a quoted,
+// escaped filename. Names with non-ASCII characters get an ASCII fallback
+// plus the full name RFC 5987 encoded as filename*, which browsers prefer.
+func contentDisposition(disposition, filename string) string {
+	if filename == "" {
+		return disposition
+	}
+
+	var fallback, encoded strings.Builder
+	needsEncoding := false
+	for _, r := range filename {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			// Control characters can't appear in a header at all
+			continue
+		case r > 0x7e:
+			needsEncoding = true
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+
+	header := fmt.Sprintf(`%s; filename="%s"`, disposition, fallback.String())
+	if !needsEncoding {
+		return header
+	}
+
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else if b >= 0x20 && b != 0x7f {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return header + "; filename*=UTF-8''" + encoded.String()
+}
+
+// isAttrChar reports whether b may appear unencoded in an RFC 5987 value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"slices"
+	"strings"
+
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// rasterContentTypes maps each raster format the service can decode or
+// encode to its content type. These are the only types images are ever
+// accepted or served as: SVG and other markup based formats can carry
+// script, so they're not in the list and can't be allowed by configuration.
+var rasterContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+	"tiff": "image/tiff",
+	"bmp":  "image/bmp",
+}
+
+// allowedContentTypes narrows rasterContentTypes to the comma separated
+// ALLOWED_CONTENT_TYPES, e.g. "image/jpeg,image/png". Unset allows them all.
+var allowedContentTypes = loadAllowedContentTypes()
+
+func loadAllowedContentTypes() []string {
+	raw := config.ConfigDefault("ALLOWED_CONTENT_TYPES", "")
+	if strings.TrimSpace(raw) == "" {
+		var all []string
+		for _, contentType := range rasterContentTypes {
+			all = append(all, contentType)
+		}
+		slices.Sort(all)
+		return all
+	}
+
+	var allowed []string
+	for _, field := range strings.Split(raw, ",") {
+		contentType := strings.ToLower(strings.TrimSpace(field))
+		if contentType == "" {
+			continue
+		}
+		if !isRasterContentType(contentType) {
+			log.Fatalf("Invalid ALLOWED_CONTENT_TYPES: %q is not a supported raster image type", contentType)
+		}
+		allowed = append(allowed, contentType)
+	}
+	if len(allowed) == 0 {
+		log.Fatalf("Invalid ALLOWED_CONTENT_TYPES: no content types given")
+	}
+
+	slices.Sort(allowed)
+	return slices.Compact(allowed)
+}
+
+func isRasterContentType(contentType string) bool {
+	for _, known := range rasterContentTypes {
+		if known == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// contentTypeAllowed reports whether content of this type may be stored or
+// served as an image. Parameters such as charset are ignored.
+func contentTypeAllowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(allowedContentTypes, mediaType)
+}
+
+// formatAllowed reports whether images in a decoded or output format, as
+// named by the image package, are allowed
+func formatAllowed(format string) bool {
+	contentType, ok := rasterContentTypes[format]
+	return ok && contentTypeAllowed(contentType)
+}
+
+// allowedFormats filters formats down to the allowed ones
+func allowedFormats(formats ...string) []string {
+	var allowed []string
+	for _, format := range formats {
+		if formatAllowed(format) {
+			allowed = append(allowed, format)
+		}
+	}
+	return allowed
+}
+
+// errUnsupportedImage marks an upload that isn't a decodable image of an
+// allowed type
+var errUnsupportedImage = errors.New("unsupported image type")
+
+// checkUploadFormat validates the result of decoding an upload
+func checkUploadFormat(format string, decodeErr error) error {
+	if errors.Is(decodeErr, errImageTooLarge) {
+		return decodeErr
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("%w: %v", errUnsupportedImage, decodeErr)
+	}
+	if !formatAllowed(format) {
+		return fmt.Errorf("%w: %s images are not accepted", errUnsupportedImage, format)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/png"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+var (
+	keepUploadOriginals   = config.ConfigBool("UPLOAD_KEEP_ORIGINAL", false)
+	filterGeneratedImages = config.ConfigBool("GENERATION_DEFAULT_FILTERS", false)
+)
+
+// Default filters are an org-wide policy applied to every stored upload,
+// written in the same syntax as the filter endpoint's query string,
+// e.g. UPLOAD_DEFAULT_FILTERS="resize=2000x0". They're the
+// upload_default_filters runtime setting.
+func defaultUploadFilters() []gift.Filter {
+	return currentSetting(SettingUploadDefaultFilters).parsed.([]gift.Filter)
+}
+
+// parseFilterQuery parses a filter chain written as a query string
+func parseFilterQuery(raw string) ([]gift.Filter, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make(map[string]string, len(values))
+	for name := range values {
+		params[name] = values.Get(name)
+	}
+
+	return parseFilters(params)
+}
+
+// filterImage runs a default filter chain over an image. Re-encoding also
+// drops any metadata carried by the original file.
+func filterImage(ctx context.Context, src image.Image, filters []gift.Filter) (reader *bytes.Reader, err error) {
+	processingPool.run(func() {
+		var processed image.Image
+		processed, err = processImage(src, filters)
+		if err != nil {
+			return
+		}
+		reader, err = tracedEncodeImage(ctx, processed, defaultOutputOptions())
+	})
+
+	return reader, err
+}
+
+func withExtension(filename, ext string) string {
+	return strings.TrimSuffix(filename, filepath.Ext(filename)) + ext
+}
+
+// storeUploadedFile uploads a user's file, applying the default filters first
+// when a policy is configured. An empty visibility leaves the objects' access
+// to the bucket.
+func storeUploadedFile(ctx context.Context, file io.ReadSeeker, filename string, userID uint, source, visibility string) (UploadResult, error) {
+	metadata := ObjectMetadata{OwnerID: userID, Source: source, OriginalFilename: filename, Visibility: visibility}
+
+	// Decode once up front so the perceptual hash comes from the original
+	// pixels. Anything that isn't a raster image of an allowed type, SVG
+	// included, is rejected before it reaches storage.
+	var src image.Image
+	var format, phash, placeholder string
+	var err error
+	processingPool.run(func() {
+		src, format, err = tracedDecodeImage(ctx, file)
+		if err = checkUploadFormat(format, err); err != nil {
+			return
+		}
+		phash = perceptualHash(src)
+		placeholder = blurHash(src)
+	})
+	if err != nil {
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	filters := defaultUploadFilters()
+	if len(filters) == 0 {
+		url, attrs, err := uploader.UploadFile(ctx, file, filename, metadata)
+		if err != nil {
+			return UploadResult{Filename: filename, Error: err}, err
+		}
+		return UploadResult{
+			URL:        url,
+			Filename:   filename,
+			ObjectPath: attrs.Name,
+			Size:       attrs.Size,
+			PHash:      phash,
+			BlurHash:   placeholder,
+			Source:     source,
+		}, nil
+	}
+
+	processed, err := filterImage(ctx, src, filters)
+	if err != nil {
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	processedName := withExtension(filename, defaultOutputOptions().extension())
+	processedURL, processedAttrs, err := uploader.UploadProcessedFile(ctx, processed, processedName, metadata)
+	if err != nil {
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	if !keepUploadOriginals {
+		return UploadResult{
+			URL:        processedURL,
+			Filename:   processedName,
+			ObjectPath: processedAttrs.Name,
+			Size:       processedAttrs.Size,
+			PHash:      phash,
+			BlurHash:   placeholder,
+			Source:     source,
+		}, nil
+	}
+
+	// Without the original the processed copy would never get a record
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		deleteUploads([]UploadResult{{ObjectPath: processedAttrs.Name}})
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	originalURL, originalAttrs, err := uploader.UploadFile(ctx, file, filename, metadata)
+	if err != nil {
+		deleteUploads([]UploadResult{{ObjectPath: processedAttrs.Name}})
+		return UploadResult{Filename: filename, Error: err}, err
+	}
+
+	return UploadResult{
+		URL:          originalURL,
+		ProcessedURL: processedURL,
+		Filename:     filename,
+		ObjectPath:   originalAttrs.Name,
+		Size:         originalAttrs.Size,
+		PHash:        phash,
+		BlurHash:     placeholder,
+		Source:       source,
+	}, nil
+}
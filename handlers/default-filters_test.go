@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestStoreUploadedFileRemovesProcessedCopyWithoutOriginal(t *testing.T) {
+	filters, err := parseFilterQuery("grayscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setSettingOverride(SettingUploadDefaultFilters, &settingValue{raw: "grayscale", parsed: filters})
+	keep, format := keepUploadOriginals, defaultOutputFormat
+	keepUploadOriginals, defaultOutputFormat = true, FormatJPEG
+	t.Cleanup(func() {
+		setSettingOverride(SettingUploadDefaultFilters, nil)
+		keepUploadOriginals, defaultOutputFormat = keep, format
+	})
+
+	// The processed copy is a JPEG, only the original PNG fails to upload
+	fs := newFakeStorage(t)
+	fs.failUpload = func(name string) bool { return strings.HasSuffix(name, ".png") }
+
+	_, err = storeUploadedFile(t.Context(), bytes.NewReader(encodePNG(t, testImage(8, 8))), "photo.png", 1, SourceUpload, "")
+	if err == nil {
+		t.Fatal("storeUploadedFile succeeded without storing the original")
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.uploads.Load() != 1 || len(fs.deleted) != 1 {
+		t.Fatalf("%d upload(s) and %d delete(s), want the processed copy stored and deleted again", fs.uploads.Load(), len(fs.deleted))
+	}
+	if len(fs.objects) != 0 {
+		t.Errorf("objects left in storage: %v", fs.objects)
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+// imageObjectPaths lists the stored objects of an image: its own and, when
+// the upload kept its original, the separate processed copy. processed_url
+// can be changed by the user, so the copy is only included when its
+// metadata says the user stored it.
+func imageObjectPaths(img models.Image, userID uint) ([]string, error) {
+	var paths []string
+	if objectPath := uploader.objectPathFor(img); objectPath != "" {
+		paths = append(paths, objectPath)
+	}
+
+	if img.ProcessedURL == "" || img.ProcessedURL == img.OriginalURL {
+		return paths, nil
+	}
+	processedPath := uploader.objectPathFromURL(img.ProcessedURL)
+	if processedPath == "" || slices.Contains(paths, processedPath) {
+		return paths, nil
+	}
+
+	attrs, err := uploader.Attrs(processedPath)
+	if errors.Is(err, ErrStorageNotFound) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if attrs.Metadata["owner-id"] == strconv.FormatUint(uint64(userID), 10) {
+		paths = append(paths, processedPath)
+	}
+	return paths, nil
+}
+
+// DeleteImage removes one of the user's images. Its objects are deleted from
+// storage first and the record is soft deleted after, so a request that
+// fails halfway can simply be repeated.
+func DeleteImage(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	objectPaths, err := imageObjectPaths(img, userID)
+	if err != nil {
+		return storageErrorResponse(c, err, "Failed to delete image")
+	}
+
+	for _, objectPath := range objectPaths {
+		// An object that's already gone was removed by an earlier attempt
+		if err := uploader.Delete(objectPath); err != nil && !errors.Is(err, ErrStorageNotFound) {
+			return storageErrorResponse(c, err, "Failed to delete image")
+		}
+	}
+
+	// Only the record and its audit entry share a transaction, storage calls
+	// above don't hold a connection
+	err = middleware.DB(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&img).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, &userID, models.AuditImageDelete, fmt.Sprintf("image:%d", img.ID))
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to delete image",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image deleted",
+		"data":    nil,
+	})
+}
@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+const deleteTestObject = "users/1/photo.png"
+
+func deleteImageApp(userID uint) *fiber.App {
+	app := fiber.New()
+	app.Delete("/image/:id", asUser(userID), DeleteImage)
+	return app
+}
+
+// expectImage expects getOwnedImage to load image 5, owned by ownerID
+func expectImage(mock sqlmock.Sqlmock, ownerID uint) {
+	mock.ExpectQuery(`SELECT \* FROM "images"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "filename", "original_url", "object_path"}).
+			AddRow(5, ownerID, "photo.png", "https://storage.googleapis.com/"+bucketName+"/"+deleteTestObject, deleteTestObject))
+}
+
+func TestDeleteImage(t *testing.T) {
+	fs := newFakeStorage(t)
+	fs.put(deleteTestObject, encodePNG(t, testImage(4, 4)), nil)
+
+	mock := newMockDB(t)
+	expectImage(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "images" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	resp, err := deleteImageApp(1).Test(httptest.NewRequest("DELETE", "/image/5", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if fs.has(deleteTestObject) {
+		t.Fatal("object is still in storage")
+	}
+}
+
+func TestDeleteImageOfAnotherUser(t *testing.T) {
+	fs := newFakeStorage(t)
+	fs.put(deleteTestObject, encodePNG(t, testImage(4, 4)), nil)
+
+	mock := newMockDB(t)
+	expectImage(mock, 1)
+
+	resp, err := deleteImageApp(2).Test(httptest.NewRequest("DELETE", "/image/5", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("status = %d, want 403", resp.StatusCode)
+	}
+	if !fs.has(deleteTestObject) || fs.requests.Load() != 0 {
+		t.Fatal("storage was touched for another user's image")
+	}
+}
+
+func TestDeleteImageNotFound(t *testing.T) {
+	fs := newFakeStorage(t)
+
+	mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "images"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	resp, err := deleteImageApp(1).Test(httptest.NewRequest("DELETE", "/image/5", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+	if fs.requests.Load() != 0 {
+		t.Fatal("storage was touched for a missing image")
+	}
+}
@@ -0,0 +1,386 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"google.golang.org/api/iterator"
+)
+
+// directUploadExpiry is how long a signed upload URL can be used
+var directUploadExpiry = time.Duration(config.ConfigInt("DIRECT_UPLOAD_URL_EXPIRY_MINUTES", 15)) * time.Minute
+
+// directUploadConfirmWindow is how long after an upload it can still be
+// confirmed. Older uploads without an image record are deleted.
+var directUploadConfirmWindow = time.Duration(config.ConfigInt("DIRECT_UPLOAD_CONFIRM_HOURS", 24)) * time.Hour
+
+// How often unconfirmed direct uploads are deleted. 0 disables the cleanup,
+// for buckets with a lifecycle rule on the direct prefix instead.
+var directUploadCleanupInterval = time.Duration(config.ConfigInt("DIRECT_UPLOAD_CLEANUP_MINUTES", 60)) * time.Minute
+
+type DirectUploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+type ConfirmUploadRequest struct {
+	ObjectPath string `json:"object_path"`
+}
+
+// directUploadPrefix is where a user's direct uploads go, so a confirmation
+// can only claim objects issued to the same user
+func (c *ClientUploader) directUploadPrefix(userID uint) string {
+	return fmt.Sprintf("%s%d/", c.directUploadRoot(), userID)
+}
+
+// directUploadRoot holds every user's direct uploads
+func (c *ClientUploader) directUploadRoot() string {
+	return c.uploadPath + "direct/"
+}
+
+// SignedUploadURL returns a V4 signed PUT URL for objectPath. The client has
+// to send the returned headers with the upload, which stores the object
+// metadata, pins the content type and makes GCS refuse bodies larger than
+// MaxUploadBytes.
+func (c *ClientUploader) SignedUploadURL(objectPath, contentType string, metadata ObjectMetadata) (string, map[string]string, error) {
+	sizeRange := fmt.Sprintf("0,%d", MaxUploadBytes)
+	headers := map[string]string{
+		"Content-Type":                contentType,
+		"x-goog-content-length-range": sizeRange,
+	}
+	signedHeaders := []string{"x-goog-content-length-range:" + sizeRange}
+	for key, value := range metadata.toMap() {
+		header := "x-goog-meta-" + key
+		headers[header] = value
+		signedHeaders = append(signedHeaders, header+":"+value)
+	}
+
+	opts := &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      "PUT",
+		ContentType: contentType,
+		Headers:     signedHeaders,
+		Expires:     time.Now().Add(directUploadExpiry),
+	}
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return "", nil, err
+	}
+
+	signedURL, err := bucket.SignedURL(objectPath, opts)
+	if err != nil {
+		return "", nil, classifyStorageError("SignedURL", err)
+	}
+
+	return signedURL, headers, nil
+}
+
+// Delete removes a stored object
+func (c *ClientUploader) Delete(objectPath string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*50)
+	defer cancel()
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return err
+	}
+
+	if err := bucket.Object(objectPath).Delete(ctx); err != nil {
+		return classifyStorageError("Object.Delete", err)
+	}
+	return nil
+}
+
+// CreateDirectUpload hands out a signed URL the client uploads an image to
+// straight to the bucket, keeping large uploads off the server. The upload
+// only becomes an image once ConfirmDirectUpload is called for it.
+func CreateDirectUpload(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input DirectUploadRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	filename := path.Base(strings.TrimSpace(input.Filename))
+	if filename == "" || filename == "." || filename == "/" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "filename is required",
+			"data":    nil,
+		})
+	}
+
+	if !slices.Contains(allowedContentTypes, input.ContentType) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("content_type must be one of: %s", strings.Join(allowedContentTypes, ", ")),
+			"data":    nil,
+		})
+	}
+
+	objectPath := uploader.directUploadPrefix(userID) + strconv.FormatInt(time.Now().UnixNano(), 10) + "_" + filename
+	uploadURL, headers, err := uploader.SignedUploadURL(objectPath, input.ContentType, ObjectMetadata{
+		OwnerID:          userID,
+		Source:           SourceDirectUpload,
+		OriginalFilename: filename,
+	})
+	if err != nil {
+		return storageErrorResponse(c, err, "Failed to sign upload URL")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Upload URL created",
+		"data": fiber.Map{
+			"upload_url":  uploadURL,
+			"method":      "PUT",
+			"headers":     headers,
+			"object_path": objectPath,
+			"url":         clientURL(uploader.publicURL(objectPath)),
+			"max_bytes":   MaxUploadBytes,
+			"expires_at":  time.Now().Add(directUploadExpiry).UTC(),
+		},
+	})
+}
+
+// errDirectUploadInvalid marks a confirmed object that isn't an acceptable
+// image; the object is deleted
+var errDirectUploadInvalid = errors.New("uploaded object is not a valid image")
+
+// checkDirectUpload validates a directly uploaded object without downloading
+// it, reading only as much as needed for the image header
+func checkDirectUpload(ctx context.Context, attrs *storage.ObjectAttrs) error {
+	if attrs.Size > int64(MaxUploadBytes) {
+		return fmt.Errorf("%w: larger than %d bytes", errDirectUploadInvalid, MaxUploadBytes)
+	}
+	if !contentTypeAllowed(attrs.ContentType) {
+		return fmt.Errorf("%w: content type %q is not accepted", errDirectUploadInvalid, attrs.ContentType)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	reader, err := uploader.DownloadStream(ctx, attrs.Name)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	cfg, format, err := image.DecodeConfig(reader)
+	if err := checkUploadFormat(format, err); err != nil {
+		return fmt.Errorf("%w: %v", errDirectUploadInvalid, err)
+	}
+	if cfg.Width > maxImageWidth() || cfg.Height > maxImageHeight() {
+		return fmt.Errorf("%w: image too large (max %dx%d)", errDirectUploadInvalid, maxImageWidth(), maxImageHeight())
+	}
+
+	return nil
+}
+
+// ConfirmDirectUpload records an image uploaded through a signed upload URL
+// once the client has finished the upload
+func ConfirmDirectUpload(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input ConfirmUploadRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	prefix := uploader.directUploadPrefix(userID)
+	if !strings.HasPrefix(input.ObjectPath, prefix) || strings.Contains(strings.TrimPrefix(input.ObjectPath, prefix), "/") {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "error",
+			"message": "object_path wasn't issued to you",
+			"data":    nil,
+		})
+	}
+
+	db := middleware.DB(c)
+	var existing int64
+	if err := db.Model(&models.Image{}).Where("object_path = ?", input.ObjectPath).Count(&existing).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+	if existing > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Upload already confirmed",
+			"data":    nil,
+		})
+	}
+
+	attrs, err := uploader.Attrs(input.ObjectPath)
+	if err != nil {
+		return storageErrorResponse(c, err, "Upload not found, finish the upload before confirming it")
+	}
+
+	// Past the window the cleanup may be deleting it already
+	if attrs.Created.Before(time.Now().Add(-directUploadConfirmWindow)) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Upload expired, upload the file again",
+			"data":    nil,
+		})
+	}
+
+	if err := checkDirectUpload(c.UserContext(), attrs); err != nil {
+		if !errors.Is(err, errDirectUploadInvalid) {
+			return storageErrorResponse(c, err, "Failed to read upload")
+		}
+		if err := uploader.Delete(attrs.Name); err != nil {
+			return storageErrorResponse(c, err, "Failed to remove invalid upload")
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	result := UploadResult{
+		URL:        uploader.publicURL(attrs.Name),
+		Filename:   path.Base(attrs.Name),
+		ObjectPath: attrs.Name,
+		Size:       attrs.Size,
+		Source:     SourceDirectUpload,
+		Private:    privateUploads,
+	}
+	if err := uploadImageToDB(c.UserContext(), result, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error saving to database",
+			"data":    nil,
+		})
+	}
+
+	if result.Private {
+		if result, err = result.signed(); err != nil {
+			return storageErrorResponse(c, err, "Error signing the file URL")
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Successfully uploaded the file",
+		"data":    result.displayURL(),
+	})
+}
+
+// directUploadCleanupBatch is how many stale uploads are checked against the
+// images table per query
+const directUploadCleanupBatch = 500
+
+// cleanDirectUploads deletes direct uploads older than
+// directUploadConfirmWindow that were never confirmed, and returns how many
+// it removed
+func cleanDirectUploads(ctx context.Context) (int, error) {
+	bucket, err := uploader.bucket()
+	if err != nil {
+		return 0, err
+	}
+
+	query := &storage.Query{Prefix: uploader.directUploadRoot()}
+	if err := query.SetAttrSelection([]string{"Name", "Created"}); err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-directUploadConfirmWindow)
+	var stale []string
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return 0, classifyStorageError("Objects", err)
+		}
+		if attrs.Created.Before(cutoff) {
+			stale = append(stale, attrs.Name)
+		}
+	}
+
+	deleted := 0
+	db := database.GetDB().WithContext(ctx)
+	for batch := range slices.Chunk(stale, directUploadCleanupBatch) {
+		// Soft deleted images still count, their objects go with the record
+		var confirmed []string
+		if err := db.Unscoped().Model(&models.Image{}).Where("object_path IN ?", batch).Pluck("object_path", &confirmed).Error; err != nil {
+			return deleted, err
+		}
+
+		for _, name := range batch {
+			if slices.Contains(confirmed, name) {
+				continue
+			}
+			if err := uploader.Delete(name); err != nil {
+				log.Printf("Failed to delete unconfirmed direct upload %s: %v", name, err)
+				continue
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// StartDirectUploadCleanup deletes unconfirmed direct uploads every
+// DIRECT_UPLOAD_CLEANUP_MINUTES
+func StartDirectUploadCleanup() {
+	if directUploadCleanupInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(directUploadCleanupInterval) {
+			deleted, err := cleanDirectUploads(context.Background())
+			if err != nil {
+				log.Printf("Failed to clean up direct uploads: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d unconfirmed direct upload(s)", deleted)
+			}
+		}
+	}()
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestCleanDirectUploadsKeepsConfirmedAndRecent(t *testing.T) {
+	fs := newFakeStorage(t)
+	mock := newMockDB(t)
+
+	confirmed := uploader.directUploadPrefix(1) + "1_confirmed.png"
+	abandoned := uploader.directUploadPrefix(1) + "2_abandoned.png"
+	recent := uploader.directUploadPrefix(2) + "3_recent.png"
+	data := encodePNG(t, testImage(4, 4))
+	for _, name := range []string{confirmed, abandoned, recent} {
+		fs.put(name, data, nil)
+	}
+
+	// Only uploads past the confirmation window are candidates
+	stale := time.Now().Add(-directUploadConfirmWindow - time.Hour)
+	fs.mu.Lock()
+	for _, name := range []string{confirmed, abandoned} {
+		object := fs.objects[name]
+		object.created = stale
+		fs.objects[name] = object
+	}
+	fs.mu.Unlock()
+
+	mock.ExpectQuery(`SELECT "object_path" FROM "images" WHERE object_path IN`).
+		WillReturnRows(sqlmock.NewRows([]string{"object_path"}).AddRow(confirmed))
+
+	deleted, err := cleanDirectUploads(t.Context())
+	if err != nil {
+		t.Fatalf("cleanDirectUploads: %v", err)
+	}
+	if deleted != 1 || fs.has(abandoned) {
+		t.Errorf("deleted %d upload(s), want only the abandoned one", deleted)
+	}
+	if !fs.has(confirmed) || !fs.has(recent) {
+		t.Error("a confirmed or recent upload was deleted")
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+var (
+	errHostNotAllowed = errors.New("image URL host is not allowed")
+	errBlockedAddress = errors.New("image URL points to a disallowed address")
+)
+
+// fetchAllowedHosts are hosts stored images may be loaded from besides the
+// bucket's own and PUBLIC_URL_BASE's, from the comma separated
+// FETCH_ALLOWED_HOSTS, e.g. a second CDN domain
+var fetchAllowedHosts = loadFetchAllowedHosts()
+
+func loadFetchAllowedHosts() []string {
+	var hosts []string
+	for _, field := range strings.Split(config.ConfigDefault("FETCH_ALLOWED_HOSTS", ""), ",") {
+		if host := strings.ToLower(strings.TrimSpace(field)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// checkFetchHost makes sure a stored image URL points at one of the hosts
+// images are served from, whatever the record says
+func checkFetchHost(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return errHostNotAllowed
+	}
+
+	allowed := slices.Clone(fetchAllowedHosts)
+	for _, base := range []string{uploader.gcsURLBase(), uploader.publicURLBase} {
+		if baseURL, err := url.Parse(base); err == nil && baseURL.Host != "" {
+			allowed = append(allowed, strings.ToLower(baseURL.Hostname()))
+		}
+	}
+
+	if !slices.Contains(allowed, strings.ToLower(u.Hostname())) {
+		return errHostNotAllowed
+	}
+	return nil
+}
+
+// blockedPrefixes are internal ranges the netip predicates don't cover:
+// carrier-grade NAT and IPv4's "this network"
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("0.0.0.0/8"),
+}
+
+// isBlockedAddress reports whether addr is loopback, private, link-local
+// (which includes the 169.254.169.254 metadata server) or otherwise not a
+// public unicast address
+func isBlockedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() || addr.IsUnspecified() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// denyInternalAddresses runs on every connection the fetch client makes,
+// with the address DNS resolved to
+func denyInternalAddresses(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || isBlockedAddress(addrPort.Addr()) {
+		return errBlockedAddress
+	}
+	return nil
+}
+
+// fetchClient downloads images by URL. The address is checked after DNS
+// resolution and again for every redirect, so neither a hostname that
+// resolves to an internal address nor a redirect to one gets through. It
+// never uses a proxy, which would hide the real destination.
+var fetchClient = newFetchClient()
+
+// fetchTimeout bounds a whole download by URL, from connecting to reading
+// the last byte, so a slow or stalled server can't hold a request open
+var fetchTimeout = time.Duration(config.ConfigInt("FETCH_TIMEOUT_SECONDS", 30)) * time.Second
+
+func newFetchClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   fetchTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   denyInternalAddresses,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	transport.ResponseHeaderTimeout = fetchTimeout
+
+	return &http.Client{Transport: transport, Timeout: fetchTimeout}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestIsBlockedAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"224.0.0.1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"142.250.72.16", false},
+		{"2001:4860:4860::8888", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isBlockedAddress(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+				t.Errorf("isBlockedAddress(%s) = %v, want %v", tt.addr, got, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestCheckFetchHost(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		err  error
+	}{
+		{"bucket URL", uploader.gcsURLBase() + "/users/1/photo.png", nil},
+		{"loopback", "http://127.0.0.1/users/1/photo.png", errHostNotAllowed},
+		{"metadata server", "http://169.254.169.254/computeMetadata/v1/", errHostNotAllowed},
+		{"other host", "https://example.com/photo.png", errHostNotAllowed},
+		{"non-HTTP scheme", "file:///etc/passwd", errHostNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkFetchHost(tt.url); !errors.Is(err, tt.err) {
+				t.Errorf("checkFetchHost(%q) = %v, want %v", tt.url, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestFetchClientRefusesLoopback(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	resp, err := fetchClient.Get("http://" + listener.Addr().String() + "/")
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("fetchClient connected to a loopback address")
+	}
+	if !errors.Is(err, errBlockedAddress) {
+		t.Errorf("err = %v, want errBlockedAddress", err)
+	}
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+// filterCosts is the rough time in milliseconds each filter takes per
+// megapixel of its input on one worker. They're ballpark figures for a
+// modern core, only meant to set expectations.
+var filterCosts = map[string]float64{
+	"resize":              25,
+	"crop_to_size":        2,
+	"crop_rect":           2,
+	"rotate":              40,
+	"brightness_increase": 8,
+	"brightness_decrease": 8,
+	"contrast_increase":   8,
+	"contrast_decrease":   8,
+	"saturation_increase": 10,
+	"saturation_decrease": 10,
+	"gamma":               8,
+	"hue":                 12,
+	"colorize":            12,
+	"threshold":           8,
+	"gaussian_blur":       35,
+	"sharpen":             40,
+	"pixelate":            6,
+	"grayscale":           6,
+	"invert":              4,
+	"autotrim":            5,
+	"background":          6,
+	"caption":             10,
+	"lut":                 15,
+	"watermark":           10,
+}
+
+// Per-megapixel cost of decoding a source image and of encoding the result
+const decodeCostPerMP = 15
+
+var encodeCosts = map[string]float64{
+	FormatJPEG: 20,
+	FormatWebP: 60,
+	FormatPNG:  80,
+}
+
+// ImageDimensions is an image given to the estimate by its size alone
+type ImageDimensions struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// EstimateRequest describes a filter run to estimate. Images can be given by
+// size, by stored URL or both; filters work as for ApplyFilterToImage.
+type EstimateRequest struct {
+	Images   []ImageDimensions `json:"images"`
+	ImageUrl []string          `json:"image_url"`
+	Filters  []FilterOperation `json:"filters"`
+}
+
+// ImageEstimate is the estimated outcome of processing one image
+type ImageEstimate struct {
+	Source         string `json:"source,omitempty"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	OutputWidth    int    `json:"output_width"`
+	OutputHeight   int    `json:"output_height"`
+	EstimatedMS    int64  `json:"estimated_ms"`
+	EstimatedBytes int64  `json:"estimated_bytes"`
+}
+
+// namedFilter pairs a filter with its name, which picks its cost
+type namedFilter struct {
+	name   string
+	filter gift.Filter
+}
+
+func megapixels(bounds image.Rectangle) float64 {
+	return float64(bounds.Dx()) * float64(bounds.Dy()) / 1e6
+}
+
+// estimatedBytesPerPixel is roughly how large a photo encodes with opts
+func estimatedBytesPerPixel(opts OutputOptions) float64 {
+	switch {
+	case opts.Format == FormatPNG:
+		return 2.0
+	case opts.Format == FormatWebP && opts.Lossless:
+		return 1.4
+	case opts.Format == FormatWebP:
+		return 0.05 + 0.3*float64(opts.Quality)/100
+	}
+	return 0.1 + 0.5*float64(opts.Quality)/100
+}
+
+// estimateImage walks the filter chain over an image's bounds, adding up the
+// cost of each step at the size it sees. Filters whose output depends on the
+// pixels, like autotrim, are assumed to keep the size.
+func estimateImage(width, height int, filters []namedFilter, opts OutputOptions) ImageEstimate {
+	bounds := image.Rect(0, 0, width, height)
+	cost := decodeCostPerMP * megapixels(bounds)
+
+	for _, f := range filters {
+		cost += filterCosts[f.name] * megapixels(bounds)
+		bounds = f.filter.Bounds(bounds)
+	}
+
+	cost += encodeCosts[opts.Format] * megapixels(bounds)
+
+	return ImageEstimate{
+		Width:          width,
+		Height:         height,
+		OutputWidth:    bounds.Dx(),
+		OutputHeight:   bounds.Dy(),
+		EstimatedMS:    int64(math.Ceil(cost)),
+		EstimatedBytes: int64(estimatedBytesPerPixel(opts) * float64(bounds.Dx()*bounds.Dy())),
+	}
+}
+
+// estimateFilters parses the filters of an estimate request the same way
+// ApplyFilterToImage does, keeping their names
+func estimateFilters(c *fiber.Ctx, ops []FilterOperation) ([]namedFilter, error) {
+	var names []string
+	var filters []gift.Filter
+	var err error
+
+	if len(ops) > 0 {
+		for _, op := range ops {
+			names = append(names, op.Filter)
+		}
+		filters, err = parseFilterOperations(ops)
+	} else {
+		var steps []queryFilter
+		if steps, err = queryFilterSteps(c); err != nil {
+			return nil, err
+		}
+		for _, step := range steps {
+			names = append(names, step.name)
+		}
+		filters, err = createQueryFilters(steps, c.Queries())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	filters = withWatermark(filters, c.Query("watermark"))
+	named := make([]namedFilter, len(filters))
+	for i, filter := range filters {
+		name := "watermark"
+		if i < len(names) {
+			name = names[i]
+		}
+		named[i] = namedFilter{name, filter}
+	}
+
+	return named, nil
+}
+
+// imageURLDimensions reads the size of a stored image from its header only
+func imageURLDimensions(ctx context.Context, imageURL string) (int, int, error) {
+	if _, err := validateURL(imageURL); err != nil {
+		return 0, 0, fmt.Errorf("image not found")
+	}
+
+	res, err := openImageURL(ctx, imageURL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Body.Close()
+
+	cfg, _, err := image.DecodeConfig(res.Body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read image header: %v", err)
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
+
+// EstimateFilters estimates how long a filter run would take and how large
+// its output would be, from image sizes and filter costs alone, without
+// processing anything. The batch total accounts for the processing workers
+// running images in parallel.
+func EstimateFilters(c *fiber.Ctx) error {
+	if _, err := middleware.CheckUserLoggedIn(c); err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input EstimateRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	count := len(input.Images) + len(input.ImageUrl)
+	if count == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "images or image_url is required",
+			"data":    nil,
+		})
+	}
+	if count > maxBatchSize() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Too many images (max %d per request)", maxBatchSize()),
+			"data":    nil,
+		})
+	}
+
+	filters, err := estimateFilters(c, input.Filters)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	opts, err := parseOutputOptions(c.Queries())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	sizes := append([]ImageDimensions(nil), input.Images...)
+	for _, imageURL := range input.ImageUrl {
+		width, height, err := imageURLDimensions(c.UserContext(), imageURL)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("%s: %v", imageURL, err),
+				"data":    nil,
+			})
+		}
+		sizes = append(sizes, ImageDimensions{Width: width, Height: height})
+	}
+
+	estimates := make([]ImageEstimate, 0, len(sizes))
+	var totalMS, totalBytes, slowest int64
+	for i, size := range sizes {
+		if size.Width < 1 || size.Height < 1 || size.Width > maxImageWidth() || size.Height > maxImageHeight() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("image dimensions must be between 1x1 and %dx%d", maxImageWidth(), maxImageHeight()),
+				"data":    nil,
+			})
+		}
+
+		estimate := estimateImage(size.Width, size.Height, filters, opts)
+		if i >= len(input.Images) {
+			estimate.Source = input.ImageUrl[i-len(input.Images)]
+		}
+		estimates = append(estimates, estimate)
+
+		totalMS += estimate.EstimatedMS
+		totalBytes += estimate.EstimatedBytes
+		slowest = max(slowest, estimate.EstimatedMS)
+	}
+
+	// Images spread over the workers, but one image never gets faster than
+	// its own estimate
+	wallMS := max(slowest, int64(math.Ceil(float64(totalMS)/float64(processingPool.workers))))
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Estimate computed",
+		"data": fiber.Map{
+			"images":                estimates,
+			"format":                opts.Format,
+			"total_estimated_ms":    wallMS,
+			"total_estimated_bytes": totalBytes,
+		},
+	})
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/gift"
+)
+
+const MaxFilterOperations = 20
+
+// FilterOperation is one step of a filter pipeline given in the request body,
+// e.g. {"filter": "resize", "params": {"width": 800, "height": 0}}. Unlike
+// query parameters, operations run in the order they're listed. Region
+// ("x:y:width:height") limits the operation to part of the image.
+type FilterOperation struct {
+	Filter string                 `json:"filter"`
+	Params map[string]interface{} `json:"params"`
+	Region string                 `json:"region,omitempty"`
+}
+
+// filterParams describes how a filter's named params map onto the positional
+// value the query-string syntax uses, so both go through createFilter
+type filterParams struct {
+	names     []string
+	separator string
+	// Options that adjust the filter beyond its query-string value
+	options []string
+	// Trailing names that may be left out
+	optional []string
+}
+
+var filterParamSpecs = map[string]filterParams{
+	"resize":              {names: []string{"width", "height"}, separator: "x", options: []string{"resampling"}},
+	"crop_to_size":        {names: []string{"width", "height"}, separator: "x", options: []string{"anchor"}},
+	"crop_rect":           {names: []string{"x", "y", "width", "height"}, separator: ":"},
+	"rotate":              {names: []string{"angle", "background"}, separator: ":", optional: []string{"background"}},
+	"brightness_increase": {names: []string{"value"}},
+	"brightness_decrease": {names: []string{"value"}},
+	"contrast_increase":   {names: []string{"value"}},
+	"contrast_decrease":   {names: []string{"value"}},
+	"saturation_increase": {names: []string{"value"}},
+	"saturation_decrease": {names: []string{"value"}},
+	"gamma":               {names: []string{"value"}},
+	"hue":                 {names: []string{"degrees"}},
+	"colorize":            {names: []string{"hue", "saturation", "percent"}, separator: ":"},
+	"threshold":           {names: []string{"value"}},
+	"gaussian_blur":       {names: []string{"radius"}},
+	"sharpen":             {names: []string{"sigma", "amount", "threshold"}, separator: ","},
+	"pixelate":            {names: []string{"size"}},
+	"grayscale":           {},
+	"invert":              {},
+	"autotrim":            {names: []string{"tolerance"}, optional: []string{"tolerance"}},
+	"background":          {names: []string{"color"}},
+	"caption":             {names: []string{"position", "color", "size", "text"}, separator: ":"},
+	"lut":                 {names: []string{"name", "strength"}, separator: ":", optional: []string{"strength"}},
+}
+
+var resamplingFilters = map[string]gift.Resampling{
+	"nearest": gift.NearestNeighborResampling,
+	"box":     gift.BoxResampling,
+	"linear":  gift.LinearResampling,
+	"cubic":   gift.CubicResampling,
+	"lanczos": gift.LanczosResampling,
+}
+
+var cropAnchors = map[string]gift.Anchor{
+	"center":       gift.CenterAnchor,
+	"top-left":     gift.TopLeftAnchor,
+	"top":          gift.TopAnchor,
+	"top-right":    gift.TopRightAnchor,
+	"left":         gift.LeftAnchor,
+	"right":        gift.RightAnchor,
+	"bottom-left":  gift.BottomLeftAnchor,
+	"bottom":       gift.BottomAnchor,
+	"bottom-right": gift.BottomRightAnchor,
+}
+
+// parseFilterOperations builds the filters for an ordered list of operations
+func parseFilterOperations(ops []FilterOperation) ([]gift.Filter, error) {
+	if len(ops) > MaxFilterOperations {
+		return nil, fmt.Errorf("too many filter operations (max %d)", MaxFilterOperations)
+	}
+
+	filters := make([]gift.Filter, 0, len(ops))
+	for _, op := range ops {
+		filter, err := parseFilterOperation(op)
+		if err != nil {
+			return nil, err
+		}
+		if op.Region != "" {
+			if filter, err = withRegion(op.Filter, filter, op.Region); err != nil {
+				return nil, err
+			}
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) == 0 {
+		return nil, fmt.Errorf("no valid filters specified")
+	}
+
+	return filters, nil
+}
+
+func parseFilterOperation(op FilterOperation) (gift.Filter, error) {
+	spec, ok := filterParamSpecs[op.Filter]
+	if !ok || !supportedFilters[op.Filter] {
+		return nil, FilterError{op.Filter, "unsupported filter"}
+	}
+
+	known := map[string]bool{}
+	for _, name := range append(spec.names, spec.options...) {
+		known[name] = true
+	}
+	for name := range op.Params {
+		if !known[name] {
+			return nil, FilterError{op.Filter, fmt.Sprintf("unknown parameter '%s'", name)}
+		}
+	}
+
+	values := make([]string, 0, len(spec.names))
+	for _, name := range spec.names {
+		raw, ok := op.Params[name]
+		if !ok {
+			if slices.Contains(spec.optional, name) {
+				break
+			}
+			return nil, FilterError{op.Filter, fmt.Sprintf("parameter '%s' is required", name)}
+		}
+
+		value, err := formatParam(raw)
+		if err != nil {
+			return nil, FilterError{op.Filter, fmt.Sprintf("parameter '%s' %v", name, err)}
+		}
+		values = append(values, value)
+	}
+
+	param := strings.Join(values, spec.separator)
+	if len(spec.options) == 0 {
+		return createFilter(op.Filter, param)
+	}
+
+	width, height, err := parseDimensions(param, op.Filter, op.Filter == "resize")
+	if err != nil {
+		return nil, err
+	}
+
+	switch op.Filter {
+	case "resize":
+		resampling := gift.LanczosResampling
+		if name, ok := op.Params["resampling"]; ok {
+			if resampling, ok = resamplingFilters[fmt.Sprint(name)]; !ok {
+				return nil, FilterError{op.Filter, "resampling must be one of " + optionNames(resamplingFilters)}
+			}
+		}
+		return gift.Resize(width, height, resampling), nil
+
+	default:
+		anchor := gift.LeftAnchor
+		if name, ok := op.Params["anchor"]; ok {
+			if anchor, ok = cropAnchors[fmt.Sprint(name)]; !ok {
+				return nil, FilterError{op.Filter, "anchor must be one of " + optionNames(cropAnchors)}
+			}
+		}
+		return gift.CropToSize(width, height, anchor), nil
+	}
+}
+
+// formatParam turns a JSON value into its query-string form
+func formatParam(raw interface{}) (string, error) {
+	switch v := raw.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("must be a number or a string")
+	}
+}
+
+func optionNames[T any](options map[string]T) string {
+	names := make([]string, 0, len(options))
+	for name := range options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+)
+
+// filterPrecedence is the order filters given as separate query parameters
+// run in: trimming and cropping first so crop coordinates refer to the
+// source, then rotation and resizing, color adjustments, effects, the
+// background once the image's shape is final, and the caption last so it
+// stays legible
+var filterPrecedence = []string{
+	"autotrim",
+	"crop_rect",
+	"crop_to_size",
+	"rotate",
+	"resize",
+	"brightness_increase",
+	"brightness_decrease",
+	"contrast_increase",
+	"contrast_decrease",
+	"saturation_increase",
+	"saturation_decrease",
+	"gamma",
+	"hue",
+	"colorize",
+	"lut",
+	"grayscale",
+	"threshold",
+	"invert",
+	"sharpen",
+	"gaussian_blur",
+	"pixelate",
+	"background",
+	"caption",
+}
+
+// queryFilter is one filter in query-string syntax, its name and value
+type queryFilter struct {
+	name  string
+	param string
+}
+
+// filterRank is the position of a filter in filterPrecedence
+func filterRank(filterName string) int {
+	if rank := slices.Index(filterPrecedence, filterName); rank >= 0 {
+		return rank
+	}
+	return len(filterPrecedence)
+}
+
+// sortedQueryFilters returns the filters given as separate query parameters
+// in filterPrecedence order
+func sortedQueryFilters(queryParams map[string]string) []queryFilter {
+	var steps []queryFilter
+	for filterName, param := range queryParams {
+		if !supportedFilters[filterName] {
+			continue // Skip unknown parameters
+		}
+		steps = append(steps, queryFilter{filterName, param})
+	}
+
+	slices.SortFunc(steps, func(a, b queryFilter) int {
+		return filterRank(a.name) - filterRank(b.name)
+	})
+
+	return steps
+}
+
+// orderedQueryFilters reads repeated ?filter=name:value parameters, e.g.
+// ?filter=resize:800x0&filter=gaussian_blur:2, keeping the order given. A
+// filter may appear more than once.
+func orderedQueryFilters(specs []string, queryParams map[string]string) ([]queryFilter, error) {
+	if len(specs) > MaxFilterOperations {
+		return nil, fmt.Errorf("too many filter operations (max %d)", MaxFilterOperations)
+	}
+
+	for filterName := range queryParams {
+		if supportedFilters[filterName] {
+			return nil, fmt.Errorf("filter '%s' can't be combined with filter=, list it as filter=%s:<value> instead", filterName, filterName)
+		}
+	}
+
+	steps := make([]queryFilter, 0, len(specs))
+	for _, spec := range specs {
+		filterName, param, _ := strings.Cut(spec, ":")
+		if !supportedFilters[filterName] {
+			return nil, FilterError{filterName, "unsupported filter"}
+		}
+		steps = append(steps, queryFilter{filterName, param})
+	}
+
+	return steps, nil
+}
+
+// queryFilterSteps returns the filters of a request's query string in the
+// order they run: the ?filter= list when given, otherwise the separately
+// named filters
+func queryFilterSteps(c *fiber.Ctx) ([]queryFilter, error) {
+	var specs []string
+	for _, spec := range c.Context().QueryArgs().PeekMulti("filter") {
+		specs = append(specs, string(spec))
+	}
+
+	if len(specs) > 0 {
+		return orderedQueryFilters(specs, c.Queries())
+	}
+	return sortedQueryFilters(c.Queries()), nil
+}
+
+// queryFilters builds the filters of a request's query string
+func queryFilters(c *fiber.Ctx) ([]gift.Filter, error) {
+	steps, err := queryFilterSteps(c)
+	if err != nil {
+		return nil, err
+	}
+	return createQueryFilters(steps, c.Queries())
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"image"
+	"image/color"
+	"slices"
+	"testing"
+)
+
+func stepNames(steps []queryFilter) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.name
+	}
+	return names
+}
+
+func TestSortedQueryFiltersUsesPrecedence(t *testing.T) {
+	params := map[string]string{
+		"gaussian_blur": "2",
+		"caption":       "bottom:ffffff:12:hi",
+		"resize":        "16x0",
+		"grayscale":     "",
+		"format":        "png",
+	}
+	want := []string{"resize", "grayscale", "gaussian_blur", "caption"}
+
+	// Map iteration order changes between runs, the result must not
+	for range 20 {
+		if got := stepNames(sortedQueryFilters(params)); !slices.Equal(got, want) {
+			t.Fatalf("sortedQueryFilters = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOrderedQueryFiltersKeepsRequestOrder(t *testing.T) {
+	steps, err := orderedQueryFilters([]string{"gaussian_blur:2", "resize:16x0", "gaussian_blur:1"}, nil)
+	if err != nil {
+		t.Fatalf("orderedQueryFilters: %v", err)
+	}
+	if got, want := stepNames(steps), []string{"gaussian_blur", "resize", "gaussian_blur"}; !slices.Equal(got, want) {
+		t.Fatalf("orderedQueryFilters = %v, want %v", got, want)
+	}
+
+	if _, err := orderedQueryFilters([]string{"resize:16x0"}, map[string]string{"gaussian_blur": "2"}); err == nil {
+		t.Fatal("a named filter was accepted alongside filter=")
+	}
+}
+
+// checkerboard alternates black and white cells of cell pixels. Blurring
+// before shrinking it barely touches the cells, blurring after smears the
+// shrunken ones to gray.
+func checkerboard(size, cell int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := color.NRGBA{A: 255}
+			if (x/cell+y/cell)%2 == 0 {
+				c = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func applySteps(t *testing.T, src image.Image, specs ...string) image.Image {
+	t.Helper()
+
+	steps, err := orderedQueryFilters(specs, nil)
+	if err != nil {
+		t.Fatalf("orderedQueryFilters: %v", err)
+	}
+	filters, err := createQueryFilters(steps, nil)
+	if err != nil {
+		t.Fatalf("createQueryFilters: %v", err)
+	}
+	img, err := processImage(src, filters)
+	if err != nil {
+		t.Fatalf("processImage: %v", err)
+	}
+	return img
+}
+
+func TestFilterOrderChangesPixels(t *testing.T) {
+	src := checkerboard(64, 8)
+	resizeFirst := applySteps(t, src, "resize:16x0", "gaussian_blur:2")
+	blurFirst := applySteps(t, src, "gaussian_blur:2", "resize:16x0")
+
+	if resizeFirst.Bounds() != blurFirst.Bounds() {
+		t.Fatalf("bounds differ: %v and %v", resizeFirst.Bounds(), blurFirst.Bounds())
+	}
+
+	// Mean absolute difference per channel, out of 255
+	var diff, samples float64
+	bounds := resizeFirst.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r1, g1, b1, _ := resizeFirst.At(x, y).RGBA()
+			r2, g2, b2, _ := blurFirst.At(x, y).RGBA()
+			for _, d := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+				diff += float64(max(d[0], d[1])-min(d[0], d[1])) / 257
+				samples++
+			}
+		}
+	}
+	if mean := diff / samples; mean < 16 {
+		t.Errorf("resize-then-blur and blur-then-resize differ by %.2f per channel on average, want a measurable difference", mean)
+	}
+}
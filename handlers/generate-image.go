@@ -3,15 +3,85 @@ package handler
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"image"
+	"io"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/disintegration/gift"
 	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
 	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"github.com/krishkalaria12/snap-serve/tracing"
 	"google.golang.org/genai"
 )
 
+// generationTimeout caps how long a single Gemini call may take
+var generationTimeout = time.Duration(config.ConfigInt("GENERATION_TIMEOUT_SECONDS", 120)) * time.Second
+
+// MaxGenerationVariations is how many images one prompt can produce per request
+const MaxGenerationVariations = 4
+
+var errGenerationBusy = errors.New("too many image generations in progress")
+
+// generationFailure is a failed generation along with the status and message
+// to respond with
+type generationFailure struct {
+	status  int
+	message string
+	err     error
+	// storage failures are answered by storageErrorResponse
+	storage bool
+	// feedback is what Gemini said about a generation that returned no image
+	feedback *models.GenerationFeedback
+}
+
+func (f *generationFailure) Error() string {
+	if f.err != nil {
+		return fmt.Sprintf("%s: %v", f.message, f.err)
+	}
+	return f.message
+}
+
+func (f *generationFailure) Unwrap() error {
+	return f.err
+}
+
+func failGeneration(status int, message string, err error) error {
+	return &generationFailure{status: status, message: message, err: err}
+}
+
+// generationErrorResponse writes the response for an error from generateVariation
+func generationErrorResponse(c *fiber.Ctx, err error) error {
+	var failure *generationFailure
+	if !errors.As(err, &failure) {
+		failure = &generationFailure{status: fiber.StatusInternalServerError, message: "Failed to generate image", err: err}
+	}
+
+	if failure.storage {
+		return storageErrorResponse(c, failure.err, failure.message)
+	}
+
+	if failure.status == fiber.StatusTooManyRequests {
+		c.Set(fiber.HeaderRetryAfter, "10")
+	}
+
+	var data fiber.Map
+	if failure.feedback != nil {
+		data = fiber.Map{"feedback": feedbackData(*failure.feedback)}
+	}
+
+	return c.Status(failure.status).JSON(fiber.Map{
+		"status":  "error",
+		"message": failure.message,
+		"data":    data,
+	})
+}
+
 func injectSysPrompt(prompt string) string {
 	return fmt.Sprintf(`You are an AI image generation assistant. Create detailed, visual descriptions for image generation models. Focus on:
 
@@ -25,8 +95,190 @@ Transform user requests into precise, descriptive prompts that will produce high
 User request: %s`, prompt)
 }
 
+// processGeneratedImage runs filters over a generated image. It stays a PNG
+// unless the default upload filters were applied, which output the default
+// format.
+func processGeneratedImage(ctx context.Context, src image.Image, filters []gift.Filter, useDefaultFormat bool) (reader *bytes.Reader, err error) {
+	processingPool.run(func() {
+		reader, err = filterGeneratedImage(ctx, src, filters, useDefaultFormat)
+	})
+
+	return reader, err
+}
+
+func filterGeneratedImage(ctx context.Context, src image.Image, filters []gift.Filter, useDefaultFormat bool) (*bytes.Reader, error) {
+	processed, err := processImage(src, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := OutputOptions{Format: FormatPNG}
+	if useDefaultFormat {
+		opts = defaultOutputOptions()
+	}
+	return tracedEncodeImage(ctx, processed, opts)
+}
+
+// generateVariation runs one Gemini generation of prompt, then processes,
+// uploads and records the image along with a copy at each of sizes. It waits
+// for a free generation slot first.
+func generateVariation(ctx context.Context, client *genai.Client, prompt string, userId uint, watermark string, sizes []int) (generatedImage, error) {
+	release, ok := acquireGenerationSlot(ctx)
+	if !ok {
+		return generatedImage{}, failGeneration(fiber.StatusTooManyRequests, "Too many image generations in progress, try again shortly", errGenerationBusy)
+	}
+	defer release()
+
+	// Bound the generation so a hung call can't hold the request forever
+	genCtx, cancel := context.WithTimeout(ctx, generationTimeout)
+	defer cancel()
+
+	genCtx, span := tracing.Start(genCtx, "gemini.generate_content")
+	result, err := client.Models.GenerateContent(
+		genCtx,
+		"gemini-2.5-flash-image-preview",
+		genai.Text(prompt),
+		&genai.GenerateContentConfig{},
+	)
+	tracing.End(span, err)
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return generatedImage{}, failGeneration(fiber.StatusGatewayTimeout, "Image generation timed out", err)
+	}
+
+	if err != nil {
+		return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Failed to generate image", err)
+	}
+
+	// The feedback is stored however the generation ends, with the image's
+	// URL once there is one
+	feedback := generationFeedback(userId, result)
+	defer func() { saveGenerationFeedback(feedback) }()
+
+	var imageBytes []byte
+	var foundImage bool
+
+	if len(result.Candidates) > 0 && result.Candidates[0] != nil && result.Candidates[0].Content != nil {
+		for _, part := range result.Candidates[0].Content.Parts {
+			if part != nil && part.InlineData != nil && part.InlineData.Data != nil {
+				imageBytes = part.InlineData.Data
+				foundImage = true
+				break
+			}
+		}
+	}
+
+	if !foundImage {
+		status := fiber.StatusInternalServerError
+		if generationBlocked(feedback) {
+			status = fiber.StatusUnprocessableEntity
+		}
+		return generatedImage{}, &generationFailure{status: status, message: generationExplanation(feedback), feedback: &feedback}
+	}
+
+	if len(imageBytes) == 0 {
+		return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Empty image data received", nil)
+	}
+
+	// Generated images go through the same decode and checks as uploads
+	reader := bytes.NewReader(imageBytes)
+	var src image.Image
+	var phash, placeholder string
+	processingPool.run(func() {
+		src, _, err = tracedDecodeImage(ctx, reader)
+		if err == nil {
+			err = checkImageDimensions(src)
+		}
+		if err == nil {
+			phash, placeholder = perceptualHash(src), blurHash(src)
+		}
+	})
+	if err != nil {
+		return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Generated image is invalid", err)
+	}
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Generated image is invalid", err)
+	}
+
+	outputFilename := fmt.Sprintf("generated_%d.png", time.Now().UnixNano())
+
+	var filters []gift.Filter
+	if filterGeneratedImages {
+		filters = defaultUploadFilters()
+	}
+	applyDefaults := len(filters) > 0
+	filters = withWatermark(filters, watermark)
+
+	if len(filters) > 0 {
+		reader, err = processGeneratedImage(ctx, src, filters, applyDefaults)
+		if err != nil {
+			return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Failed to process generated image", err)
+		}
+		if applyDefaults {
+			outputFilename = withExtension(outputFilename, defaultOutputOptions().extension())
+		}
+	}
+
+	uploadCtx, span := tracing.Start(ctx, "storage.upload")
+	url, attrs, err := uploader.UploadProcessedFile(uploadCtx, reader, outputFilename, ObjectMetadata{OwnerID: userId, Source: SourceGenerate})
+	tracing.End(span, err)
+	if err != nil {
+		return generatedImage{}, &generationFailure{message: "Failed to upload generated image", err: err, storage: true}
+	}
+
+	generated := generatedImage{UploadResult: UploadResult{
+		URL:        url,
+		Filename:   outputFilename,
+		ObjectPath: attrs.Name,
+		Size:       attrs.Size,
+		PHash:      phash,
+		BlurHash:   placeholder,
+		Source:     SourceGenerate,
+	}}
+
+	if len(sizes) > 0 {
+		sizesCtx, span := tracing.Start(ctx, "storage.upload_sizes")
+		generated.Sizes, err = storeGeneratedSizes(sizesCtx, src, filters, sizes, outputFilename, userId)
+		tracing.End(span, err)
+		if err != nil {
+			deleteUploads([]UploadResult{generated.UploadResult})
+			return generatedImage{}, &generationFailure{message: "Failed to store generated image sizes", err: err, storage: true}
+		}
+	}
+
+	// The image and its sizes are recorded together or not at all
+	saveCtx, span := tracing.Start(ctx, "db.save")
+	err = saveGeneratedImage(saveCtx, &generated, userId)
+	tracing.End(span, err)
+	if err != nil {
+		deleteUploads(append(generated.Sizes, generated.UploadResult))
+		return generatedImage{}, failGeneration(fiber.StatusInternalServerError, "Failed to save image record", err)
+	}
+
+	feedback.ImageURL = url
+	generated.Feedback = feedback
+	return generated, nil
+}
+
+// generatedImageData is the response entry of a generated image
+func generatedImageData(generated generatedImage) fiber.Map {
+	data := fiber.Map{
+		"url":      clientURL(generated.URL),
+		"filename": generated.Filename,
+		"feedback": feedbackData(generated.Feedback),
+	}
+	if len(generated.Sizes) > 0 {
+		sizes := make([]fiber.Map, len(generated.Sizes))
+		for i, size := range generated.Sizes {
+			sizes[i] = fiber.Map{"url": clientURL(size.URL), "width": size.Width, "height": size.Height}
+		}
+		data["sizes"] = sizes
+	}
+	return data
+}
+
 func GenerateImage(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 
 	userId, err := middleware.CheckUserLoggedIn(c)
 	if err != nil {
@@ -39,6 +291,10 @@ func GenerateImage(c *fiber.Ctx) error {
 
 	type GenerateImageRequest struct {
 		Prompt string `json:"prompt"`
+		// Variations is how many images to generate from the prompt, 1 by default
+		Variations int `json:"variations"`
+		// Sizes are widths to also store each image at, GENERATION_SIZES by default
+		Sizes *[]int `json:"sizes"`
 	}
 
 	var genImage GenerateImageRequest
@@ -66,90 +322,145 @@ func GenerateImage(c *fiber.Ctx) error {
 		})
 	}
 
-	enhancedPrompt := injectSysPrompt(genImage.Prompt)
-
-	client, err := genai.NewClient(ctx, nil)
-	if err != nil {
-		log.Fatal(err)
+	variations := genImage.Variations
+	if variations == 0 {
+		variations = 1
 	}
-
-	result, err := client.Models.GenerateContent(
-		ctx,
-		"gemini-2.5-flash-image-preview",
-		genai.Text(enhancedPrompt),
-		&genai.GenerateContentConfig{},
-	)
-
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	if variations < 1 || variations > MaxGenerationVariations {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to generate image",
+			"message": fmt.Sprintf("variations must be between 1 and %d", MaxGenerationVariations),
 			"data":    nil,
 		})
 	}
 
-	if len(result.Candidates[0].Content.Parts) == 0 {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "No image content in response",
-			"data":    nil,
-		})
+	sizes := generationSizes
+	if genImage.Sizes != nil {
+		if sizes, err = validateGenerationSizes(*genImage.Sizes); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": err.Error(),
+				"data":    nil,
+			})
+		}
 	}
 
-	var imageBytes []byte
-	var foundImage bool
-
-	for _, part := range result.Candidates[0].Content.Parts {
-		if part.InlineData != nil && part.InlineData.Data != nil {
-			imageBytes = part.InlineData.Data
-			foundImage = true
+	// Every variation counts against the quota, and all of them must fit
+	day := generationDay()
+	reserved := 0
+	for reserved < variations {
+		allowed, err := reserveGeneration(userId, day)
+		if err != nil {
+			for ; reserved > 0; reserved-- {
+				releaseGeneration(userId, day)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Database error",
+				"data":    nil,
+			})
+		}
+		if !allowed {
 			break
 		}
+		reserved++
 	}
 
-	if !foundImage {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	if reserved < variations {
+		message := fmt.Sprintf("Daily limit reached (%d generations per day), resets at midnight UTC", dailyGenerationLimit())
+		if reserved > 0 {
+			message = fmt.Sprintf("Only %d generations left today, requested %d", reserved, variations)
+		}
+		for ; reserved > 0; reserved-- {
+			releaseGeneration(userId, day)
+		}
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 			"status":  "error",
-			"message": "No image data found in response",
+			"message": message,
 			"data":    nil,
 		})
 	}
 
-	if len(imageBytes) == 0 {
+	// Gemini bills every call, including ones it blocks or that time out, so
+	// only variations that never reached it are given back
+	unbilled := reserved
+	defer func() {
+		for ; unbilled > 0; unbilled-- {
+			releaseGeneration(userId, day)
+		}
+	}()
+
+	enhancedPrompt := injectSysPrompt(genImage.Prompt)
+
+	client, err := genai.NewClient(ctx, nil)
+	if err != nil {
+		log.Printf("Failed to create genai client: %v", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Empty image data received",
+			"message": "Failed to generate image",
 			"data":    nil,
 		})
 	}
 
-	reader := bytes.NewReader(imageBytes)
+	watermark := c.Query("watermark")
+	uploads := make([]generatedImage, variations)
+	errs := make([]error, variations)
+	var wg sync.WaitGroup
+	for i := range variations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			uploads[i], errs[i] = generateVariation(ctx, client, enhancedPrompt, userId, watermark, sizes)
+		}(i)
+	}
+	wg.Wait()
 
-	outputFilename := fmt.Sprintf("generated_%d.png", time.Now().UnixNano())
+	unbilled = 0
+	for _, err := range errs {
+		if errors.Is(err, errGenerationBusy) {
+			unbilled++
+		}
+	}
 
-	url, filename, err := uploader.UploadProcessedFile(reader, outputFilename)
-	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to upload generated image",
-			"data":    nil,
+	images := []fiber.Map{}
+	var failures []string
+	var firstErr error
+	for i, err := range errs {
+		if err != nil {
+			log.Printf("Image generation failed: %v", err)
+			failures = append(failures, err.Error())
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		images = append(images, generatedImageData(uploads[i]))
+	}
+	generated := len(images)
+
+	if generated == 0 {
+		return generationErrorResponse(c, firstErr)
+	}
+
+	if variations == 1 {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Successfully generated image",
+			"data":    images[0],
 		})
 	}
 
-	if err := uploadImageToDB(url, filename, userId); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to save image record",
-			"data":    nil,
+	if len(failures) > 0 {
+		return c.Status(fiber.StatusPartialContent).JSON(fiber.Map{
+			"status":  "partial_success",
+			"message": fmt.Sprintf("Generated %d out of %d variations", generated, variations),
+			"data":    fiber.Map{"images": images, "errors": failures},
 		})
 	}
 
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status":  "success",
-		"message": "Successfully generated image",
-		"data": fiber.Map{
-			"url":      url,
-			"filename": filename,
-		},
+		"message": fmt.Sprintf("Successfully generated %d variations", generated),
+		"data":    fiber.Map{"images": images},
 	})
 }
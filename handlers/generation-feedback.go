@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/models"
+	"google.golang.org/genai"
+)
+
+// blockingFinishReasons are the finish reasons that mean Gemini refused to
+// produce the image, rather than failed
+var blockingFinishReasons = map[genai.FinishReason]string{
+	genai.FinishReasonSafety:            "it was flagged by safety filters",
+	genai.FinishReasonImageSafety:       "the image was flagged by safety filters",
+	genai.FinishReasonProhibitedContent: "it asked for prohibited content",
+	genai.FinishReasonBlocklist:         "it contains blocked terms",
+	genai.FinishReasonSPII:              "it may contain sensitive personal information",
+	genai.FinishReasonRecitation:        "the result resembled existing content too closely",
+}
+
+// generationFeedback pulls the finish reason and safety feedback out of a
+// Gemini response, for the prompt as a whole and its first candidate
+func generationFeedback(userID uint, result *genai.GenerateContentResponse) models.GenerationFeedback {
+	feedback := models.GenerationFeedback{UserID: userID, SafetyFlags: models.Tags{}}
+	if result == nil {
+		return feedback
+	}
+
+	var ratings []*genai.SafetyRating
+	if pf := result.PromptFeedback; pf != nil {
+		feedback.BlockReason = string(pf.BlockReason)
+		feedback.BlockMessage = pf.BlockReasonMessage
+		ratings = append(ratings, pf.SafetyRatings...)
+	}
+	if len(result.Candidates) > 0 && result.Candidates[0] != nil {
+		candidate := result.Candidates[0]
+		feedback.FinishReason = string(candidate.FinishReason)
+		feedback.FinishMessage = candidate.FinishMessage
+		ratings = append(ratings, candidate.SafetyRatings...)
+	}
+
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		flagged := rating.Probability != "" && rating.Probability != genai.HarmProbabilityNegligible &&
+			rating.Probability != genai.HarmProbabilityUnspecified
+		if rating.Blocked || flagged {
+			feedback.SafetyFlags = append(feedback.SafetyFlags, fmt.Sprintf("%s:%s", rating.Category, rating.Probability))
+		}
+	}
+
+	return feedback
+}
+
+// generationBlocked reports whether Gemini refused the prompt or its image
+func generationBlocked(feedback models.GenerationFeedback) bool {
+	_, blocked := blockingFinishReasons[genai.FinishReason(feedback.FinishReason)]
+	return blocked || feedback.BlockReason != ""
+}
+
+// generationExplanation tells the user why no image came back, in Gemini's
+// own words when it gave any
+func generationExplanation(feedback models.GenerationFeedback) string {
+	var message string
+	switch {
+	case feedback.BlockReason != "":
+		message = fmt.Sprintf("The prompt was blocked (%s)", feedback.BlockReason)
+		if feedback.BlockMessage != "" {
+			message += ": " + feedback.BlockMessage
+		}
+	case blockingFinishReasons[genai.FinishReason(feedback.FinishReason)] != "":
+		message = "No image was generated because " + blockingFinishReasons[genai.FinishReason(feedback.FinishReason)]
+		if feedback.FinishMessage != "" {
+			message += ": " + feedback.FinishMessage
+		}
+	case feedback.FinishReason != "" && feedback.FinishReason != string(genai.FinishReasonStop):
+		message = fmt.Sprintf("No image was generated (finish reason %s)", feedback.FinishReason)
+	default:
+		return "No image data found in response"
+	}
+
+	if len(feedback.SafetyFlags) > 0 {
+		message += fmt.Sprintf(". Flagged categories: %s", strings.Join(feedback.SafetyFlags, ", "))
+	}
+	return message
+}
+
+// saveGenerationFeedback stores the feedback of a generation. Failing to
+// store it doesn't fail the generation.
+func saveGenerationFeedback(feedback models.GenerationFeedback) {
+	if err := database.GetDB().Create(&feedback).Error; err != nil {
+		log.Printf("Failed to save generation feedback: %v", err)
+	}
+}
+
+// feedbackData is the response entry of a generation's feedback
+func feedbackData(feedback models.GenerationFeedback) fiber.Map {
+	data := fiber.Map{
+		"finish_reason": feedback.FinishReason,
+		"safety_flags":  feedback.SafetyFlags,
+	}
+	if feedback.FinishMessage != "" {
+		data["finish_message"] = feedback.FinishMessage
+	}
+	if feedback.BlockReason != "" {
+		data["block_reason"] = feedback.BlockReason
+		data["block_message"] = feedback.BlockMessage
+	}
+	return data
+}
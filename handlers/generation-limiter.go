@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// generationSlots bounds how many Gemini calls run at once across all users,
+// keeping us under the provider's concurrency limits. Requests beyond that
+// wait up to generationQueueTimeout for a free slot.
+var (
+	generationSlots        = make(chan struct{}, max(config.ConfigInt("GENERATION_MAX_CONCURRENCY", 4), 1))
+	generationQueueTimeout = time.Duration(config.ConfigInt("GENERATION_QUEUE_TIMEOUT_SECONDS", 30)) * time.Second
+)
+
+// acquireGenerationSlot waits for a free generation slot, giving up when the
+// queue timeout passes or ctx is done. Call the returned func to release it.
+func acquireGenerationSlot(ctx context.Context) (func(), bool) {
+	timer := time.NewTimer(generationQueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case generationSlots <- struct{}{}:
+		return func() { <-generationSlots }, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
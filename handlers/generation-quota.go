@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// generationDay is the UTC day quota is counted against. A request takes it
+// once, so its reservations and refunds land on the same day even when it
+// runs past midnight.
+func generationDay() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
+
+// reserveGeneration counts a generation against the user's quota for day. It
+// returns false without counting anything when the quota is already used up.
+func reserveGeneration(userID uint, day time.Time) (bool, error) {
+	limit := dailyGenerationLimit()
+	if limit <= 0 {
+		return true, nil
+	}
+
+	db := database.GetDB()
+	usage := models.GenerationUsage{UserID: userID, Day: day, Count: 1}
+
+	// The conditional upsert keeps concurrent requests from overshooting the cap
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":      gorm.Expr("generation_usages.count + 1"),
+			"updated_at": time.Now(),
+		}),
+		Where: clause.Where{Exprs: []clause.Expression{
+			clause.Expr{SQL: "generation_usages.count < ?", Vars: []interface{}{limit}},
+		}},
+	}).Create(&usage)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// releaseGeneration gives back a generation reserved for day that never
+// reached Gemini
+func releaseGeneration(userID uint, day time.Time) {
+	if dailyGenerationLimit() <= 0 {
+		return
+	}
+
+	db := database.GetDB()
+	db.Model(&models.GenerationUsage{}).
+		Where("user_id = ? AND day = ? AND count > 0", userID, day).
+		UpdateColumn("count", gorm.Expr("count - 1"))
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+// MaxGenerationSizes caps how many smaller copies a generated image gets
+const MaxGenerationSizes = 5
+
+// generationSizes are the widths every generated image is also stored at,
+// from the comma separated GENERATION_SIZES, e.g. "320,1024"
+var generationSizes = loadGenerationSizes()
+
+func loadGenerationSizes() []int {
+	var widths []int
+	for _, field := range strings.Split(config.ConfigDefault("GENERATION_SIZES", ""), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		width, err := strconv.Atoi(field)
+		if err != nil {
+			log.Fatalf("Invalid GENERATION_SIZES: %q is not a width", field)
+		}
+		widths = append(widths, width)
+	}
+
+	widths, err := validateGenerationSizes(widths)
+	if err != nil {
+		log.Fatalf("Invalid GENERATION_SIZES: %v", err)
+	}
+	return widths
+}
+
+// validateGenerationSizes checks a list of widths and returns it sorted
+// largest first without duplicates
+func validateGenerationSizes(widths []int) ([]int, error) {
+	for _, width := range widths {
+		if width < 1 || width > MaxImageWidth {
+			return nil, fmt.Errorf("sizes must be widths between 1 and %d", MaxImageWidth)
+		}
+	}
+
+	widths = slices.Clone(widths)
+	slices.Sort(widths)
+	widths = slices.Compact(widths)
+	slices.Reverse(widths)
+
+	if len(widths) > MaxGenerationSizes {
+		return nil, fmt.Errorf("too many sizes (max %d)", MaxGenerationSizes)
+	}
+	return widths, nil
+}
+
+// generatedImage is a stored generation along with its smaller copies
+type generatedImage struct {
+	UploadResult
+	Sizes    []UploadResult
+	Feedback models.GenerationFeedback
+}
+
+// storeGeneratedSizes stores a copy of src resized to each width below its
+// own, after the same filters as the full image, in the default output
+// format. If one fails, the copies already stored are deleted again.
+func storeGeneratedSizes(ctx context.Context, src image.Image, filters []gift.Filter, widths []int, filename string, userID uint) ([]UploadResult, error) {
+	opts := defaultOutputOptions()
+	var sizes []UploadResult
+
+	for _, width := range widths {
+		if width >= src.Bounds().Dx() {
+			continue
+		}
+
+		resize, err := createFilter("resize", fmt.Sprintf("%dx0", width))
+		if err != nil {
+			deleteUploads(sizes)
+			return nil, err
+		}
+
+		var resized image.Image
+		var reader *bytes.Reader
+		processingPool.run(func() {
+			resized, err = processImage(src, append(slices.Clip(filters), resize))
+			if err == nil {
+				reader, err = tracedEncodeImage(ctx, resized, opts)
+			}
+		})
+		if err != nil {
+			deleteUploads(sizes)
+			return nil, err
+		}
+
+		sizeFilename := withExtension(filename, fmt.Sprintf("_%dw%s", width, opts.extension()))
+		url, attrs, err := uploader.UploadProcessedFile(ctx, reader, sizeFilename, ObjectMetadata{OwnerID: userID, Source: SourceGenerate})
+		if err != nil {
+			deleteUploads(sizes)
+			return nil, err
+		}
+
+		sizes = append(sizes, UploadResult{
+			URL:        url,
+			Filename:   sizeFilename,
+			ObjectPath: attrs.Name,
+			Size:       attrs.Size,
+			Width:      resized.Bounds().Dx(),
+			Height:     resized.Bounds().Dy(),
+			Format:     opts.Format,
+			Source:     SourceGenerate,
+		})
+	}
+
+	return sizes, nil
+}
+
+// deleteUploads removes stored objects whose records will never be created
+func deleteUploads(uploads []UploadResult) {
+	for _, upload := range uploads {
+		if err := uploader.Delete(upload.ObjectPath); err != nil {
+			log.Printf("Failed to delete %s: %v", upload.ObjectPath, err)
+		}
+	}
+}
+
+// saveGeneratedImage records a generated image and its sizes in one
+// transaction, linking each size to the full image as a variant
+func saveGeneratedImage(ctx context.Context, generated *generatedImage, userID uint) error {
+	return database.GetDB().WithContext(context.WithoutCancel(ctx)).Transaction(func(tx *gorm.DB) error {
+		record := newImageRecord(generated.UploadResult, userID)
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+
+		for i := range generated.Sizes {
+			generated.Sizes[i].SourceID = record.ID
+			sizeRecord := newImageRecord(generated.Sizes[i], userID)
+			if err := tx.Create(&sizeRecord).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
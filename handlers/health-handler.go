@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"google.golang.org/genai"
+)
+
+const (
+	healthCheckTimeout = 5 * time.Second
+
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// geminiHealthCheck turns the Gemini probe off even when generation is set up
+var geminiHealthCheck = config.ConfigBool("GEMINI_HEALTH_CHECK", true)
+
+// geminiProbeTTL is how long a Gemini probe result is reused, so frequent
+// health checks don't turn into a stream of API calls
+var geminiProbeTTL = time.Duration(config.ConfigInt("GEMINI_HEALTH_CACHE_SECONDS", 60)) * time.Second
+
+type geminiProbe struct {
+	mu        sync.Mutex
+	status    string
+	err       string
+	checkedAt time.Time
+}
+
+var geminiStatus geminiProbe
+
+// generationConfigured reports whether a Gemini key is available, matching
+// what genai.NewClient looks for
+func generationConfigured() bool {
+	return os.Getenv("GEMINI_API_KEY") != "" || os.Getenv("GOOGLE_API_KEY") != ""
+}
+
+// check lists a single model, which needs a working key but costs no quota
+func (p *geminiProbe) check(ctx context.Context) fiber.Map {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.checkedAt) >= geminiProbeTTL {
+		ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		defer cancel()
+
+		p.status, p.err = HealthOK, ""
+		client, err := genai.NewClient(ctx, nil)
+		if err == nil {
+			_, err = client.Models.List(ctx, &genai.ListModelsConfig{PageSize: 1})
+		}
+		if err != nil {
+			log.Printf("Gemini health check failed: %v", err)
+			p.status, p.err = HealthDown, err.Error()
+		}
+		p.checkedAt = time.Now()
+	}
+
+	result := fiber.Map{"status": p.status, "checked_at": p.checkedAt}
+	if p.err != "" {
+		result["error"] = p.err
+	}
+	return result
+}
+
+// Health reports the status of the database and, when generation is
+// configured, the Gemini API. Gemini being down only degrades the service
+// since uploads and filters still work without it.
+func Health(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), healthCheckTimeout)
+	defer cancel()
+
+	checks := fiber.Map{}
+	status := HealthOK
+
+	dbCheck := fiber.Map{"status": HealthOK}
+	sqlDB, err := database.GetDB().DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		dbCheck = fiber.Map{"status": HealthDown, "error": err.Error()}
+		status = HealthDown
+	}
+	checks["database"] = dbCheck
+
+	if geminiHealthCheck && generationConfigured() {
+		gemini := geminiStatus.check(c.UserContext())
+		checks["gemini"] = gemini
+		if gemini["status"] != HealthOK && status == HealthOK {
+			status = HealthDegraded
+		}
+	}
+
+	code, result := fiber.StatusOK, "success"
+	if status == HealthDown {
+		code, result = fiber.StatusServiceUnavailable, "error"
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status":  result,
+		"message": "Service is " + status,
+		"data":    fiber.Map{"status": status, "checks": checks},
+	})
+}
@@ -0,0 +1,360 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-pkgz/auth/v2/token"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	_ "github.com/krishkalaria12/snap-serve/internal/testenv"
+	"google.golang.org/api/option"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeObject is an object held by fakeStorage
+type fakeObject struct {
+	data        []byte
+	contentType string
+	metadata    map[string]string
+	created     time.Time
+}
+
+// fakeStorage serves the parts of the Cloud Storage JSON API the uploader
+// uses, keeping objects in memory. It counts uploads and tracks how many run
+// at once.
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+	deleted []string
+
+	// uploadDelay holds every upload, so concurrent ones overlap
+	uploadDelay time.Duration
+	// failUpload rejects uploads of the objects it matches
+	failUpload  func(name string) bool
+	uploads     atomic.Int32
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+	requests    atomic.Int32
+}
+
+// newFakeStorage points the uploader at a fresh fakeStorage for the rest of
+// the test
+func newFakeStorage(t *testing.T) *fakeStorage {
+	t.Helper()
+
+	fs := &fakeStorage{objects: map[string]fakeObject{}}
+	srv := httptest.NewServer(http.HandlerFunc(fs.serve))
+
+	client, err := storage.NewClient(context.Background(),
+		option.WithEndpoint(srv.URL+"/storage/v1/"),
+		option.WithoutAuthentication(),
+	)
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+
+	SetStorageClient(client)
+	t.Cleanup(func() {
+		SetStorageClient(nil)
+		client.Close()
+		srv.Close()
+	})
+	return fs
+}
+
+// put stores an object directly, as if it had been uploaded before
+func (fs *fakeStorage) put(name string, data []byte, metadata map[string]string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.objects[name] = fakeObject{data: data, contentType: "image/png", metadata: metadata, created: time.Now()}
+}
+
+func (fs *fakeStorage) has(name string) bool {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	_, ok := fs.objects[name]
+	return ok
+}
+
+func (fs *fakeStorage) serve(w http.ResponseWriter, r *http.Request) {
+	fs.requests.Add(1)
+	bucketPrefix := "/b/" + bucketName + "/o"
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1"+bucketPrefix:
+		fs.upload(w, r)
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1"+bucketPrefix:
+		fs.list(w, r.URL.Query().Get("prefix"))
+	case strings.HasPrefix(r.URL.Path, "/storage/v1"+bucketPrefix+"/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1"+bucketPrefix+"/"))
+		switch {
+		case r.Method == http.MethodDelete:
+			fs.delete(w, name)
+		case r.URL.Query().Get("alt") == "media":
+			fs.download(w, name)
+		default:
+			fs.attrs(w, name)
+		}
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/"+bucketName+"/"):
+		fs.download(w, strings.TrimPrefix(r.URL.Path, "/"+bucketName+"/"))
+	default:
+		http.Error(w, "not implemented by fakeStorage", http.StatusNotImplemented)
+	}
+}
+
+func (fs *fakeStorage) upload(w http.ResponseWriter, r *http.Request) {
+	inFlight := fs.inFlight.Add(1)
+	defer fs.inFlight.Add(-1)
+	for {
+		highest := fs.maxInFlight.Load()
+		if inFlight <= highest || fs.maxInFlight.CompareAndSwap(highest, inFlight) {
+			break
+		}
+	}
+	time.Sleep(fs.uploadDelay)
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	parts := multipart.NewReader(r.Body, params["boundary"])
+
+	var attrs struct {
+		Name        string            `json:"name"`
+		ContentType string            `json:"contentType"`
+		Metadata    map[string]string `json:"metadata"`
+	}
+	part, err := parts.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&attrs)
+	}
+	var data []byte
+	if err == nil {
+		part, err = parts.NextPart()
+	}
+	if err == nil {
+		data, err = io.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if attrs.Name == "" {
+		attrs.Name = r.URL.Query().Get("name")
+	}
+	if fs.failUpload != nil && fs.failUpload(attrs.Name) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, `{"error":{"code":403,"message":"Upload rejected"}}`)
+		return
+	}
+
+	object := fakeObject{data: data, contentType: attrs.ContentType, metadata: attrs.Metadata, created: time.Now()}
+	fs.mu.Lock()
+	fs.objects[attrs.Name] = object
+	fs.mu.Unlock()
+	fs.uploads.Add(1)
+
+	writeObjectJSON(w, attrs.Name, object)
+}
+
+func (fs *fakeStorage) attrs(w http.ResponseWriter, name string) {
+	fs.mu.Lock()
+	object, ok := fs.objects[name]
+	fs.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	writeObjectJSON(w, name, object)
+}
+
+func (fs *fakeStorage) download(w http.ResponseWriter, name string) {
+	fs.mu.Lock()
+	object, ok := fs.objects[name]
+	fs.mu.Unlock()
+	if !ok {
+		writeNotFound(w)
+		return
+	}
+	w.Header().Set("Content-Type", object.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+	w.Write(object.data)
+}
+
+// list returns every object under prefix on a single page
+func (fs *fakeStorage) list(w http.ResponseWriter, prefix string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	items := []map[string]interface{}{}
+	for name, object := range fs.objects {
+		if strings.HasPrefix(name, prefix) {
+			items = append(items, objectJSON(name, object))
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"kind": "storage#objects", "items": items})
+}
+
+func (fs *fakeStorage) delete(w http.ResponseWriter, name string) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if _, ok := fs.objects[name]; !ok {
+		writeNotFound(w)
+		return
+	}
+	delete(fs.objects, name)
+	fs.deleted = append(fs.deleted, name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func objectJSON(name string, object fakeObject) map[string]interface{} {
+	return map[string]interface{}{
+		"bucket":      bucketName,
+		"name":        name,
+		"size":        strconv.Itoa(len(object.data)),
+		"contentType": object.contentType,
+		"metadata":    object.metadata,
+		"generation":  "1",
+		"timeCreated": object.created.Format(time.RFC3339Nano),
+	}
+}
+
+func writeObjectJSON(w http.ResponseWriter, name string, object fakeObject) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(objectJSON(name, object))
+}
+
+func writeNotFound(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, `{"error":{"code":404,"message":"No such object"}}`)
+}
+
+// newMockDB makes database.GetDB use a sqlmock connection for the rest of the
+// test. Every expectation set on the returned mock must be met.
+func newMockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatalf("failed to open gorm: %v", err)
+	}
+
+	database.SetDB(db)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet database expectations: %v", err)
+		}
+		sqlDB.Close()
+	})
+	return mock
+}
+
+// roundTripFunc lets a function serve fetchClient's requests
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// stubFetch serves every image fetched by URL for the rest of the test with
+// data, calling onFetch first when it isn't nil
+func stubFetch(t *testing.T, data []byte, onFetch func(*http.Request)) {
+	t.Helper()
+
+	client := fetchClient
+	fetchClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if onFetch != nil {
+			onFetch(r)
+		}
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {"image/png"}},
+			ContentLength: int64(len(data)),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			Request:       r,
+		}, nil
+	})}
+	t.Cleanup(func() { fetchClient = client })
+}
+
+// expectStoredImage expects GetImageFromDB to find image id, owned by userID
+// and stored at objectPath, returning the URL it is served at
+func expectStoredImage(mock sqlmock.Sqlmock, id, userID uint, objectPath string) string {
+	url := uploader.gcsURLBase() + "/" + objectPath
+	mock.ExpectQuery(`SELECT \* FROM "images" WHERE \(original_url IN`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "original_url", "object_path"}).
+			AddRow(id, userID, url, objectPath))
+	return url
+}
+
+// asUser stands in for AuthMiddleware, logging every request in as userID
+func asUser(userID uint) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", token.User{ID: strconv.FormatUint(uint64(userID), 10)})
+		return c.Next()
+	}
+}
+
+// testImage is a w x h image with a gradient, so filters have something to
+// work on
+func testImage(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 255 / max(w-1, 1)), G: uint8(y * 255 / max(h-1, 1)), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t testing.TB, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// decodeResponse reads a JSON response body into v
+func decodeResponse(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+)
+
+// accessFlushInterval is how often buffered image views are written out
+var accessFlushInterval = time.Duration(max(config.ConfigInt("ACCESS_FLUSH_SECONDS", 30), 1)) * time.Second
+
+type imageAccess struct {
+	views int64
+	last  time.Time
+}
+
+// accessRecorder buffers image views in memory and writes them in one UPDATE
+// per interval, so serving a popular image doesn't write its row on every
+// request. Views buffered when the process exits are lost, so counts are
+// approximate.
+type accessRecorder struct {
+	mu      sync.Mutex
+	pending map[uint]imageAccess
+	start   sync.Once
+}
+
+var imageAccesses = &accessRecorder{pending: map[uint]imageAccess{}}
+
+// record counts one view of an image
+func (r *accessRecorder) record(imageID uint) {
+	r.start.Do(func() {
+		go r.run()
+	})
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	access := r.pending[imageID]
+	access.views++
+	access.last = time.Now()
+	r.pending[imageID] = access
+}
+
+func (r *accessRecorder) run() {
+	for range time.Tick(accessFlushInterval) {
+		if err := r.flush(); err != nil {
+			log.Printf("Failed to record image views: %v", err)
+		}
+	}
+}
+
+// flush writes the buffered views. On failure they are merged back in to be
+// retried with the next flush.
+func (r *accessRecorder) flush() error {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = map[uint]imageAccess{}
+	r.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	rows := make([]string, 0, len(batch))
+	args := make([]interface{}, 0, len(batch)*3)
+	for id, access := range batch {
+		rows = append(rows, "(?::bigint, ?::bigint, ?::timestamptz)")
+		args = append(args, id, access.views, access.last)
+	}
+
+	err := database.GetDB().Exec(fmt.Sprintf(`UPDATE images SET
+			view_count = images.view_count + v.views,
+			last_accessed_at = GREATEST(images.last_accessed_at, v.last)
+		FROM (VALUES %s) AS v(id, views, last)
+		WHERE images.id = v.id`, strings.Join(rows, ", ")), args...).Error
+	if err != nil {
+		r.mu.Lock()
+		for id, access := range batch {
+			merged := r.pending[id]
+			merged.views += access.views
+			if access.last.After(merged.last) {
+				merged.last = access.last
+			}
+			r.pending[id] = merged
+		}
+		r.mu.Unlock()
+	}
+
+	return err
+}
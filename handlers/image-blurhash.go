@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"image"
+	"log"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/disintegration/gift"
+)
+
+const (
+	blurHashXComponents = 4
+	blurHashYComponents = 3
+	// blurHashSampleWidth is the width images are shrunk to before encoding.
+	// A placeholder only keeps a handful of components, so more pixels only
+	// cost time.
+	blurHashSampleWidth = 32
+)
+
+// blurHash computes a BlurHash placeholder for img, empty if it can't be
+// encoded
+func blurHash(img image.Image) string {
+	g := gift.New(gift.Resize(blurHashSampleWidth, 0, gift.BoxResampling))
+	small := image.NewNRGBA(g.Bounds(img.Bounds()))
+	g.Draw(small, img)
+
+	hash, err := blurhash.Encode(blurHashXComponents, blurHashYComponents, small)
+	if err != nil {
+		log.Printf("Failed to compute blurhash: %v", err)
+		return ""
+	}
+	return hash
+}
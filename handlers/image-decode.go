@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/tracing"
+	"github.com/rwcarlsen/goexif/exif"
+	"go.opentelemetry.io/otel/attribute"
+
+	// TIFF sources for print and scientific workflows. Only the first page of
+	// a multi-page TIFF is decoded.
+	_ "golang.org/x/image/tiff"
+)
+
+// orientationFilters turn an image upright for each EXIF orientation value
+var orientationFilters = map[int]gift.Filter{
+	2: gift.FlipHorizontal(),
+	3: gift.Rotate180(),
+	4: gift.FlipVertical(),
+	5: gift.Transpose(),
+	6: gift.Rotate270(),
+	7: gift.Transverse(),
+	8: gift.Rotate90(),
+}
+
+// exifOrientation reads the EXIF orientation of an image, 1 (upright) when
+// there's none
+func exifOrientation(r io.Reader) int {
+	x, err := exif.Decode(r)
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return 1
+	}
+
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+
+	orientation, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+// errImageTooLarge marks an image rejected for its size before it was
+// decoded
+var errImageTooLarge = errors.New("image too large")
+
+// checkDeclaredSize rejects an image whose header declares more pixels than
+// the largest image allowed. Only the pixel count is compared, since EXIF
+// orientation may still swap width and height; the exact bounds are checked
+// once the image is decoded.
+func checkDeclaredSize(cfg image.Config) error {
+	if int64(cfg.Width)*int64(cfg.Height) > int64(maxImageWidth())*int64(maxImageHeight()) {
+		return fmt.Errorf("%w (max %dx%d pixels, declared %dx%d)", errImageTooLarge, maxImageWidth(), maxImageHeight(), cfg.Width, cfg.Height)
+	}
+	return nil
+}
+
+// decodeImage decodes an image and rotates it upright according to its EXIF
+// orientation, so every path that works on pixels (uploads, filters and
+// generation) sees the image the way viewers display it. TIFFs carry the same
+// orientation tag in their own header.
+//
+// The header is read first, so an image declaring huge dimensions, like a
+// small but highly compressed decompression bomb, is rejected before its
+// pixel buffer is allocated.
+func decodeImage(r io.ReadSeeker) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+	if err := checkDeclaredSize(cfg); err != nil {
+		return nil, "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	if format != "jpeg" && format != "tiff" {
+		return img, format, nil
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	filter, ok := orientationFilters[exifOrientation(r)]
+	if !ok {
+		return img, format, nil
+	}
+
+	g := gift.New(filter)
+	upright := image.NewRGBA(g.Bounds(img.Bounds()))
+	g.Draw(upright, img)
+	return upright, format, nil
+}
+
+// tracedDecodeImage is decodeImage recorded as an image.decode span of ctx
+func tracedDecodeImage(ctx context.Context, r io.ReadSeeker) (image.Image, string, error) {
+	_, span := tracing.Start(ctx, "image.decode")
+	img, format, err := decodeImage(r)
+	if err == nil {
+		span.SetAttributes(
+			attribute.String("image.format", format),
+			attribute.Int("image.width", img.Bounds().Dx()),
+			attribute.Int("image.height", img.Bounds().Dy()),
+		)
+	}
+	tracing.End(span, err)
+	return img, format, err
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// pngHeader is a PNG that only declares its size: a signature and an IHDR
+// chunk for an 8-bit RGBA image, with no pixel data
+func pngHeader(width, height uint32) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], width)
+	binary.BigEndian.PutUint32(ihdr[4:], height)
+	ihdr[8] = 8 // bit depth
+	ihdr[9] = 6 // RGBA
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)))
+	chunk := append([]byte("IHDR"), ihdr...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	return buf.Bytes()
+}
+
+func TestDecodeImageRejectsDeclaredHugeDimensions(t *testing.T) {
+	// Just over the limit; decoding it would allocate a 64 MB pixel buffer
+	data := pngHeader(MaxImageWidth+1, MaxImageHeight+1)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, _, err := decodeImage(bytes.NewReader(data))
+	runtime.ReadMemStats(&after)
+
+	if !errors.Is(err, errImageTooLarge) {
+		t.Fatalf("err = %v, want errImageTooLarge", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("allocated %d bytes before rejecting the image", allocated)
+	}
+}
+
+func TestDecodeImageAcceptsSmallImage(t *testing.T) {
+	data := encodePNG(t, testImage(16, 16))
+	if _, format, err := decodeImage(bytes.NewReader(data)); err != nil || format != "png" {
+		t.Fatalf("decodeImage = %q, %v, want png", format, err)
+	}
+}
+
+func TestReadImageBodyStopsAtMaxFetchBytes(t *testing.T) {
+	limit := maxFetchBytes
+	maxFetchBytes = 1024
+	t.Cleanup(func() { maxFetchBytes = limit })
+
+	// The server doesn't announce the length, so only the read can stop it
+	res := &http.Response{
+		ContentLength: -1,
+		Body:          io.NopCloser(strings.NewReader(strings.Repeat("x", 4096))),
+	}
+	if _, err := readImageBody(res); !errors.Is(err, errImageTooLarge) {
+		t.Fatalf("err = %v, want errImageTooLarge", err)
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+// exifCollector gathers EXIF tags into a flat map, optionally skipping GPS
+type exifCollector struct {
+	tags      map[string]string
+	redactGPS bool
+}
+
+func (w exifCollector) Walk(name exif.FieldName, tag *tiff.Tag) error {
+	field := string(name)
+	if w.redactGPS && strings.HasPrefix(field, "GPS") {
+		return nil
+	}
+
+	if tag.Format() == tiff.StringVal {
+		value, err := tag.StringVal()
+		if err == nil {
+			w.tags[field] = strings.TrimRight(value, "\x00 ")
+			return nil
+		}
+	}
+
+	w.tags[field] = tag.String()
+	return nil
+}
+
+// extractExif parses EXIF data from raw image bytes. Images without EXIF
+// yield an empty result rather than an error.
+func extractExif(data []byte, redactGPS bool) fiber.Map {
+	result := fiber.Map{"tags": map[string]string{}}
+
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil && (x == nil || exif.IsCriticalError(err)) {
+		return result
+	}
+
+	collector := exifCollector{tags: map[string]string{}, redactGPS: redactGPS}
+	if err := x.Walk(collector); err != nil {
+		return result
+	}
+	result["tags"] = collector.tags
+
+	if !redactGPS {
+		if lat, long, err := x.LatLong(); err == nil {
+			result["gps"] = fiber.Map{"latitude": lat, "longitude": long}
+		}
+	}
+
+	return result
+}
+
+func GetImageExif(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	data, err := downloadStoredImage(img)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to load image",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "EXIF metadata extracted",
+		"data":    extractExif(data, c.QueryBool("redact_gps")),
+	})
+}
@@ -2,18 +2,30 @@ package handler
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/disintegration/gift"
+	"github.com/gen2brain/webp"
 	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
 	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"github.com/krishkalaria12/snap-serve/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const (
@@ -24,11 +36,39 @@ const (
 	MaxBrightness  = 100
 	MaxContrast    = 100
 	MaxSaturation  = 200
+	MinGamma       = 0.1
+	MaxGamma       = 3.0
+	MaxHueShift    = 180
+	MaxHue         = 360
+	MaxPercentage  = 100
+
+	MinSharpenSigma     = 0.1
+	MaxSharpenSigma     = 10
+	MaxSharpenAmount    = 5
+	MaxSharpenThreshold = 1
+
+	MaxTrimTolerance     = 100
+	DefaultTrimTolerance = 10
+
+	DefaultDPI = 72
+	MaxDPI     = 2400
+
+	DefaultWebPQuality = 80
+	MinQuality         = 1
+	MaxQuality         = 100
+)
+
+// Output formats processed images can be encoded to
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatPNG  = "png"
 )
 
 var supportedFilters = map[string]bool{
 	"resize":              true,
 	"crop_to_size":        true,
+	"crop_rect":           true,
 	"rotate":              true,
 	"brightness_increase": true,
 	"brightness_decrease": true,
@@ -40,10 +80,130 @@ var supportedFilters = map[string]bool{
 	"pixelate":            true,
 	"grayscale":           true,
 	"invert":              true,
+	"autotrim":            true,
+	"gamma":               true,
+	"hue":                 true,
+	"colorize":            true,
+	"threshold":           true,
+	"caption":             true,
+	"lut":                 true,
+	"sharpen":             true,
+	"background":          true,
 }
 
 type ImageRequest struct {
 	ImageUrl []string `json:"image_url"`
+	// Filters, when given, replaces the filters from query parameters
+	Filters []FilterOperation `json:"filters"`
+}
+
+// EncodedImage is a processed image ready for upload, along with the
+// dimensions and format it actually ended up with after all transforms.
+// Reader streams the encoded bytes as they're produced and must be closed
+// once the upload is done.
+type EncodedImage struct {
+	Reader io.ReadCloser
+	Width  int
+	Height int
+	Format string
+	// SourceID is the stored image this one was processed from
+	SourceID uint
+}
+
+// OutputOptions controls how processed images are encoded. DPI only applies
+// to JPEG; Lossless only to WebP, where it replaces Quality. PNG is always
+// lossless and ignores both.
+type OutputOptions struct {
+	DPI      int
+	Format   string
+	Quality  int
+	Lossless bool
+}
+
+// defaultOutputFormat is used when a request doesn't pick a format, and for
+// uploads and generated images run through the default filters
+var defaultOutputFormat = loadDefaultOutputFormat()
+
+func loadDefaultOutputFormat() string {
+	format := config.ConfigDefault("DEFAULT_OUTPUT_FORMAT", FormatJPEG)
+	if !slices.Contains(outputFormats, format) {
+		log.Fatalf("DEFAULT_OUTPUT_FORMAT must be one of: %s", strings.Join(outputFormats, ", "))
+	}
+	return format
+}
+
+// defaultQuality is the lossy encoder quality used for a format when the
+// request doesn't set one
+func defaultQuality(format string) int {
+	if format == FormatWebP {
+		return DefaultWebPQuality
+	}
+	return JPEGQuality
+}
+
+func defaultOutputOptions() OutputOptions {
+	return OutputOptions{DPI: DefaultDPI, Format: defaultOutputFormat, Quality: defaultQuality(defaultOutputFormat)}
+}
+
+// extension is the filename extension for the output format
+func (o OutputOptions) extension() string {
+	switch o.Format {
+	case FormatWebP:
+		return ".webp"
+	case FormatPNG:
+		return ".png"
+	}
+	return ".jpg"
+}
+
+func parseOutputOptions(queryParams map[string]string) (OutputOptions, error) {
+	opts := defaultOutputOptions()
+
+	if param, ok := queryParams["dpi"]; ok {
+		dpi, err := parseIntParam(param, "dpi")
+		if err != nil {
+			return opts, err
+		}
+		if dpi < 1 || dpi > MaxDPI {
+			return opts, fmt.Errorf("dpi must be between 1 and %d", MaxDPI)
+		}
+		opts.DPI = dpi
+	}
+
+	if format, ok := queryParams["format"]; ok {
+		if !slices.Contains(outputFormats, format) {
+			return opts, fmt.Errorf("format must be one of: %s", strings.Join(outputFormats, ", "))
+		}
+		opts.Format = format
+		opts.Quality = defaultQuality(format)
+	}
+
+	if param, ok := queryParams["webp_lossless"]; ok {
+		lossless, err := strconv.ParseBool(param)
+		if err != nil {
+			return opts, fmt.Errorf("webp_lossless must be true or false")
+		}
+		if lossless && opts.Format != FormatWebP {
+			return opts, fmt.Errorf("webp_lossless requires WebP output, set format=webp")
+		}
+		opts.Lossless = lossless
+	}
+
+	if param, ok := queryParams["quality"]; ok {
+		if opts.Lossless {
+			return opts, fmt.Errorf("quality only applies to lossy encoding, drop it or webp_lossless")
+		}
+		if opts.Format == FormatPNG {
+			return opts, fmt.Errorf("quality doesn't apply to PNG, which is always lossless")
+		}
+		// Out of range values are clamped and anything else keeps the
+		// format's default, so a sloppy client still gets an image
+		if quality, err := strconv.Atoi(param); err == nil {
+			opts.Quality = min(max(quality, MinQuality), MaxQuality)
+		}
+	}
+
+	return opts, nil
 }
 
 type FilterError struct {
@@ -55,49 +215,114 @@ func (e FilterError) Error() string {
 	return fmt.Sprintf("filter '%s': %s", e.FilterName, e.Message)
 }
 
-func validateURL(imageURL string) error {
-	_, err := GetImageFromDB(imageURL)
-
-	if err != nil {
-		return err
+// validateURL checks that the URL belongs to a stored image on one of the
+// hosts images are served from, returning its record
+func validateURL(imageURL string) (models.Image, error) {
+	if err := checkFetchHost(imageURL); err != nil {
+		return models.Image{}, err
 	}
-
-	return nil
+	return GetImageFromDB(imageURL)
 }
 
-func loadImage(imageURL string) (image.Image, error) {
-	if err := validateURL(imageURL); err != nil {
-		return nil, err
+// openImageURL fetches a remote image, checking that the response is a
+// successful image response. Internal addresses are never connected to. The
+// caller must close the body.
+func openImageURL(ctx context.Context, imageURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %v", err)
 	}
 
-	res, err := http.Get(imageURL)
+	res, err := fetchClient.Do(req)
+	if errors.Is(err, errBlockedAddress) {
+		// Said without the address, which would reveal the internal network
+		return nil, errBlockedAddress
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch image: %v", err)
 	}
-	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
 		return nil, fmt.Errorf("received status code %d", res.StatusCode)
 	}
 
 	// Check content type
 	contentType := res.Header.Get("Content-Type")
 	if !strings.HasPrefix(contentType, "image/") {
+		res.Body.Close()
 		return nil, fmt.Errorf("URL does not point to an image")
 	}
 
-	img, _, err := image.Decode(res.Body)
+	return res, nil
+}
+
+// maxFetchBytes caps how much of an image fetched by URL is downloaded
+var maxFetchBytes = int64(config.ConfigInt("MAX_FETCH_BYTES", MaxUploadBytes))
+
+// readImageBody reads a fetched image, giving up as soon as it's larger than
+// maxFetchBytes instead of buffering whatever the server sends
+func readImageBody(res *http.Response) ([]byte, error) {
+	tooLarge := fmt.Errorf("%w (max %d bytes)", errImageTooLarge, maxFetchBytes)
+	if res.ContentLength > maxFetchBytes {
+		return nil, tooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxFetchBytes+1))
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %v", err)
+		return nil, fmt.Errorf("failed to read image: %v", err)
 	}
+	if int64(len(data)) > maxFetchBytes {
+		return nil, tooLarge
+	}
+	return data, nil
+}
 
-	// Check image dimensions
+func checkImageDimensions(img image.Image) error {
 	bounds := img.Bounds()
-	if bounds.Dx() > MaxImageWidth || bounds.Dy() > MaxImageHeight {
-		return nil, fmt.Errorf("image too large (max %dx%d)", MaxImageWidth, MaxImageHeight)
+	if bounds.Dx() > maxImageWidth() || bounds.Dy() > maxImageHeight() {
+		return fmt.Errorf("image too large (max %dx%d)", maxImageWidth(), maxImageHeight())
 	}
+	return nil
+}
 
-	return img, nil
+// LoadedImage is an image in the filter pipeline along with the ID of the
+// stored image it derives from
+type LoadedImage struct {
+	Image    image.Image
+	SourceID uint
+}
+
+func loadImage(ctx context.Context, imageURL string) (LoadedImage, error) {
+	record, err := validateURL(imageURL)
+	if err != nil {
+		return LoadedImage{}, err
+	}
+
+	res, err := openImageURL(ctx, imageURL)
+	if err != nil {
+		return LoadedImage{}, err
+	}
+	defer res.Body.Close()
+
+	data, err := readImageBody(res)
+	if err != nil {
+		return LoadedImage{}, err
+	}
+
+	var img image.Image
+	processingPool.run(func() {
+		img, _, err = tracedDecodeImage(ctx, bytes.NewReader(data))
+	})
+	if err != nil {
+		return LoadedImage{}, err
+	}
+
+	if err := checkImageDimensions(img); err != nil {
+		return LoadedImage{}, err
+	}
+
+	return LoadedImage{Image: img, SourceID: record.ID}, nil
 }
 
 func parseIntParam(param, paramName string) (int, error) {
@@ -135,7 +360,9 @@ func parseFloatParam(param, paramName string, min, max float32) (float32, error)
 	return floatVal, nil
 }
 
-func parseDimensions(param, filterName string) (int, int, error) {
+// parseDimensions parses "widthxheight". With preserveAspect one side may be
+// 0 to have it follow the aspect ratio, otherwise both must be set.
+func parseDimensions(param, filterName string, preserveAspect bool) (int, int, error) {
 	if param == "" {
 		return 0, 0, FilterError{filterName, "dimensions parameter is required"}
 	}
@@ -155,6 +382,13 @@ func parseDimensions(param, filterName string) (int, int, error) {
 		return 0, 0, FilterError{filterName, err.Error()}
 	}
 
+	if width == 0 && height == 0 {
+		return 0, 0, FilterError{filterName, "width and height can't both be 0"}
+	}
+	if !preserveAspect && (width == 0 || height == 0) {
+		return 0, 0, FilterError{filterName, "width and height must be greater than 0"}
+	}
+
 	if width > MaxImageWidth || height > MaxImageHeight {
 		return 0, 0, FilterError{filterName, fmt.Sprintf("dimensions too large (max %dx%d)", MaxImageWidth, MaxImageHeight)}
 	}
@@ -162,28 +396,70 @@ func parseDimensions(param, filterName string) (int, int, error) {
 	return width, height, nil
 }
 
+// parseCropRect parses "x:y:width:height" into the rectangle to crop to
+func parseCropRect(param string) (image.Rectangle, error) {
+	parts := strings.Split(param, ":")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("value must be in format 'x:y:width:height'")
+	}
+
+	var values [4]int
+	for i, name := range []string{"x", "y", "width", "height"} {
+		value, err := parseIntParam(parts[i], name)
+		if err != nil {
+			return image.Rectangle{}, err
+		}
+		values[i] = value
+	}
+
+	x, y, width, height := values[0], values[1], values[2], values[3]
+	if width == 0 || height == 0 {
+		return image.Rectangle{}, fmt.Errorf("width and height must be greater than 0")
+	}
+	if width > MaxImageWidth || height > MaxImageHeight {
+		return image.Rectangle{}, fmt.Errorf("dimensions too large (max %dx%d)", MaxImageWidth, MaxImageHeight)
+	}
+
+	return image.Rect(x, y, x+width, y+height), nil
+}
+
 func createFilter(filterName, param string) (gift.Filter, error) {
 	switch filterName {
 	case "resize":
-		width, height, err := parseDimensions(param, filterName)
+		width, height, err := parseDimensions(param, filterName, true)
 		if err != nil {
 			return nil, err
 		}
 		return gift.Resize(width, height, gift.LanczosResampling), nil
 
 	case "crop_to_size":
-		width, height, err := parseDimensions(param, filterName)
+		width, height, err := parseDimensions(param, filterName, false)
 		if err != nil {
 			return nil, err
 		}
 		return gift.CropToSize(width, height, gift.LeftAnchor), nil
 
+	case "crop_rect":
+		rect, err := parseCropRect(param)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.Crop(rect), nil
+
 	case "rotate":
-		degree, err := parseFloatParam(param, "rotation angle", -360, 360)
+		// angle[:background], exposed corners stay transparent by default
+		angle, background, hasBackground := strings.Cut(param, ":")
+		degree, err := parseFloatParam(angle, "rotation angle", -360, 360)
 		if err != nil {
 			return nil, FilterError{filterName, err.Error()}
 		}
-		return gift.Rotate(degree, color.Transparent, gift.CubicInterpolation), nil
+		var fill color.Color = color.Transparent
+		if hasBackground {
+			if fill, err = parseHexColor(background); err != nil {
+				return nil, FilterError{filterName, err.Error()}
+			}
+		}
+		return gift.Rotate(degree, fill, gift.CubicInterpolation), nil
 
 	case "brightness_increase":
 		value, err := parseFloatParam(param, "brightness", 0, MaxBrightness)
@@ -227,6 +503,60 @@ func createFilter(filterName, param string) (gift.Filter, error) {
 		}
 		return gift.Saturation(-value), nil
 
+	case "gamma":
+		value, err := parseFloatParam(param, "gamma", MinGamma, MaxGamma)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.Gamma(value), nil
+
+	case "hue":
+		value, err := parseFloatParam(param, "hue shift", -MaxHueShift, MaxHueShift)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.Hue(value), nil
+
+	case "colorize":
+		parts := strings.Split(param, ":")
+		if len(parts) != 3 {
+			return nil, FilterError{filterName, "value must be in format 'hue:saturation:percent'"}
+		}
+		hue, err := parseFloatParam(parts[0], "hue", 0, MaxHue)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		saturation, err := parseFloatParam(parts[1], "saturation", 0, MaxPercentage)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		percent, err := parseFloatParam(parts[2], "percent", 0, MaxPercentage)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.Colorize(hue, saturation, percent), nil
+
+	case "threshold":
+		value, err := parseFloatParam(param, "threshold", 0, MaxPercentage)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.Threshold(value), nil
+
+	case "caption":
+		caption, err := parseCaption(param)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return caption, nil
+
+	case "lut":
+		filter, err := parseLUTParam(param)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return filter, nil
+
 	case "gaussian_blur":
 		value, err := parseFloatParam(param, "blur radius", 0.1, MaxBlurRadius)
 		if err != nil {
@@ -234,6 +564,27 @@ func createFilter(filterName, param string) (gift.Filter, error) {
 		}
 		return gift.GaussianBlur(value), nil
 
+	case "sharpen":
+		// sigma,amount,threshold of an unsharp mask. Sharpening after a
+		// downscaling resize restores the detail it softens.
+		parts := strings.Split(param, ",")
+		if len(parts) != 3 {
+			return nil, FilterError{filterName, "value must be in format 'sigma,amount,threshold'"}
+		}
+		sigma, err := parseFloatParam(parts[0], "sigma", MinSharpenSigma, MaxSharpenSigma)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		amount, err := parseFloatParam(parts[1], "amount", 0, MaxSharpenAmount)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		threshold, err := parseFloatParam(parts[2], "threshold", 0, MaxSharpenThreshold)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return gift.UnsharpMask(sigma, amount, threshold), nil
+
 	case "pixelate":
 		value, err := parseIntParam(param, "pixelate size")
 		if err != nil {
@@ -250,24 +601,54 @@ func createFilter(filterName, param string) (gift.Filter, error) {
 	case "invert":
 		return gift.Invert(), nil
 
+	case "background":
+		// Flattens transparency onto a solid color, e.g. before JPEG output
+		fill, err := parseHexColor(param)
+		if err != nil {
+			return nil, FilterError{filterName, err.Error()}
+		}
+		return backgroundFilter{color: fill}, nil
+
+	case "autotrim":
+		tolerance := float32(DefaultTrimTolerance)
+		if param != "" && param != "true" {
+			value, err := parseFloatParam(param, "trim tolerance", 0, MaxTrimTolerance)
+			if err != nil {
+				return nil, FilterError{filterName, err.Error()}
+			}
+			tolerance = value
+		}
+		return autoTrimFilter{tolerance: tolerance}, nil
+
 	default:
 		return nil, FilterError{filterName, "unsupported filter"}
 	}
 }
 
+// parseFilters builds the filters given as separate query parameters, e.g.
+// ?resize=800x0&grayscale=true. Those carry no order, so they run in
+// filterPrecedence order.
 func parseFilters(queryParams map[string]string) ([]gift.Filter, error) {
-	var filters []gift.Filter
+	return createQueryFilters(sortedQueryFilters(queryParams), queryParams)
+}
 
-	for filterName, param := range queryParams {
-		if !supportedFilters[filterName] {
-			continue // Skip unknown parameters
-		}
+// createQueryFilters creates the filters of steps in order
+func createQueryFilters(steps []queryFilter, queryParams map[string]string) ([]gift.Filter, error) {
+	var filters []gift.Filter
 
-		filter, err := createFilter(filterName, param)
+	for _, step := range steps {
+		filter, err := createFilter(step.name, step.param)
 		if err != nil {
 			return nil, err
 		}
 
+		// ?region= limits every filter given in the query to that rectangle
+		if region, ok := queryParams["region"]; ok {
+			if filter, err = withRegion(step.name, filter, region); err != nil {
+				return nil, err
+			}
+		}
+
 		filters = append(filters, filter)
 	}
 
@@ -278,118 +659,354 @@ func parseFilters(queryParams map[string]string) ([]gift.Filter, error) {
 	return filters, nil
 }
 
-func processImage(src image.Image, filters []gift.Filter) (image.Image, error) {
+// contentFilter is implemented by filters whose output bounds depend on the
+// pixels of the image rather than just its size, which gift can't express.
+// processImage resolves them against the intermediate image when reached.
+type contentFilter interface {
+	gift.Filter
+	resolve(img image.Image) gift.Filter
+}
+
+// autoTrimFilter crops away uniform-color borders. The border color is taken
+// from the top-left pixel and tolerance is a percentage of the channel range.
+type autoTrimFilter struct {
+	tolerance float32
+}
+
+func (f autoTrimFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f autoTrimFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+}
+
+func (f autoTrimFilter) resolve(img image.Image) gift.Filter {
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return gift.Crop(bounds)
+	}
+
+	maxDiff := uint32(f.tolerance / 100 * 0xffff)
+	r0, g0, b0, a0 := img.At(bounds.Min.X, bounds.Min.Y).RGBA()
+	matches := func(x, y int) bool {
+		r, g, b, a := img.At(x, y).RGBA()
+		return channelDiff(r, r0) <= maxDiff && channelDiff(g, g0) <= maxDiff &&
+			channelDiff(b, b0) <= maxDiff && channelDiff(a, a0) <= maxDiff
+	}
+	uniformRow := func(y, minX, maxX int) bool {
+		for x := minX; x < maxX; x++ {
+			if !matches(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	uniformColumn := func(x, minY, maxY int) bool {
+		for y := minY; y < maxY; y++ {
+			if !matches(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	top := bounds.Min.Y
+	for top < bounds.Max.Y && uniformRow(top, bounds.Min.X, bounds.Max.X) {
+		top++
+	}
+
+	// Every row matched the border color, so there is nothing to trim to
+	if top == bounds.Max.Y {
+		return gift.Crop(bounds)
+	}
+
+	bottom := bounds.Max.Y
+	for bottom > top && uniformRow(bottom-1, bounds.Min.X, bounds.Max.X) {
+		bottom--
+	}
+
+	left := bounds.Min.X
+	for left < bounds.Max.X && uniformColumn(left, top, bottom) {
+		left++
+	}
+
+	right := bounds.Max.X
+	for right > left && uniformColumn(right-1, top, bottom) {
+		right--
+	}
+
+	return gift.Crop(image.Rect(left, top, right, bottom))
+}
+
+func channelDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func drawFilters(src image.Image, filters []gift.Filter) image.Image {
 	g := gift.New(filters...)
 	dst := image.NewRGBA(g.Bounds(src.Bounds()))
 	g.Draw(dst, src)
-	return dst, nil
+	return dst
 }
 
-func encodeImage(img image.Image) (*bytes.Reader, error) {
+func processImage(src image.Image, filters []gift.Filter) (image.Image, error) {
+	img := src
+	var pending []gift.Filter
+
+	for _, filter := range filters {
+		if cf, ok := filter.(contentFilter); ok {
+			if len(pending) > 0 {
+				img = drawFilters(img, pending)
+				pending = nil
+			}
+			filter = cf.resolve(img)
+		}
+		pending = append(pending, filter)
+	}
+
+	return drawFilters(img, pending), nil
+}
+
+func encodeImage(img image.Image, opts OutputOptions) (*bytes.Reader, error) {
 	var buf bytes.Buffer
-	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: JPEGQuality})
+	if err := writeEncoded(&buf, img, opts); err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf.Bytes()), nil
+}
+
+// tracedEncodeImage is encodeImage recorded as an image.encode span of ctx
+func tracedEncodeImage(ctx context.Context, img image.Image, opts OutputOptions) (*bytes.Reader, error) {
+	_, span := tracing.Start(ctx, "image.encode", attribute.String("image.format", opts.Format))
+	reader, err := encodeImage(img, opts)
+	if err == nil {
+		span.SetAttributes(attribute.Int("image.bytes", reader.Len()))
+	}
+	tracing.End(span, err)
+	return reader, err
+}
+
+// writeEncoded encodes img to w in the format opts asks for. It refuses any
+// format outside the allowed output formats.
+func writeEncoded(w io.Writer, img image.Image, opts OutputOptions) error {
+	if !slices.Contains(outputFormats, opts.Format) {
+		return fmt.Errorf("failed to encode image: output format %q is not allowed", opts.Format)
+	}
+
+	var err error
+	switch opts.Format {
+	case FormatWebP:
+		err = webp.Encode(w, img, webp.Options{Quality: opts.Quality, Lossless: opts.Lossless})
+	case FormatPNG:
+		err = png.Encode(w, img)
+	default:
+		err = jpeg.Encode(&densityWriter{w: w, dpi: opts.DPI}, flattenForJPEG(img), &jpeg.Options{Quality: opts.Quality})
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to encode image: %v", err)
+	}
+	return nil
+}
+
+func encodeJPEG(img image.Image, quality int) (*bytes.Reader, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
 	if err != nil {
 		return nil, fmt.Errorf("failed to encode image: %v", err)
 	}
 	return bytes.NewReader(buf.Bytes()), nil
 }
 
-func routineLoadImages(images []string) []image.Image {
-	loadedImages := make(chan image.Image, len(images))
-	var wg sync.WaitGroup
+func jfifSegment(dpi int) []byte {
+	return []byte{
+		0xFF, 0xE0, // APP0 marker
+		0x00, 0x10, // segment length
+		'J', 'F', 'I', 'F', 0x00,
+		0x01, 0x01, // JFIF version 1.01
+		0x01,                      // density in dots per inch
+		byte(dpi >> 8), byte(dpi), // horizontal density
+		byte(dpi >> 8), byte(dpi), // vertical density
+		0x00, 0x00, // no thumbnail
+	}
+}
 
-	for _, imageUrl := range images {
-		wg.Add(1)
-		go func(url string) {
-			defer wg.Done()
-			img, err := loadImage(url)
-			if err != nil {
-				loadedImages <- nil
-			} else {
-				loadedImages <- img
-			}
-		}(imageUrl)
+// densityWriter inserts a JFIF APP0 segment carrying the given DPI after the
+// first two bytes (the SOI marker) written through it. The stdlib encoder
+// doesn't write one, so without it viewers fall back to their own default
+// density.
+type densityWriter struct {
+	w       io.Writer
+	dpi     int
+	written int
+}
+
+func (d *densityWriter) Write(p []byte) (int, error) {
+	if d.written >= 2 {
+		return d.w.Write(p)
 	}
 
-	go func() {
-		wg.Wait()
-		close(loadedImages)
-	}()
+	n := min(2-d.written, len(p))
+	if _, err := d.w.Write(p[:n]); err != nil {
+		return 0, err
+	}
+	d.written += n
 
-	results := []image.Image{}
-	for img := range loadedImages {
-		if img != nil {
-			results = append(results, img)
+	if d.written == 2 {
+		if _, err := d.w.Write(jfifSegment(d.dpi)); err != nil {
+			return n, err
 		}
 	}
 
-	return results
+	if n == len(p) {
+		return n, nil
+	}
+	rest, err := d.w.Write(p[n:])
+	return n + rest, err
+}
+
+// streamEncodeImage encodes img on the processing pool, streaming the output
+// through a pipe so the full encoded image is never held in memory. Encoding
+// paces itself to the reader; closing the reader early aborts it.
+//
+// Encoding only starts on the first read. An encoder blocks a pool worker
+// until its output is read, so streams still waiting for their upload must
+// not hold workers the ones being read need.
+func streamEncodeImage(ctx context.Context, img image.Image, opts OutputOptions) io.ReadCloser {
+	return &lazyEncoder{ctx: ctx, img: img, opts: opts}
 }
 
-func routineProcessImages(images []image.Image, filters []gift.Filter) []image.Image {
-	processedImages := make(chan image.Image, len(images))
-	var wg sync.WaitGroup
+type lazyEncoder struct {
+	ctx    context.Context
+	img    image.Image
+	opts   OutputOptions
+	once   sync.Once
+	reader *io.PipeReader
+}
 
-	for _, img := range images {
-		wg.Add(1)
-		go func(srcImg image.Image) {
-			defer wg.Done()
-			processedImg, err := processImage(srcImg, filters)
-			if err != nil {
-				processedImages <- nil
-			} else {
-				processedImages <- processedImg
-			}
-		}(img)
+func (e *lazyEncoder) start() {
+	pr, pw := io.Pipe()
+	go processingPool.run(func() {
+		_, span := tracing.Start(e.ctx, "image.encode", attribute.String("image.format", e.opts.Format))
+		err := writeEncoded(pw, e.img, e.opts)
+		tracing.End(span, err)
+		pw.CloseWithError(err)
+	})
+	e.reader = pr
+}
+
+func (e *lazyEncoder) Read(p []byte) (int, error) {
+	e.once.Do(e.start)
+	if e.reader == nil {
+		return 0, io.ErrClosedPipe
 	}
+	return e.reader.Read(p)
+}
 
-	go func() {
-		wg.Wait()
-		close(processedImages)
-	}()
+// Close aborts the encoder, or keeps it from ever starting
+func (e *lazyEncoder) Close() error {
+	e.once.Do(func() {})
+	if e.reader == nil {
+		return nil
+	}
+	return e.reader.Close()
+}
 
-	results := []image.Image{}
-	for img := range processedImages {
+// routineLoadImages fetches and decodes the images, skipping any that fail,
+// at most pipelineConcurrency at a time. Cancelling ctx aborts the downloads.
+// It also returns the first failure, to explain when none loaded.
+func routineLoadImages(ctx context.Context, images []string) ([]LoadedImage, error) {
+	loaded := make([]*LoadedImage, len(images))
+	errs := make([]error, len(images))
+	forEachBounded(len(images), pipelineConcurrency, func(i int) {
+		img, err := loadImage(ctx, images[i])
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		loaded[i] = &img
+	})
+
+	results := []LoadedImage{}
+	var firstErr error
+	for i, img := range loaded {
 		if img != nil {
-			results = append(results, img)
+			results = append(results, *img)
+		} else if firstErr == nil {
+			firstErr = errs[i]
 		}
 	}
 
-	return results
+	return results, firstErr
 }
 
-func routineEncodeImages(images []image.Image) []*bytes.Reader {
-	encodedImages := make(chan *bytes.Reader, len(images))
-	var wg sync.WaitGroup
+// routineProcessImages runs the filters over each image, skipping any that
+// fail. The processing pool bounds the CPU work across requests. Once ctx is
+// done, images not yet started are skipped.
+func routineProcessImages(ctx context.Context, images []LoadedImage, filters []gift.Filter) []LoadedImage {
+	processed := make([]*LoadedImage, len(images))
+	forEachBounded(len(images), pipelineConcurrency, func(i int) {
+		if ctx.Err() != nil {
+			return
+		}
 
-	for _, img := range images {
-		wg.Add(1)
-		go func(srcImg image.Image) {
-			defer wg.Done()
-			reader, err := encodeImage(srcImg)
-			if err != nil {
-				encodedImages <- nil
-			} else {
-				encodedImages <- reader
-			}
-		}(img)
+		var processedImg image.Image
+		var err error
+		processingPool.run(func() {
+			processedImg, err = processImage(images[i].Image, filters)
+		})
+		if err == nil {
+			processed[i] = &LoadedImage{Image: processedImg, SourceID: images[i].SourceID}
+		}
+	})
+
+	results := []LoadedImage{}
+	for _, img := range processed {
+		if img != nil {
+			results = append(results, *img)
+		}
 	}
 
-	go func() {
-		wg.Wait()
-		close(encodedImages)
-	}()
+	return results
+}
 
-	results := []*bytes.Reader{}
-	for reader := range encodedImages {
-		if reader != nil {
-			results = append(results, reader)
-		}
+// routineEncodeImages prepares an encoding stream for each image, consumed
+// by the upload. Encoding errors surface when reading them.
+func routineEncodeImages(ctx context.Context, images []LoadedImage, opts OutputOptions) []EncodedImage {
+	results := make([]EncodedImage, 0, len(images))
+	for _, img := range images {
+		bounds := img.Image.Bounds()
+		results = append(results, EncodedImage{
+			Reader:   streamEncodeImage(ctx, img.Image, opts),
+			Width:    bounds.Dx(),
+			Height:   bounds.Dy(),
+			Format:   opts.Format,
+			SourceID: img.SourceID,
+		})
 	}
 
 	return results
 }
 
+// requestAborted answers a filter request whose context ended before its
+// results were saved
+func requestAborted(c *fiber.Ctx, err error) error {
+	status, message := fiber.StatusRequestTimeout, "Request cancelled"
+	if errors.Is(err, context.DeadlineExceeded) {
+		status, message = fiber.StatusGatewayTimeout, "Request timed out"
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"data":    nil,
+	})
+}
+
 func ApplyFilterToImage(c *fiber.Ctx) error {
 	userId, err := middleware.CheckUserLoggedIn(c)
 	if err != nil {
@@ -424,16 +1041,38 @@ func ApplyFilterToImage(c *fiber.Ctx) error {
 		})
 	}
 
-	loadImgs := routineLoadImages(cleanImageUrls)
+	if len(cleanImageUrls) > maxBatchSize() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Too many images (max %d per request)", maxBatchSize()),
+			"data":    nil,
+		})
+	}
+
+	// Everything below stops once the request's context ends
+	ctx := c.UserContext()
+
+	loadCtx, span := tracing.Start(ctx, "image.load", attribute.Int("image.count", len(cleanImageUrls)))
+	loadImgs, loadErr := routineLoadImages(loadCtx, cleanImageUrls)
+	span.SetAttributes(attribute.Int("image.loaded", len(loadImgs)))
+	span.End()
+	if err := ctx.Err(); err != nil {
+		return requestAborted(c, err)
+	}
 	if len(loadImgs) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to load any images",
+			"message": fmt.Sprintf("Failed to load any images: %v", loadErr),
 			"data":    nil,
 		})
 	}
 
-	filters, err := parseFilters(c.Queries())
+	var filters []gift.Filter
+	if len(imageData.Filters) > 0 {
+		filters, err = parseFilterOperations(imageData.Filters)
+	} else {
+		filters, err = queryFilters(c)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
@@ -442,41 +1081,79 @@ func ApplyFilterToImage(c *fiber.Ctx) error {
 		})
 	}
 
-	processedImgs := routineProcessImages(loadImgs, filters)
-	if len(processedImgs) == 0 {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+	filters = withWatermark(filters, c.Query("watermark"))
+
+	outputOpts, err := parseOutputOptions(c.Queries())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to process any images",
+			"message": err.Error(),
 			"data":    nil,
 		})
 	}
 
-	encodedReaders := routineEncodeImages(processedImgs)
-	if len(encodedReaders) == 0 {
+	processCtx, span := tracing.Start(ctx, "image.process", attribute.Int("image.filters", len(filters)))
+	processedImgs := routineProcessImages(processCtx, loadImgs, filters)
+	span.End()
+	if err := ctx.Err(); err != nil {
+		return requestAborted(c, err)
+	}
+	if len(processedImgs) == 0 {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
-			"message": "Failed to encode any processed images",
+			"message": "Failed to process any images",
 			"data":    nil,
 		})
 	}
 
-	uploadResults := routineUploadImages(encodedReaders, "processed_image")
+	// ?inline=true returns the results for a preview without storing them
+	if c.QueryBool("inline") {
+		return sendInlineImages(c, processedImgs, outputOpts)
+	}
+
+	// ?temporary=true stores the results without image records, behind
+	// signed URLs that expire with the objects themselves
+	temporary := c.QueryBool("temporary")
+	upload := uploader.UploadProcessedFile
+	if temporary {
+		upload = uploader.UploadTemporaryFile
+	}
+
+	// Encoding streams straight into the uploads, so its spans sit under
+	// the upload's
+	uploadCtx, span := tracing.Start(ctx, "storage.upload", attribute.Int("image.count", len(processedImgs)))
+	encodedImgs := routineEncodeImages(uploadCtx, processedImgs, outputOpts)
+	uploadResults := routineUploadImages(uploadCtx, encodedImgs, "processed_image", outputOpts.extension(), userId, upload)
 	successfulUploads := []UploadResult{}
+	var uploadErr error
 	for _, result := range uploadResults {
 		if result.Error == nil {
 			successfulUploads = append(successfulUploads, result)
+		} else {
+			uploadErr = result.Error
 		}
 	}
+	tracing.End(span, uploadErr)
+
+	// Uploads that finished before the request ended are removed rather than
+	// recorded for a response nobody receives
+	if err := ctx.Err(); err != nil {
+		deleteUploads(successfulUploads)
+		return requestAborted(c, err)
+	}
 
 	if len(successfulUploads) == 0 {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Failed to upload any processed images",
-			"data":    nil,
-		})
+		return storageErrorResponse(c, uploadErr, "Failed to upload any processed images")
+	}
+
+	if temporary {
+		return sendTemporaryResults(c, successfulUploads)
 	}
 
-	saveErrors := routineSaveImageRecords(successfulUploads, userId)
+	saveCtx, span := tracing.Start(ctx, "db.save")
+	saveErrors := routineSaveImageRecords(saveCtx, successfulUploads, userId)
+	span.SetAttributes(attribute.Int("db.errors", len(saveErrors)))
+	span.End()
 	if len(saveErrors) > 0 {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
@@ -488,8 +1165,11 @@ func ApplyFilterToImage(c *fiber.Ctx) error {
 	responseData := make([]fiber.Map, len(successfulUploads))
 	for i, result := range successfulUploads {
 		responseData[i] = fiber.Map{
-			"url":      result.URL,
+			"url":      clientURL(result.URL),
 			"filename": result.Filename,
+			"width":    result.Width,
+			"height":   result.Height,
+			"format":   result.Format,
 		}
 	}
 
@@ -0,0 +1,497 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"golang.org/x/image/webp"
+)
+
+// filterRequest turns imageURLs gray through app
+func filterRequest(t *testing.T, app *fiber.App, target string, imageURLs ...string) *http.Response {
+	t.Helper()
+
+	body, err := json.Marshal(ImageRequest{
+		ImageUrl: imageURLs,
+		Filters:  []FilterOperation{{Filter: "grayscale"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("POST", target, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// A client that goes away while its images load gets nothing stored: the
+// loaded images are dropped instead of being processed and uploaded
+func TestApplyFilterToImageCancelledStoresNothing(t *testing.T) {
+	fs := newFakeStorage(t)
+	mock := newMockDB(t)
+	imageURL := expectStoredImage(mock, 3, 1, "users/1/photo.png")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stubFetch(t, encodePNG(t, testImage(8, 8)), func(*http.Request) { cancel() })
+
+	app := fiber.New()
+	app.Post("/filter", asUser(1), func(c *fiber.Ctx) error {
+		c.SetUserContext(ctx)
+		return c.Next()
+	}, ApplyFilterToImage)
+
+	resp := filterRequest(t, app, "/filter", imageURL)
+	if resp.StatusCode != fiber.StatusRequestTimeout {
+		t.Fatalf("status = %d, want 408", resp.StatusCode)
+	}
+	if got := fs.requests.Load(); got != 0 {
+		t.Fatalf("cancelled request made %d storage requests, want none", got)
+	}
+}
+
+// Many images in one request are fetched at most IMAGE_PIPELINE_CONCURRENCY
+// at a time
+func TestRoutineLoadImagesBoundsFetches(t *testing.T) {
+	concurrency := pipelineConcurrency
+	pipelineConcurrency = 3
+	t.Cleanup(func() { pipelineConcurrency = concurrency })
+
+	const images = 12
+	mock := newMockDB(t)
+	urls := make([]string, images)
+	for i := range urls {
+		urls[i] = expectStoredImage(mock, 1, 1, "users/1/photo.png")
+	}
+
+	var gauge concurrencyGauge
+	stubFetch(t, encodePNG(t, testImage(8, 8)), func(*http.Request) {
+		defer gauge.enter()()
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	loaded, err := routineLoadImages(context.Background(), urls)
+	if err != nil {
+		t.Fatalf("routineLoadImages: %v", err)
+	}
+	if len(loaded) != images {
+		t.Fatalf("loaded %d images, want %d", len(loaded), images)
+	}
+	if got := gauge.highest.Load(); got != 3 {
+		t.Errorf("%d fetches ran at once, want IMAGE_PIPELINE_CONCURRENCY (3)", got)
+	}
+}
+
+func filterApp() *fiber.App {
+	app := fiber.New()
+	app.Post("/filter", asUser(1), ApplyFilterToImage)
+	return app
+}
+
+// isGray reports whether every pixel of img is a shade of gray
+func isGray(img image.Image) bool {
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if r != g || g != b {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ?inline=true sends the processed image back without storing it
+func TestApplyFilterToImageInline(t *testing.T) {
+	fs := newFakeStorage(t)
+	mock := newMockDB(t)
+	imageURL := expectStoredImage(mock, 3, 1, "users/1/photo.png")
+	stubFetch(t, encodePNG(t, testImage(12, 8)), nil)
+
+	resp := filterRequest(t, filterApp(), "/filter?inline=true&format=png", imageURL)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatalf("response isn't a PNG: %v", err)
+	}
+	if img.Bounds().Dx() != 12 || img.Bounds().Dy() != 8 {
+		t.Errorf("bounds = %v, want 12x8", img.Bounds())
+	}
+	if !isGray(img) {
+		t.Error("filter wasn't applied to the inline image")
+	}
+
+	// Only the lookup of the source image, no upload and no records
+	if got := fs.requests.Load(); got != 0 {
+		t.Errorf("inline request made %d storage requests, want none", got)
+	}
+}
+
+// Several inline images come back as a zip with one entry each
+func TestApplyFilterToImageInlineZip(t *testing.T) {
+	fs := newFakeStorage(t)
+	mock := newMockDB(t)
+	urls := []string{
+		expectStoredImage(mock, 3, 1, "users/1/photo.png"),
+		expectStoredImage(mock, 3, 1, "users/1/photo.png"),
+	}
+	stubFetch(t, encodePNG(t, testImage(12, 8)), nil)
+
+	resp := filterRequest(t, filterApp(), "/filter?inline=true&format=png", urls...)
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/zip" {
+		t.Fatalf("Content-Type = %q, want application/zip", got)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("response isn't a zip: %v", err)
+	}
+	if len(archive.File) != len(urls) {
+		t.Fatalf("zip has %d entries, want %d", len(archive.File), len(urls))
+	}
+	for _, entry := range archive.File {
+		f, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s isn't a PNG: %v", entry.Name, err)
+		}
+		if !isGray(img) {
+			t.Errorf("filter wasn't applied to %s", entry.Name)
+		}
+	}
+
+	if got := fs.requests.Load(); got != 0 {
+		t.Errorf("inline request made %d storage requests, want none", got)
+	}
+}
+
+// The inline mode is still behind authentication
+func TestApplyFilterToImageInlineRequiresAuth(t *testing.T) {
+	app := fiber.New()
+	app.Post("/filter", middleware.AuthMiddleware(), ApplyFilterToImage)
+
+	resp := filterRequest(t, app, "/filter?inline=true", uploader.gcsURLBase()+"/users/1/photo.png")
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", resp.StatusCode)
+	}
+}
+
+// softEdge is a gray image whose left half is dark and right half light,
+// with a gradual ramp between them like a downscaled edge
+func softEdge(width, height int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 64 + 128*min(max(x-width/2+4, 0), 8)/8
+			img.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	return img
+}
+
+// edgeContrast is the spread of values around the middle row's edge
+func edgeContrast(img image.Image) int {
+	bounds := img.Bounds()
+	y := bounds.Dy() / 2
+	lowest, highest := 255, 0
+	for x := bounds.Dx()/2 - 6; x < bounds.Dx()/2+6; x++ {
+		v := int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		lowest, highest = min(lowest, v), max(highest, v)
+	}
+	return highest - lowest
+}
+
+func TestSharpenRaisesEdgeContrast(t *testing.T) {
+	filter, err := createFilter("sharpen", "1.5,1.0,0")
+	if err != nil {
+		t.Fatalf("createFilter: %v", err)
+	}
+
+	src := softEdge(32, 8)
+	sharpened, err := processImage(src, []gift.Filter{filter})
+	if err != nil {
+		t.Fatalf("processImage: %v", err)
+	}
+
+	before, after := edgeContrast(src), edgeContrast(sharpened)
+	if after <= before {
+		t.Fatalf("edge contrast went from %d to %d, want it higher", before, after)
+	}
+}
+
+func TestSharpenNamesInvalidComponent(t *testing.T) {
+	tests := []struct {
+		param     string
+		component string
+	}{
+		{"x,1,0", "sigma"},
+		{"1,-1,0", "amount"},
+		{"1,1,abc", "threshold"},
+	}
+
+	for _, tt := range tests {
+		_, err := createFilter("sharpen", tt.param)
+		var filterErr FilterError
+		if !errors.As(err, &filterErr) || !strings.Contains(filterErr.Message, tt.component) {
+			t.Errorf("sharpen=%s: err = %v, want a FilterError naming %s", tt.param, err, tt.component)
+		}
+	}
+}
+
+// Rotating leaves transparent corners, which PNG output keeps and JPEG
+// output would flatten
+func TestRotatedPNGKeepsAlpha(t *testing.T) {
+	filter, err := createFilter("rotate", "45")
+	if err != nil {
+		t.Fatalf("createFilter: %v", err)
+	}
+	rotated, err := processImage(testImage(32, 32), []gift.Filter{filter})
+	if err != nil {
+		t.Fatalf("processImage: %v", err)
+	}
+
+	opts, err := parseOutputOptions(map[string]string{"format": "png"})
+	if err != nil {
+		t.Fatalf("parseOutputOptions: %v", err)
+	}
+	if opts.extension() != ".png" || contentTypeOf("processed_image"+opts.extension()) != "image/png" {
+		t.Errorf("format=png stores %s files as %s", opts.extension(), contentTypeOf("processed_image"+opts.extension()))
+	}
+
+	encoded, err := encodeImage(rotated, opts)
+	if err != nil {
+		t.Fatalf("encodeImage: %v", err)
+	}
+	decoded, err := png.Decode(encoded)
+	if err != nil {
+		t.Fatalf("output isn't a PNG: %v", err)
+	}
+
+	if _, _, _, a := decoded.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("corner alpha = %d, want transparent", a)
+	}
+	bounds := decoded.Bounds()
+	if _, _, _, a := decoded.At(bounds.Dx()/2, bounds.Dy()/2).RGBA(); a != 0xffff {
+		t.Errorf("center alpha = %d, want opaque", a)
+	}
+}
+
+// photo is a deterministic stand-in for a photograph: smooth gradients with
+// fine noise, which lossy encoders have to trade off
+func photo(width, height int) *image.NRGBA {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			noise := rng.IntN(32) - 16
+			img.Set(x, y, color.NRGBA{
+				R: uint8(min(max(x*255/width+noise, 0), 255)),
+				G: uint8(min(max(y*255/height+noise, 0), 255)),
+				B: uint8(min(max((x+y)*255/(width+height)+noise, 0), 255)),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// encodedSize encodes img with the output options of query
+func encodedSize(t testing.TB, img image.Image, query map[string]string) int {
+	t.Helper()
+
+	opts, err := parseOutputOptions(query)
+	if err != nil {
+		t.Fatalf("parseOutputOptions(%v): %v", query, err)
+	}
+	encoded, err := encodeImage(img, opts)
+	if err != nil {
+		t.Fatalf("encodeImage(%v): %v", query, err)
+	}
+	return int(encoded.Size())
+}
+
+func TestQualityParam(t *testing.T) {
+	opts, err := parseOutputOptions(map[string]string{"format": "jpeg"})
+	if err != nil || opts.Quality != JPEGQuality {
+		t.Fatalf("default quality = %d, %v, want %d", opts.Quality, err, JPEGQuality)
+	}
+
+	tests := []struct {
+		param string
+		want  int
+	}{
+		{"60", 60},
+		{"0", MinQuality},
+		{"101", MaxQuality},
+		{"high", JPEGQuality},
+	}
+	for _, tt := range tests {
+		opts, err := parseOutputOptions(map[string]string{"format": "jpeg", "quality": tt.param})
+		if err != nil || opts.Quality != tt.want {
+			t.Errorf("quality=%s: got %d, %v, want %d", tt.param, opts.Quality, err, tt.want)
+		}
+	}
+}
+
+func TestLowerQualityIsSmaller(t *testing.T) {
+	src := photo(256, 256)
+	full := encodedSize(t, src, map[string]string{"format": "jpeg"})
+	low := encodedSize(t, src, map[string]string{"format": "jpeg", "quality": "60"})
+	if low >= full {
+		t.Fatalf("quality=60 is %d bytes, default quality %d bytes, want it smaller", low, full)
+	}
+}
+
+// Requests encoding at the same time each keep their own quality
+func TestQualityPerRequest(t *testing.T) {
+	images := []LoadedImage{{Image: photo(128, 128)}}
+	sizes := make(map[string]int)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, quality := range []string{"30", "95"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opts, err := parseOutputOptions(map[string]string{"format": "jpeg", "quality": quality})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			encoded := routineEncodeImages(t.Context(), images, opts)[0]
+			data, err := io.ReadAll(encoded.Reader)
+			encoded.Reader.Close()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			sizes[quality] = len(data)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if sizes["30"] >= sizes["95"] {
+		t.Fatalf("quality=30 is %d bytes, quality=95 %d bytes, want it smaller", sizes["30"], sizes["95"])
+	}
+}
+
+func TestWebPOutput(t *testing.T) {
+	opts, err := parseOutputOptions(map[string]string{"format": "webp"})
+	if err != nil {
+		t.Fatalf("parseOutputOptions: %v", err)
+	}
+	if opts.Quality != DefaultWebPQuality {
+		t.Errorf("quality = %d, want %d", opts.Quality, DefaultWebPQuality)
+	}
+	filename := "processed_image" + opts.extension()
+	if filename != "processed_image.webp" || contentTypeOf(filename) != "image/webp" {
+		t.Errorf("format=webp stores %s as %s", filename, contentTypeOf(filename))
+	}
+
+	encoded, err := encodeImage(photo(64, 48), opts)
+	if err != nil {
+		t.Fatalf("encodeImage: %v", err)
+	}
+	decoded, err := webp.Decode(encoded)
+	if err != nil {
+		t.Fatalf("output isn't WebP: %v", err)
+	}
+	if decoded.Bounds().Dx() != 64 || decoded.Bounds().Dy() != 48 {
+		t.Errorf("bounds = %v, want 64x48", decoded.Bounds())
+	}
+}
+
+// webp_lossless keeps every pixel, for graphics
+func TestWebPLosslessKeepsPixels(t *testing.T) {
+	src := photo(32, 32)
+	opts, err := parseOutputOptions(map[string]string{"format": "webp", "webp_lossless": "true"})
+	if err != nil {
+		t.Fatalf("parseOutputOptions: %v", err)
+	}
+	encoded, err := encodeImage(src, opts)
+	if err != nil {
+		t.Fatalf("encodeImage: %v", err)
+	}
+	decoded, err := webp.Decode(encoded)
+	if err != nil {
+		t.Fatalf("output isn't WebP: %v", err)
+	}
+
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			if got, want := color.NRGBAModel.Convert(decoded.At(x, y)), src.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+
+	if _, err := parseOutputOptions(map[string]string{"format": "jpeg", "webp_lossless": "true"}); err == nil {
+		t.Error("webp_lossless was accepted for JPEG output")
+	}
+}
+
+// BenchmarkEncodeFormats compares the output size of each format for the
+// same photo-like image, reported as bytes/image. Run with
+// go test -bench EncodeFormats -run '^$' ./handlers
+func BenchmarkEncodeFormats(b *testing.B) {
+	src := photo(1024, 768)
+	formats := []struct {
+		name  string
+		query map[string]string
+	}{
+		{"jpeg-q90", map[string]string{"format": "jpeg"}},
+		{"webp-q80", map[string]string{"format": "webp"}},
+		{"webp-q90", map[string]string{"format": "webp", "quality": "90"}},
+		{"webp-lossless", map[string]string{"format": "webp", "webp_lossless": "true"}},
+		{"png", map[string]string{"format": "png"}},
+	}
+
+	for _, format := range formats {
+		b.Run(format.name, func(b *testing.B) {
+			var size int
+			for b.Loop() {
+				size = encodedSize(b, src, format.query)
+			}
+			b.ReportMetric(float64(size), "bytes/image")
+		})
+	}
+}
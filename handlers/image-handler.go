@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"mime/multipart"
@@ -19,6 +20,9 @@ import (
 	"github.com/krishkalaria12/snap-serve/database"
 	"github.com/krishkalaria12/snap-serve/middleware"
 	"github.com/krishkalaria12/snap-serve/models"
+	"github.com/krishkalaria12/snap-serve/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/api/option"
 	"gorm.io/gorm"
 )
 
@@ -26,47 +30,148 @@ var projectId string = config.Config("GSC_PROJECT_ID")
 var bucketName string = config.Config("GSC_BUCKET_NAME")
 
 type ClientUploader struct {
+	// cl is created on first use, see client
+	clOnce     sync.Once
 	cl         *storage.Client
+	clErr      error
 	projectID  string
 	bucketName string
 	uploadPath string
+	// publicURLBase is prepended to object paths to build their public URLs
+	publicURLBase string
 }
 
 type UploadResult struct {
-	URL      string
-	Filename string
-	Error    error
+	URL          string
+	ProcessedURL string
+	Filename     string
+	ObjectPath   string
+	Size         int64
+	PHash        string
+	BlurHash     string
+	Width        int
+	Height       int
+	Format       string
+	Source       string
+	Private      bool
+	// SourceID is the stored image this upload was derived from, if any
+	SourceID uint
+	Error        error
 }
 
-var uploader *ClientUploader
+// displayURL is the URL handed back to clients, preferring the processed
+// version when the original was kept alongside it
+func (r UploadResult) displayURL() string {
+	if r.ProcessedURL != "" {
+		return clientURL(r.ProcessedURL)
+	}
+	return clientURL(r.URL)
+}
 
-func init() {
-	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
-		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "./credentials.json")
+var uploader = newClientUploader(nil)
+
+// ImageInsertBatchSize is the number of image records written per INSERT
+const ImageInsertBatchSize = 100
+
+// storageClientOptions picks the storage credentials: service account JSON
+// from GSC_CREDENTIALS_JSON if set, then ./credentials.json, and otherwise
+// whatever Application Default Credentials find (GOOGLE_APPLICATION_CREDENTIALS,
+// the metadata server, ...).
+func storageClientOptions() []option.ClientOption {
+	if credentialsJSON := config.ConfigDefault("GSC_CREDENTIALS_JSON", ""); credentialsJSON != "" {
+		return []option.ClientOption{option.WithCredentialsJSON([]byte(credentialsJSON))}
 	}
 
-	client, err := storage.NewClient(context.Background())
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+	if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") == "" {
+		if _, err := os.Stat("./credentials.json"); err == nil {
+			return []option.ClientOption{option.WithCredentialsFile("./credentials.json")}
+		}
 	}
-	uploader = &ClientUploader{
+
+	return nil
+}
+
+// newClientUploader sets up an uploader for the configured bucket. With a nil
+// client, one is created from storageClientOptions on first use.
+func newClientUploader(client *storage.Client) *ClientUploader {
+	c := &ClientUploader{
 		cl:         client,
 		bucketName: bucketName,
 		projectID:  projectId,
 		uploadPath: "images/",
 	}
+	c.publicURLBase = normalizeImageURL(config.ConfigDefault("PUBLIC_URL_BASE", c.gcsURLBase()))
+	return c
 }
 
-func uploadImageToDB(url, filename string, userID uint) error {
-	db := database.GetDB()
+// SetStorageClient makes all storage operations use client, e.g. one pointed
+// at a fake server in tests. It isn't safe to call while requests are served.
+func SetStorageClient(client *storage.Client) {
+	uploader = newClientUploader(client)
+}
+
+// client returns the storage client, creating it on first use. A client that
+// can't be created, say for missing credentials, fails every storage
+// operation with ErrStorageUnavailable instead of stopping the server.
+func (c *ClientUploader) client() (*storage.Client, error) {
+	c.clOnce.Do(func() {
+		if c.cl != nil {
+			return
+		}
+
+		client, err := storage.NewClient(context.Background(), storageClientOptions()...)
+		if err != nil {
+			log.Printf("Failed to create storage client: %v", err)
+			c.clErr = &StorageError{Kind: ErrStorageUnavailable, Op: "storage.NewClient", Err: err}
+			return
+		}
+		c.cl = client
+	})
+
+	return c.cl, c.clErr
+}
+
+// bucket returns the handle of the configured bucket
+func (c *ClientUploader) bucket() (*storage.BucketHandle, error) {
+	client, err := c.client()
+	if err != nil {
+		return nil, err
+	}
+	return client.Bucket(c.bucketName), nil
+}
+
+// sourceImageID turns an optional source ID into the nullable column value
+func sourceImageID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
 
-	image := models.Image{
-		UserID:      userID,
-		Filename:    filename,
-		OriginalURL: url,
-		Status:      "completed",
+// newImageRecord builds the database record for a stored upload
+func newImageRecord(result UploadResult, userID uint) models.Image {
+	return models.Image{
+		UserID:        userID,
+		Filename:      result.Filename,
+		OriginalURL:   normalizeImageURL(result.URL),
+		ProcessedURL:  normalizeImageURL(result.ProcessedURL),
+		ObjectPath:    result.ObjectPath,
+		SizeBytes:     result.Size,
+		PHash:         result.PHash,
+		BlurHash:      result.BlurHash,
+		Private:       result.Private,
+		Source:        result.Source,
+		SourceImageID: sourceImageID(result.SourceID),
+		Status:        models.ImageStatusCompleted,
 	}
+}
 
+// uploadImageToDB records an uploaded image. ctx only carries the trace: the
+// object is already stored, so the record is saved even if the request ends.
+func uploadImageToDB(ctx context.Context, result UploadResult, userID uint) error {
+	db := database.GetDB().WithContext(context.WithoutCancel(ctx))
+
+	image := newImageRecord(result, userID)
 	if err := db.Create(&image).Error; err != nil {
 		return err
 	}
@@ -78,7 +183,10 @@ func GetImageFromDB(url string) (models.Image, error) {
 	db := database.GetDB()
 	var image models.Image
 
-	result := db.Where("original_url = ?", url).First(&image)
+	// Signed URLs carry their signature in the query string, the stored URL
+	// is the unsigned one, normalized
+	candidates := imageURLCandidates(url)
+	result := db.Where("original_url IN ? OR processed_url IN ?", candidates, candidates).First(&image)
 
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
@@ -91,6 +199,80 @@ func GetImageFromDB(url string) (models.Image, error) {
 	return image, nil
 }
 
+var (
+	ErrImageNotFound  = errors.New("image not found")
+	ErrImageForbidden = errors.New("image belongs to another user")
+)
+
+// getOwnedImage looks up an image by ID, making sure it belongs to userID.
+// Pass middleware.DB so the lookup joins the request's transaction.
+func getOwnedImage(db *gorm.DB, id string, userID uint) (models.Image, error) {
+	var image models.Image
+
+	if err := db.First(&image, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return image, ErrImageNotFound
+		}
+		return image, err
+	}
+
+	if image.UserID != userID {
+		return image, ErrImageForbidden
+	}
+
+	return image, nil
+}
+
+// imageLookupError writes the response for an error from getOwnedImage
+func imageLookupError(c *fiber.Ctx, err error) error {
+	status := fiber.StatusInternalServerError
+	message := "Database error"
+
+	switch {
+	case errors.Is(err, ErrImageNotFound):
+		status = fiber.StatusNotFound
+		message = "Image not found"
+	case errors.Is(err, ErrImageForbidden):
+		status = fiber.StatusForbidden
+		message = "You don't have access to this image"
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"data":    nil,
+	})
+}
+
+// downloadStoredImage fetches the raw bytes of an image's object
+func downloadStoredImage(img models.Image) ([]byte, error) {
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return nil, fmt.Errorf("storage object unknown")
+	}
+
+	return uploader.Download(objectPath)
+}
+
+// decodeStoredImage downloads an image's object from storage and decodes it
+func decodeStoredImage(img models.Image) (image.Image, string, error) {
+	data, err := downloadStoredImage(img)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var decoded image.Image
+	var format string
+	processingPool.run(func() {
+		decoded, format, err = decodeImage(bytes.NewReader(data))
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return decoded, format, nil
+}
+
 func UploadImage(c *fiber.Ctx) error {
 	userID, err := middleware.CheckUserLoggedIn(c)
 	if err != nil {
@@ -110,6 +292,19 @@ func UploadImage(c *fiber.Ctx) error {
 		})
 	}
 
+	// visibility may be sent as a form field or in the query
+	visibility := c.FormValue("visibility")
+	if visibility == "" {
+		visibility = c.Query("visibility")
+	}
+	if visibility, err = parseVisibility(visibility); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
 	blobFile, err := file.Open()
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -120,16 +315,18 @@ func UploadImage(c *fiber.Ctx) error {
 	}
 	defer blobFile.Close() // Important: close the file
 
-	url, originalFilename, err := uploader.UploadFile(blobFile, file.Filename)
+	uploadCtx, span := tracing.Start(c.UserContext(), "storage.upload", attribute.Int64("file.size", file.Size))
+	result, err := storeUploadedFile(uploadCtx, blobFile, file.Filename, userID, SourceUpload, visibility)
+	tracing.End(span, err)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"status":  "error",
-			"message": "Error uploading the file",
-			"data":    nil,
-		})
+		return storageErrorResponse(c, err, "Error uploading the file")
 	}
+	result.Private = visibility == VisibilityPrivate
 
-	if err := uploadImageToDB(url, originalFilename, userID); err != nil {
+	saveCtx, span := tracing.Start(c.UserContext(), "db.save")
+	err = uploadImageToDB(saveCtx, result, userID)
+	tracing.End(span, err)
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "error",
 			"message": "Error saving to database",
@@ -137,10 +334,16 @@ func UploadImage(c *fiber.Ctx) error {
 		})
 	}
 
+	if result.Private {
+		if result, err = result.signed(); err != nil {
+			return storageErrorResponse(c, err, "Error signing the file URL")
+		}
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{
 		"status":  "success",
 		"message": "Successfully uploaded the file",
-		"data":    url,
+		"data":    result.displayURL(),
 	})
 }
 
@@ -154,7 +357,10 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 		})
 	}
 
-	form, err := c.MultipartForm()
+	form, err := parseMultipartForm(c)
+	if errors.Is(err, errUploadTooLarge) {
+		return uploadTooLargeResponse(c)
+	}
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "error",
@@ -162,6 +368,8 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 			"data":    nil,
 		})
 	}
+	// Files over the memory limit were spooled to disk
+	defer form.RemoveAll()
 
 	files := form.File["images"]
 	if len(files) == 0 {
@@ -172,12 +380,30 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 		})
 	}
 
-	uploadResults := routineUploadMultipleImages(files)
+	if len(files) > maxBatchSize() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("Too many files (max %d per request)", maxBatchSize()),
+			"data":    nil,
+		})
+	}
+
+	// Fail the whole batch up front rather than every file when storage
+	// isn't configured
+	if _, err := uploader.client(); err != nil {
+		return storageErrorResponse(c, err, "Error uploading the files")
+	}
+
+	uploadResults := routineUploadMultipleImages(c.UserContext(), files, userID)
 	
 	successfulUploads := []UploadResult{}
 	var uploadErrors []string
+	timedOut := []string{}
 	
 	for _, result := range uploadResults {
+		if errors.Is(result.Error, errBatchUploadTimeout) {
+			timedOut = append(timedOut, result.Filename)
+		}
 		if result.Error != nil {
 			uploadErrors = append(uploadErrors, fmt.Sprintf("Error uploading %s: %v", result.Filename, result.Error))
 		} else {
@@ -186,7 +412,7 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 	}
 
 	if len(successfulUploads) > 0 {
-		dbErrors := routineSaveImageRecords(successfulUploads, userID)
+		dbErrors := routineSaveImageRecords(c.UserContext(), successfulUploads, userID)
 		if len(dbErrors) > 0 {
 			for _, dbErr := range dbErrors {
 				uploadErrors = append(uploadErrors, fmt.Sprintf("Database error: %v", dbErr))
@@ -196,7 +422,7 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 
 	urls := make([]string, 0, len(successfulUploads))
 	for _, result := range successfulUploads {
-		urls = append(urls, result.URL)
+		urls = append(urls, result.displayURL())
 	}
 
 	responseData := fiber.Map{
@@ -205,6 +431,10 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 		"total_count":   len(files),
 	}
 
+	if len(timedOut) > 0 {
+		responseData["timed_out"] = timedOut
+	}
+
 	if len(uploadErrors) > 0 {
 		responseData["errors"] = uploadErrors
 		return c.Status(fiber.StatusPartialContent).JSON(fiber.Map{
@@ -221,12 +451,9 @@ func UploadMultipleImages(c *fiber.Ctx) error {
 	})
 }
 
-// Update your UploadFile method signature
-func (c *ClientUploader) UploadProcessedFile(file io.Reader, object string) (string, string, error) {
-	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
-	defer cancel()
-
+// UploadProcessedFile uploads an in-memory object and returns the public URL
+// along with the attributes of the stored object
+func (c *ClientUploader) UploadProcessedFile(ctx context.Context, file io.Reader, object string, metadata ObjectMetadata) (string, *storage.ObjectAttrs, error) {
 	// Better unique filename generation
 	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
 	uniqueFilename := timestamp + "_" + object
@@ -234,23 +461,44 @@ func (c *ClientUploader) UploadProcessedFile(file io.Reader, object string) (str
 	// Full object path
 	objectPath := c.uploadPath + uniqueFilename
 
-	// Upload an object with storage.Writer.
-	wc := c.cl.Bucket(c.bucketName).Object(objectPath).NewWriter(ctx)
+	attrs, err := c.writeProcessedObject(ctx, file, objectPath, metadata)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return c.publicURL(objectPath), attrs, nil
+}
+
+// writeProcessedObject writes an encoded image to objectPath. Processed
+// objects are named after their output format, which gives their content
+// type.
+func (c *ClientUploader) writeProcessedObject(ctx context.Context, file io.Reader, objectPath string, metadata ObjectMetadata) (*storage.ObjectAttrs, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	// Upload an object with storage.Writer
+	wc := bucket.Object(objectPath).NewWriter(ctx)
+	wc.ContentType = contentTypeOf(objectPath)
+	wc.Metadata = metadata.toMap()
+	wc.PredefinedACL = predefinedACL(metadata.Visibility)
 	if _, err := io.Copy(wc, file); err != nil {
-		return "", "", fmt.Errorf("io.Copy: %v", err)
+		return nil, classifyStorageError("io.Copy", err)
 	}
 	if err := wc.Close(); err != nil {
-		return "", "", fmt.Errorf("Writer.Close: %v", err)
+		return nil, classifyStorageError("Writer.Close", err)
 	}
 
-	// Generate the public URL
-	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", c.bucketName, objectPath)
-	return url, object, nil
+	return wc.Attrs(), nil
 }
 
-// UploadFile uploads an object and returns the public URL
-func (c *ClientUploader) UploadFile(file multipart.File, originalFilename string) (string, string, error) {
-	ctx := context.Background()
+// UploadFile uploads an object and returns the public URL along with the
+// attributes of the stored object
+func (c *ClientUploader) UploadFile(ctx context.Context, file io.Reader, originalFilename string, metadata ObjectMetadata) (string, *storage.ObjectAttrs, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
 	defer cancel()
 
@@ -261,18 +509,23 @@ func (c *ClientUploader) UploadFile(file multipart.File, originalFilename string
 	// Full object path
 	objectPath := c.uploadPath + uniqueFilename
 
+	bucket, err := c.bucket()
+	if err != nil {
+		return "", nil, err
+	}
+
 	// Upload an object with storage.Writer.
-	wc := c.cl.Bucket(c.bucketName).Object(objectPath).NewWriter(ctx)
+	wc := bucket.Object(objectPath).NewWriter(ctx)
+	wc.Metadata = metadata.toMap()
+	wc.PredefinedACL = predefinedACL(metadata.Visibility)
 	if _, err := io.Copy(wc, file); err != nil {
-		return "", "", fmt.Errorf("io.Copy: %v", err)
+		return "", nil, classifyStorageError("io.Copy", err)
 	}
 	if err := wc.Close(); err != nil {
-		return "", "", fmt.Errorf("Writer.Close: %v", err)
+		return "", nil, classifyStorageError("Writer.Close", err)
 	}
 
-	// Generate the public URL
-	url := fmt.Sprintf("https://storage.googleapis.com/%s/%s", c.bucketName, objectPath)
-	return url, originalFilename, nil
+	return c.publicURL(objectPath), wc.Attrs(), nil
 }
 
 // Alternative: Generate signed URL (if bucket is private)
@@ -285,90 +538,244 @@ func (c *ClientUploader) UploadFileWithSignedURL(file multipart.File, object str
 	object = timestamp + "_" + object
 	objectPath := c.uploadPath + object
 
+	bucket, err := c.bucket()
+	if err != nil {
+		return "", err
+	}
+
 	// Upload file
-	wc := c.cl.Bucket(c.bucketName).Object(objectPath).NewWriter(ctx)
+	wc := bucket.Object(objectPath).NewWriter(ctx)
 	if _, err := io.Copy(wc, file); err != nil {
-		return "", fmt.Errorf("io.Copy: %v", err)
+		return "", classifyStorageError("io.Copy", err)
 	}
 	if err := wc.Close(); err != nil {
-		return "", fmt.Errorf("Writer.Close: %v", err)
+		return "", classifyStorageError("Writer.Close", err)
+	}
+
+	return c.SignedURL(objectPath)
+}
+
+// Download reads the full contents of a stored object
+func (c *ClientUploader) Download(objectPath string) ([]byte, error) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := bucket.Object(objectPath).NewReader(ctx)
+	if err != nil {
+		return nil, classifyStorageError("Object.NewReader", err)
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, classifyStorageError("io.ReadAll", err)
+	}
+
+	return data, nil
+}
+
+// DownloadStream opens a stored object for reading without buffering it.
+// Reading fails once ctx ends. The caller must close the reader, which also
+// releases the download.
+func (c *ClientUploader) DownloadStream(ctx context.Context, objectPath string) (*storage.Reader, error) {
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := bucket.Object(objectPath).NewReader(ctx)
+	if err != nil {
+		return nil, classifyStorageError("Object.NewReader", err)
+	}
+
+	return rc, nil
+}
+
+// DownloadRangeStream reads length bytes of a stored object from offset. The
+// generation pins the read to the version of the object the caller already
+// checked, so a concurrent replace fails the read instead of mixing content.
+func (c *ClientUploader) DownloadRangeStream(ctx context.Context, objectPath string, generation, offset, length int64) (*storage.Reader, error) {
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := bucket.Object(objectPath).Generation(generation).NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, classifyStorageError("Object.NewRangeReader", err)
 	}
 
-	// Generate signed URL (valid for 24 hours)
-	opts := &storage.SignedURLOptions{
-		Scheme:  storage.SigningSchemeV4,
-		Method:  "GET",
-		Expires: time.Now().Add(24 * time.Hour),
+	return rc, nil
+}
+
+// ReplaceFile overwrites an existing object in place, keeping its path,
+// custom metadata and access. contentType is the type of the new content,
+// empty to keep the stored one.
+func (c *ClientUploader) ReplaceFile(file io.Reader, objectPath, contentType string) (*storage.ObjectAttrs, error) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
 	}
 
-	signedURL, err := c.cl.Bucket(c.bucketName).SignedURL(objectPath, opts)
+	object := bucket.Object(objectPath)
+	existing, err := object.Attrs(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate signed URL: %v", err)
+		return nil, classifyStorageError("Object.Attrs", err)
+	}
+
+	wc := object.NewWriter(ctx)
+	wc.Metadata = existing.Metadata
+	wc.ContentType = existing.ContentType
+	if contentType != "" {
+		wc.ContentType = contentType
+	}
+	// A rewrite gets the bucket's default ACL unless told otherwise
+	wc.PredefinedACL = predefinedACL(existing.Metadata["visibility"])
+	if wc.PredefinedACL == "" && len(existing.ACL) > 0 {
+		wc.ACL = existing.ACL
+	}
+	if _, err := io.Copy(wc, file); err != nil {
+		return nil, classifyStorageError("io.Copy", err)
+	}
+	if err := wc.Close(); err != nil {
+		return nil, classifyStorageError("Writer.Close", err)
 	}
 
-	return signedURL, nil
+	return wc.Attrs(), nil
+}
+
+// Attrs fetches the attributes of a stored object
+func (c *ClientUploader) Attrs(objectPath string) (*storage.ObjectAttrs, error) {
+	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := bucket.Object(objectPath).Attrs(ctx)
+	if err != nil {
+		return nil, classifyStorageError("Object.Attrs", err)
+	}
+
+	return attrs, nil
 }
 
 // Make bucket/object public (call this once for public access)
 func (c *ClientUploader) MakeBucketPublic() error {
 	ctx := context.Background()
-	bucket := c.cl.Bucket(c.bucketName)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*50)
+	defer cancel()
 
-	policy, err := bucket.IAM().Policy(ctx)
+	bucket, err := c.bucket()
 	if err != nil {
 		return err
 	}
 
+	policy, err := bucket.IAM().Policy(ctx)
+	if err != nil {
+		return classifyStorageError("IAM.Policy", err)
+	}
+
 	// Add allUsers with objectViewer role
 	policy.Add("allUsers", "roles/storage.objectViewer")
 
 	if err := bucket.IAM().SetPolicy(ctx, policy); err != nil {
-		return err
+		return classifyStorageError("IAM.SetPolicy", err)
 	}
 
 	return nil
 }
 
-func routineUploadImages(readers []*bytes.Reader, baseFilename string) []UploadResult {
-	uploadResults := make(chan UploadResult, len(readers))
-	var wg sync.WaitGroup
+// objectUploader stores an encoded image and returns the URL to hand out for
+// it, like ClientUploader.UploadProcessedFile
+type objectUploader func(ctx context.Context, file io.Reader, object string, metadata ObjectMetadata) (string, *storage.ObjectAttrs, error)
+
+// routineUploadImages uploads the encoded images. Cancelling ctx aborts the
+// uploads in flight, which then leave no object, and skips the rest.
+func routineUploadImages(ctx context.Context, images []EncodedImage, baseFilename, extension string, userId uint, upload objectUploader) []UploadResult {
+	results := make([]UploadResult, len(images))
+	forEachBounded(len(images), pipelineConcurrency, func(i int) {
+		img := images[i]
+		// Closing the stream stops the encoder if the upload gave up early
+		defer img.Reader.Close()
+		filename := fmt.Sprintf("%s_%d%s", baseFilename, i, extension)
+		if err := ctx.Err(); err != nil {
+			results[i] = UploadResult{Filename: filename, Error: err}
+			return
+		}
+		url, attrs, err := upload(ctx, img.Reader, filename, ObjectMetadata{
+			OwnerID:       userId,
+			Source:        SourceFilter,
+			SourceImageID: img.SourceID,
+		})
+		if err != nil {
+			results[i] = UploadResult{Filename: filename, Error: err}
+			return
+		}
+		results[i] = UploadResult{
+			URL:        url,
+			Filename:   filename,
+			ObjectPath: attrs.Name,
+			Size:       attrs.Size,
+			Width:      img.Width,
+			Height:     img.Height,
+			Format:     img.Format,
+			Source:     SourceFilter,
+			SourceID:   img.SourceID,
+		}
+	})
 
-	for i, reader := range readers {
-		wg.Add(1)
-		go func(r *bytes.Reader, index int) {
-			defer wg.Done()
-			filename := fmt.Sprintf("%s_%d.jpg", baseFilename, index)
-			url, uploadedFilename, err := uploader.UploadProcessedFile(r, filename)
-			uploadResults <- UploadResult{
-				URL:      url,
-				Filename: uploadedFilename,
-				Error:    err,
-			}
-		}(reader, i)
-	}
+	return results
+}
 
-	go func() {
-		wg.Wait()
-		close(uploadResults)
-	}()
+// batchUploadConcurrency caps how many files of one batch upload are written
+// to storage at once
+var batchUploadConcurrency = config.ConfigInt("BATCH_UPLOAD_CONCURRENCY", 8)
 
-	results := []UploadResult{}
-	for result := range uploadResults {
-		results = append(results, result)
-	}
+// batchUploadTimeout bounds a whole batch upload, so a few slow files can't
+// hold up the request
+var batchUploadTimeout = time.Duration(config.ConfigInt("BATCH_UPLOAD_TIMEOUT_SECONDS", 120)) * time.Second
 
-	return results
-}
+var errBatchUploadTimeout = errors.New("upload timed out")
+
+func routineUploadMultipleImages(ctx context.Context, files []*multipart.FileHeader, userID uint) []UploadResult {
+	ctx, cancel := context.WithTimeout(ctx, batchUploadTimeout)
+	defer cancel()
 
-func routineUploadMultipleImages(files []*multipart.FileHeader) []UploadResult {
 	uploadResults := make(chan UploadResult, len(files))
+	slots := make(chan struct{}, max(batchUploadConcurrency, 1))
 	var wg sync.WaitGroup
 
 	for _, fileHeader := range files {
 		wg.Add(1)
 		go func(fh *multipart.FileHeader) {
 			defer wg.Done()
-			
+
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+				uploadResults <- UploadResult{Filename: fh.Filename, Error: errBatchUploadTimeout}
+				return
+			}
+			if ctx.Err() != nil {
+				uploadResults <- UploadResult{Filename: fh.Filename, Error: errBatchUploadTimeout}
+				return
+			}
+
 			file, err := fh.Open()
 			if err != nil {
 				uploadResults <- UploadResult{
@@ -380,12 +787,11 @@ func routineUploadMultipleImages(files []*multipart.FileHeader) []UploadResult {
 			}
 			defer file.Close()
 
-			url, uploadedFilename, err := uploader.UploadFile(file, fh.Filename)
-			uploadResults <- UploadResult{
-				URL:      url,
-				Filename: uploadedFilename,
-				Error:    err,
+			result, err := storeUploadedFile(ctx, file, fh.Filename, userID, SourceUpload, "")
+			if err != nil && ctx.Err() != nil {
+				result.Error = errBatchUploadTimeout
 			}
+			uploadResults <- result
 		}(fileHeader)
 	}
 
@@ -402,40 +808,34 @@ func routineUploadMultipleImages(files []*multipart.FileHeader) []UploadResult {
 	return results
 }
 
-func routineSaveImageRecords(uploadResults []UploadResult, userId uint) []error {
-	saveErrors := make(chan error, len(uploadResults))
-	var wg sync.WaitGroup
-
+// routineSaveImageRecords inserts the records for all successful uploads in
+// batched multi-row INSERTs, so a large upload doesn't take a connection and
+// a round-trip per image. The insert is all or nothing, so it is retried as a
+// whole after a transient failure.
+func routineSaveImageRecords(ctx context.Context, uploadResults []UploadResult, userId uint) []error {
+	pending := []UploadResult{}
 	for _, result := range uploadResults {
-		if result.Error != nil {
-			continue
+		if result.Error == nil {
+			pending = append(pending, result)
 		}
-		wg.Add(1)
-		go func(url, filename string) {
-			defer wg.Done()
-			err := uploadImageToDB(url, filename, userId)
-			saveErrors <- err
-		}(result.URL, result.Filename)
 	}
 
-	go func() {
-		wg.Wait()
-		close(saveErrors)
-	}()
+	if len(pending) == 0 {
+		return nil
+	}
 
-	var errors []error
-	for err := range saveErrors {
-		if err != nil {
-			errors = append(errors, err)
+	db := database.GetDB().WithContext(context.WithoutCancel(ctx))
+	err := database.WithRetry(func() error {
+		// Fresh records each attempt, a failed insert may have filled in IDs
+		records := make([]models.Image, 0, len(pending))
+		for _, result := range pending {
+			records = append(records, newImageRecord(result, userId))
 		}
+		return db.CreateInBatches(&records, ImageInsertBatchSize).Error
+	})
+	if err != nil {
+		return []error{err}
 	}
 
-	return errors
+	return nil
 }
-
-// func MakeBucketPublic() error {
-// 	if uploader == nil {
-// 		return fmt.Errorf("uploader not initialized")
-// 	}
-// 	return uploader.MakeBucketPublic()
-// }
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestUploadMultipleImagesBoundsConcurrency(t *testing.T) {
+	fs := newFakeStorage(t)
+	fs.uploadDelay = 50 * time.Millisecond
+
+	concurrency := batchUploadConcurrency
+	batchUploadConcurrency = 2
+	t.Cleanup(func() { batchUploadConcurrency = concurrency })
+
+	const files = 6
+	mock := newMockDB(t)
+	ids := sqlmock.NewRows([]string{"id"})
+	for i := 1; i <= files; i++ {
+		ids.AddRow(i)
+	}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "images"`).WillReturnRows(ids)
+	mock.ExpectCommit()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for i := range files {
+		part, err := form.CreateFormFile("images", fmt.Sprintf("image-%d.png", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(encodePNG(t, testImage(8+i, 8)))
+	}
+	form.Close()
+
+	app := fiber.New()
+	app.Post("/upload/batch", asUser(1), UploadMultipleImages)
+
+	req := httptest.NewRequest("POST", "/upload/batch", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Data struct {
+			SuccessCount int      `json:"success_count"`
+			Errors       []string `json:"errors"`
+		} `json:"data"`
+	}
+	decodeResponse(t, resp, &result)
+
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200 (errors: %v)", resp.StatusCode, result.Data.Errors)
+	}
+	if result.Data.SuccessCount != files {
+		t.Errorf("success_count = %d, want %d", result.Data.SuccessCount, files)
+	}
+	if got := fs.uploads.Load(); got != files {
+		t.Errorf("uploads = %d, want %d", got, files)
+	}
+	if got := fs.maxInFlight.Load(); got != 2 {
+		t.Errorf("at most %d uploads ran at once, want exactly BATCH_UPLOAD_CONCURRENCY (2)", got)
+	}
+}
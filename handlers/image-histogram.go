@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+const (
+	DefaultHistogramBuckets = 256
+	MaxHistogramBuckets     = 256
+)
+
+// Histogram counts the pixels of an image per brightness bucket of each
+// channel. Bucket i covers values [i*256/buckets, (i+1)*256/buckets).
+type Histogram struct {
+	Buckets   int     `json:"buckets"`
+	Pixels    int     `json:"pixels"`
+	Red       []int64 `json:"red"`
+	Green     []int64 `json:"green"`
+	Blue      []int64 `json:"blue"`
+	Luminance []int64 `json:"luminance"`
+}
+
+// computeHistogram counts every visible pixel of img. Fully transparent
+// pixels are skipped since they have no color to adjust.
+func computeHistogram(img image.Image, buckets int) Histogram {
+	h := Histogram{
+		Buckets:   buckets,
+		Red:       make([]int64, buckets),
+		Green:     make([]int64, buckets),
+		Blue:      make([]int64, buckets),
+		Luminance: make([]int64, buckets),
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a == 0 {
+				continue
+			}
+
+			// Rec. 601 luma, as shown by most photo editors
+			luma := (299*r + 587*g + 114*b) / 1000
+
+			h.Red[int(r>>8)*buckets/256]++
+			h.Green[int(g>>8)*buckets/256]++
+			h.Blue[int(b>>8)*buckets/256]++
+			h.Luminance[int(luma>>8)*buckets/256]++
+			h.Pixels++
+		}
+	}
+
+	return h
+}
+
+// GetImageHistogram returns the red, green, blue and luminance histograms of
+// one of the user's images, with ?buckets= values per channel
+func GetImageHistogram(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	buckets := DefaultHistogramBuckets
+	if param := c.Query("buckets"); param != "" {
+		buckets, err = parseIntParam(param, "buckets")
+		if err != nil || buckets < 1 || buckets > MaxHistogramBuckets {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("buckets must be between 1 and %d", MaxHistogramBuckets),
+				"data":    nil,
+			})
+		}
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	decoded, _, err := decodeStoredImage(img)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to load image",
+			"data":    nil,
+		})
+	}
+
+	var histogram Histogram
+	processingPool.run(func() {
+		histogram = computeHistogram(decoded, buckets)
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Histogram computed",
+		"data":    histogram,
+	})
+}
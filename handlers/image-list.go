@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+// Periods images can be grouped into with ?group_by=
+var imageGroupPeriods = []string{"day", "month"}
+
+// MaxGroupThumbnails caps ?thumbnails=, the latest images returned per group
+const MaxGroupThumbnails = 8
+
+// ImageGroup is the number of images created in one day or month
+type ImageGroup struct {
+	Period     time.Time        `json:"period"`
+	Count      int64            `json:"count"`
+	Thumbnails []GroupThumbnail `json:"thumbnails,omitempty" gorm:"-"`
+}
+
+// GroupThumbnail is one of the latest images of an ImageGroup
+type GroupThumbnail struct {
+	ID       uint   `json:"id"`
+	URL      string `json:"url"`
+	BlurHash string `json:"blurhash,omitempty"`
+}
+
+// ListImages lists your images, newest first, optionally only those from one
+// source (?source=generate) or only your favorites (?favorite=true). Pages are numbered, or follow a cursor when
+// ?cursor= is given. With ?group_by=day or month it returns image counts per
+// period instead, for timeline views.
+func ListImages(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return paginationError(c, err)
+	}
+	cursor, err := parseCursorParam(c)
+	if err != nil {
+		return paginationError(c, err)
+	}
+
+	db := database.GetDB()
+	query := db.Model(&models.Image{}).Where("user_id = ?", userID)
+
+	if source := c.Query("source"); source != "" {
+		if !slices.Contains(imageSources, source) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("source must be one of: %s", strings.Join(imageSources, ", ")),
+				"data":    nil,
+			})
+		}
+		query = query.Where("source = ?", source)
+	}
+
+	if param := c.Query("favorite"); param != "" {
+		favorite, err := strconv.ParseBool(param)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "favorite must be true or false",
+				"data":    nil,
+			})
+		}
+		query = query.Where("favorite = ?", favorite)
+	}
+
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		if cursor != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "cursor can't be combined with group_by",
+				"data":    nil,
+			})
+		}
+		return listImageGroups(c, query, groupBy, pagination)
+	}
+
+	if cursor != nil {
+		return listImagesAfterCursor(c, query, *cursor, pagination.Limit)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	var images []models.Image
+	if err := pagination.apply(query.Order("created_at DESC, id DESC")).Find(&images).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+	if err := withResponseURLs(images); err != nil {
+		return storageErrorResponse(c, err, "Failed to sign image URLs")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Images retrieved",
+		"data": fiber.Map{
+			"images":     images,
+			"pagination": pagination.meta(total),
+		},
+	})
+}
+
+// listImagesAfterCursor returns the page of images after cursor, along with
+// the cursor of the next page, null on the last one
+func listImagesAfterCursor(c *fiber.Ctx, query *gorm.DB, cursor Cursor, limit int) error {
+	// One extra row tells whether there is a next page without counting
+	var images []models.Image
+	if err := cursor.after(query).Order("created_at DESC, id DESC").Limit(limit + 1).Find(&images).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	var nextCursor *string
+	if len(images) > limit {
+		images = images[:limit]
+		last := images[limit-1]
+		next := Cursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+		nextCursor = &next
+	}
+	if err := withResponseURLs(images); err != nil {
+		return storageErrorResponse(c, err, "Failed to sign image URLs")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Images retrieved",
+		"data": fiber.Map{
+			"images": images,
+			"pagination": fiber.Map{
+				"limit":       limit,
+				"next_cursor": nextCursor,
+			},
+		},
+	})
+}
+
+// listImageGroups counts the images matched by query per UTC day or month,
+// newest period first, paginating over periods rather than images
+func listImageGroups(c *fiber.Ctx, query *gorm.DB, groupBy string, pagination Pagination) error {
+	if !slices.Contains(imageGroupPeriods, groupBy) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("group_by must be one of: %s", strings.Join(imageGroupPeriods, ", ")),
+			"data":    nil,
+		})
+	}
+
+	thumbnails := c.QueryInt("thumbnails", 0)
+	if thumbnails < 0 || thumbnails > MaxGroupThumbnails {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("thumbnails must be between 0 and %d", MaxGroupThumbnails),
+			"data":    nil,
+		})
+	}
+
+	// groupBy is one of imageGroupPeriods, so it is safe to inline; binding
+	// it would make the SELECT and GROUP BY expressions differ for Postgres
+	period := fmt.Sprintf("date_trunc('%s', images.created_at AT TIME ZONE 'UTC')", groupBy)
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Select("COUNT(DISTINCT " + period + ")").Scan(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	groups := []ImageGroup{}
+	err := pagination.apply(query.Session(&gorm.Session{}).
+		Select(period + " AS period, COUNT(*) AS count").
+		Group("period").
+		Order("period DESC")).
+		Scan(&groups).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	if thumbnails > 0 && len(groups) > 0 {
+		if err := addGroupThumbnails(query, period, groups, thumbnails); err != nil {
+			return storageErrorResponse(c, err, "Failed to load thumbnails")
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image groups retrieved",
+		"data": fiber.Map{
+			"group_by":   groupBy,
+			"groups":     groups,
+			"pagination": pagination.meta(total),
+		},
+	})
+}
+
+// addGroupThumbnails fills in the latest perGroup images of each group with
+// one query, ranking images within their period
+func addGroupThumbnails(query *gorm.DB, period string, groups []ImageGroup, perGroup int) error {
+	// Periods are timestamps without a time zone, passing them as text keeps
+	// Postgres from converting them through the session's time zone
+	periods := make([]string, len(groups))
+	byPeriod := make(map[time.Time]*ImageGroup, len(groups))
+	for i := range groups {
+		periods[i] = groups[i].Period.UTC().Format(time.DateTime)
+		byPeriod[groups[i].Period.UTC()] = &groups[i]
+	}
+
+	type rankedImage struct {
+		models.Image
+		Period time.Time
+	}
+
+	ranked := query.Session(&gorm.Session{}).
+		Select("images.*, "+period+" AS period, ROW_NUMBER() OVER (PARTITION BY "+period+" ORDER BY images.created_at DESC, images.id DESC) AS position").
+		Where(period+" IN ?", periods)
+
+	var images []rankedImage
+	err := database.GetDB().Table("(?) AS ranked", ranked).
+		Where("position <= ?", perGroup).
+		Order("period DESC, position").
+		Scan(&images).Error
+	if err != nil {
+		return err
+	}
+
+	for _, img := range images {
+		group := byPeriod[img.Period.UTC()]
+		if group == nil {
+			continue
+		}
+
+		urls := UploadResult{URL: img.OriginalURL, ProcessedURL: img.ProcessedURL}
+		if img.Private {
+			if urls, err = urls.signed(); err != nil {
+				return err
+			}
+		}
+
+		group.Thumbnails = append(group.Thumbnails, GroupThumbnail{
+			ID:       img.ID,
+			URL:      urls.displayURL(),
+			BlurHash: img.BlurHash,
+		})
+	}
+
+	return nil
+}
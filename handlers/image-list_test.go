@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+var listImageColumns = []string{"id", "user_id", "filename", "original_url", "status", "created_at", "updated_at"}
+
+// imageRows are images first to first+n-1 of user 1, newer ones first, an
+// hour apart
+func imageRows(first, n int) *sqlmock.Rows {
+	rows := sqlmock.NewRows(listImageColumns)
+	newest := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	for id := first; id < first+n; id++ {
+		created := newest.Add(-time.Duration(id) * time.Hour)
+		rows.AddRow(id, 1, fmt.Sprintf("image-%d.png", id), fmt.Sprintf("https://storage.googleapis.com/test-bucket/users/1/image-%d.png", id), "completed", created, created)
+	}
+	return rows
+}
+
+type listResponse struct {
+	Message string `json:"message"`
+	Data    struct {
+		Images []struct {
+			ID          uint   `json:"id"`
+			Filename    string `json:"filename"`
+			OriginalURL string `json:"original_url"`
+		} `json:"images"`
+		Pagination struct {
+			Total      int64   `json:"total"`
+			Page       int     `json:"page"`
+			Limit      int     `json:"limit"`
+			TotalPages int64   `json:"total_pages"`
+			NextCursor *string `json:"next_cursor"`
+		} `json:"pagination"`
+	} `json:"data"`
+}
+
+func listImages(t *testing.T, target string) (int, listResponse) {
+	t.Helper()
+
+	app := fiber.New()
+	app.Get("/image", asUser(1), ListImages)
+
+	resp, err := app.Test(httptest.NewRequest("GET", target, nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result listResponse
+	decodeResponse(t, resp, &result)
+	return resp.StatusCode, result
+}
+
+// expectPage expects ListImages to count total images and then load the page
+// at offset, returning rows
+func expectPage(mock sqlmock.Sqlmock, total int64, limit, offset int, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "images" WHERE user_id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+
+	args := []driver.Value{1, limit}
+	if offset > 0 {
+		args = append(args, offset)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "images" WHERE user_id = \$1 .* ORDER BY created_at DESC, id DESC LIMIT`).
+		WithArgs(args...).
+		WillReturnRows(rows)
+}
+
+func TestListImagesEmpty(t *testing.T) {
+	mock := newMockDB(t)
+	expectPage(mock, 0, defaultPageSize, 0, sqlmock.NewRows(listImageColumns))
+
+	status, result := listImages(t, "/image")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if result.Data.Images == nil || len(result.Data.Images) != 0 {
+		t.Errorf("images = %v, want an empty list", result.Data.Images)
+	}
+	if p := result.Data.Pagination; p.Total != 0 || p.TotalPages != 0 || p.Page != 1 || p.Limit != defaultPageSize {
+		t.Errorf("pagination = %+v, want an empty first page of %d", p, defaultPageSize)
+	}
+}
+
+func TestListImagesSinglePage(t *testing.T) {
+	mock := newMockDB(t)
+	expectPage(mock, 3, defaultPageSize, 0, imageRows(1, 3))
+
+	status, result := listImages(t, "/image")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(result.Data.Images) != 3 || result.Data.Images[0].ID != 1 {
+		t.Fatalf("images = %+v, want images 1 to 3, newest first", result.Data.Images)
+	}
+	if p := result.Data.Pagination; p.Total != 3 || p.TotalPages != 1 {
+		t.Errorf("pagination = %+v, want 3 images on 1 page", p)
+	}
+}
+
+func TestListImagesLastPartialPage(t *testing.T) {
+	mock := newMockDB(t)
+	expectPage(mock, 5, 2, 4, imageRows(5, 1))
+
+	status, result := listImages(t, "/image?page=3&limit=2")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(result.Data.Images) != 1 || result.Data.Images[0].ID != 5 {
+		t.Fatalf("images = %+v, want only image 5", result.Data.Images)
+	}
+	if p := result.Data.Pagination; p.Total != 5 || p.Page != 3 || p.Limit != 2 || p.TotalPages != 3 {
+		t.Errorf("pagination = %+v, want page 3 of 3", p)
+	}
+}
+
+func TestListImagesPageSizeLimit(t *testing.T) {
+	newMockDB(t) // No queries are expected
+
+	tests := []string{
+		fmt.Sprintf("/image?limit=%d", maxPageSize+1),
+		"/image?limit=0",
+		"/image?page=0",
+		"/image?cursor=&page=2",
+		"/image?cursor=not-a-cursor",
+	}
+	for _, target := range tests {
+		if status, result := listImages(t, target); status != fiber.StatusBadRequest {
+			t.Errorf("GET %s: status = %d (%s), want 400", target, status, result.Message)
+		}
+	}
+}
+
+func TestListImagesMaxPageSize(t *testing.T) {
+	mock := newMockDB(t)
+	expectPage(mock, 1, maxPageSize, 0, imageRows(1, 1))
+
+	if status, _ := listImages(t, fmt.Sprintf("/image?limit=%d", maxPageSize)); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+}
+
+func TestListImagesCursorPaging(t *testing.T) {
+	mock := newMockDB(t)
+
+	// One row more than the limit tells ListImages there's a next page
+	mock.ExpectQuery(`SELECT \* FROM "images" WHERE user_id = \$1 .* ORDER BY created_at DESC, id DESC LIMIT`).
+		WithArgs(1, 3).
+		WillReturnRows(imageRows(1, 3))
+
+	status, first := listImages(t, "/image?cursor=&limit=2")
+	if status != fiber.StatusOK {
+		t.Fatalf("first page: status = %d, want 200", status)
+	}
+	if len(first.Data.Images) != 2 || first.Data.Images[1].ID != 2 {
+		t.Fatalf("first page = %+v, want images 1 and 2", first.Data.Images)
+	}
+	next := first.Data.Pagination.NextCursor
+	if next == nil {
+		t.Fatal("first page has no next_cursor")
+	}
+
+	cursor, err := parseCursor(*next)
+	if err != nil {
+		t.Fatalf("next_cursor %q doesn't parse: %v", *next, err)
+	}
+	if cursor.ID != 2 {
+		t.Fatalf("next_cursor points after image %d, want 2", cursor.ID)
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "images" WHERE user_id = \$1 AND \(created_at, id\) < \(\$2, \$3\)`).
+		WithArgs(1, cursor.CreatedAt, cursor.ID, 3).
+		WillReturnRows(imageRows(3, 1))
+
+	status, last := listImages(t, "/image?cursor="+*next+"&limit=2")
+	if status != fiber.StatusOK {
+		t.Fatalf("last page: status = %d, want 200", status)
+	}
+	if len(last.Data.Images) != 1 || last.Data.Images[0].ID != 3 {
+		t.Fatalf("last page = %+v, want image 3", last.Data.Images)
+	}
+	if last.Data.Pagination.NextCursor != nil {
+		t.Errorf("last page next_cursor = %q, want null", *last.Data.Pagination.NextCursor)
+	}
+}
+
+func TestListImagesReturnsClientURLs(t *testing.T) {
+	returnPaths := returnObjectPaths
+	returnObjectPaths = true
+	t.Cleanup(func() { returnObjectPaths = returnPaths })
+
+	mock := newMockDB(t)
+	expectPage(mock, 1, defaultPageSize, 0, imageRows(1, 1))
+
+	status, result := listImages(t, "/image")
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if len(result.Data.Images) != 1 || result.Data.Images[0].OriginalURL != "users/1/image-1.png" {
+		t.Fatalf("images = %+v, want the object path of image 1", result.Data.Images)
+	}
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+// GetImageMetadata returns the attributes of the stored object behind an
+// image, along with any ways they've drifted from the database record
+func GetImageMetadata(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Image has no stored object",
+			"data":    nil,
+		})
+	}
+
+	record := fiber.Map{
+		"object_path":      objectPath,
+		"size_bytes":       img.SizeBytes,
+		"view_count":       img.ViewCount,
+		"last_accessed_at": img.LastAccessedAt,
+	}
+
+	attrs, err := uploader.Attrs(objectPath)
+	if errors.Is(err, ErrStorageNotFound) {
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{
+			"status":  "success",
+			"message": "Stored object is missing",
+			"data": fiber.Map{
+				"record":     record,
+				"object":     nil,
+				"mismatches": []string{"object_missing"},
+			},
+		})
+	}
+	if err != nil {
+		return storageErrorResponse(c, err, "Failed to read object metadata")
+	}
+
+	mismatches := []string{}
+	if img.ObjectPath == "" {
+		mismatches = append(mismatches, "object_path_missing")
+	}
+	if img.SizeBytes != attrs.Size {
+		mismatches = append(mismatches, "size")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Object metadata retrieved",
+		"data": fiber.Map{
+			"record": record,
+			"object": fiber.Map{
+				"size_bytes":   attrs.Size,
+				"content_type": attrs.ContentType,
+				"created_at":   attrs.Created,
+				"updated_at":   attrs.Updated,
+				"md5":          attrs.MD5,
+			},
+			"mismatches": mismatches,
+		},
+	})
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+const (
+	DefaultPaletteSize = 5
+	MaxPaletteSize     = 16
+
+	// Images are sampled down to roughly this many pixels before quantizing
+	paletteSamplePixels = 10000
+)
+
+type PaletteColor struct {
+	Hex        string  `json:"hex"`
+	Proportion float64 `json:"proportion"`
+}
+
+type colorBox struct {
+	pixels [][3]uint8
+}
+
+// widestChannel returns the channel with the largest range in the box
+func (b colorBox) widestChannel() (int, int) {
+	channel, widest := 0, -1
+	for ch := 0; ch < 3; ch++ {
+		lo, hi := 255, 0
+		for _, p := range b.pixels {
+			v := int(p[ch])
+			lo = min(lo, v)
+			hi = max(hi, v)
+		}
+		if hi-lo > widest {
+			channel, widest = ch, hi-lo
+		}
+	}
+	return channel, widest
+}
+
+func (b colorBox) average() [3]uint8 {
+	var sum [3]int
+	for _, p := range b.pixels {
+		for ch := 0; ch < 3; ch++ {
+			sum[ch] += int(p[ch])
+		}
+	}
+
+	n := len(b.pixels)
+	return [3]uint8{uint8(sum[0] / n), uint8(sum[1] / n), uint8(sum[2] / n)}
+}
+
+func samplePixels(img image.Image) [][3]uint8 {
+	bounds := img.Bounds()
+	step := 1
+	for (bounds.Dx()/step)*(bounds.Dy()/step) > paletteSamplePixels {
+		step++
+	}
+
+	pixels := make([][3]uint8, 0, paletteSamplePixels)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += step {
+		for x := bounds.Min.X; x < bounds.Max.X; x += step {
+			r, g, b, a := img.At(x, y).RGBA()
+			// Mostly transparent pixels don't contribute a visible color
+			if a < 0x8000 {
+				continue
+			}
+			pixels = append(pixels, [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)})
+		}
+	}
+
+	return pixels
+}
+
+// extractPalette quantizes an image with median cut and returns up to count
+// colors ordered by how much of the image they cover
+func extractPalette(img image.Image, count int) []PaletteColor {
+	pixels := samplePixels(img)
+	if len(pixels) == 0 {
+		return []PaletteColor{}
+	}
+
+	boxes := []colorBox{{pixels: pixels}}
+	for len(boxes) < count {
+		// Split the box with the widest channel range
+		target, channel, widest := -1, 0, 0
+		for i, box := range boxes {
+			if len(box.pixels) < 2 {
+				continue
+			}
+			ch, width := box.widestChannel()
+			if width > widest {
+				target, channel, widest = i, ch, width
+			}
+		}
+		if target == -1 {
+			break
+		}
+
+		box := boxes[target]
+		sort.Slice(box.pixels, func(i, j int) bool {
+			return box.pixels[i][channel] < box.pixels[j][channel]
+		})
+		mid := len(box.pixels) / 2
+		boxes[target] = colorBox{pixels: box.pixels[:mid]}
+		boxes = append(boxes, colorBox{pixels: box.pixels[mid:]})
+	}
+
+	palette := make([]PaletteColor, 0, len(boxes))
+	for _, box := range boxes {
+		avg := box.average()
+		palette = append(palette, PaletteColor{
+			Hex:        fmt.Sprintf("#%02x%02x%02x", avg[0], avg[1], avg[2]),
+			Proportion: float64(len(box.pixels)) / float64(len(pixels)),
+		})
+	}
+
+	sort.Slice(palette, func(i, j int) bool {
+		return palette[i].Proportion > palette[j].Proportion
+	})
+
+	return palette
+}
+
+func GetImagePalette(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	count := DefaultPaletteSize
+	if param := c.Query("count"); param != "" {
+		count, err = parseIntParam(param, "count")
+		if err != nil || count < 1 || count > MaxPaletteSize {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("count must be between 1 and %d", MaxPaletteSize),
+				"data":    nil,
+			})
+		}
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	decoded, _, err := decodeStoredImage(img)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to load image",
+			"data":    nil,
+		})
+	}
+
+	var palette []PaletteColor
+	processingPool.run(func() {
+		palette = extractPalette(decoded, count)
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Palette extracted",
+		"data":    palette,
+	})
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"sort"
+	"strconv"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultSimilarityDistance = 10
+	MaxSimilarityDistance     = 32
+
+	// SimilarScanBatchSize is how many candidate hashes are loaded at a time
+	SimilarScanBatchSize = 1000
+	// MaxSimilarImages caps the matches returned, closest first
+	MaxSimilarImages = 100
+)
+
+// perceptualHash computes a 64-bit difference hash (dHash): the image is
+// shrunk to 9x8 grayscale and each bit records whether a pixel is brighter
+// than its right-hand neighbour. Visually similar images get hashes that
+// differ in only a few bits.
+func perceptualHash(img image.Image) string {
+	g := gift.New(
+		gift.Grayscale(),
+		gift.Resize(9, 8, gift.BoxResampling),
+	)
+	small := image.NewGray(g.Bounds(img.Bounds()))
+	g.Draw(small, img)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%016x", hash)
+}
+
+func hammingDistance(a, b string) (int, error) {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return bits.OnesCount64(x ^ y), nil
+}
+
+func GetSimilarImages(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	maxDistance := DefaultSimilarityDistance
+	if param := c.Query("distance"); param != "" {
+		maxDistance, err = parseIntParam(param, "distance")
+		if err != nil || maxDistance > MaxSimilarityDistance {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": fmt.Sprintf("distance must be between 0 and %d", MaxSimilarityDistance),
+				"data":    nil,
+			})
+		}
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	db := middleware.DB(c)
+
+	// Images stored before hashing was added get their hash on first use
+	if img.PHash == "" {
+		decoded, _, err := decodeStoredImage(img)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to load image",
+				"data":    nil,
+			})
+		}
+		processingPool.run(func() {
+			img.PHash = perceptualHash(decoded)
+		})
+		if err := db.Model(&img).Update("p_hash", img.PHash).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Database error",
+				"data":    nil,
+			})
+		}
+	}
+
+	type match struct {
+		image    models.Image
+		distance int
+	}
+
+	// Only the hashes are compared, so the scan loads just what a match
+	// needs, a batch at a time
+	var matches []match
+	var batch []models.Image
+	err = db.Model(&models.Image{}).
+		Select("id", "filename", "original_url", "p_hash", "private").
+		Where("user_id = ? AND id != ? AND p_hash <> ''", userID, img.ID).
+		FindInBatches(&batch, SimilarScanBatchSize, func(tx *gorm.DB, _ int) error {
+			for _, candidate := range batch {
+				distance, err := hammingDistance(img.PHash, candidate.PHash)
+				if err == nil && distance <= maxDistance {
+					matches = append(matches, match{image: candidate, distance: distance})
+				}
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].distance < matches[j].distance
+	})
+	if len(matches) > MaxSimilarImages {
+		matches = matches[:MaxSimilarImages]
+	}
+
+	type SimilarImage struct {
+		ID       uint   `json:"id"`
+		Filename string `json:"filename"`
+		URL      string `json:"url"`
+		Distance int    `json:"distance"`
+	}
+
+	similar := make([]SimilarImage, 0, len(matches))
+	for _, m := range matches {
+		urls, err := responseURLs(m.image)
+		if err != nil {
+			return storageErrorResponse(c, err, "Failed to sign image URLs")
+		}
+		similar = append(similar, SimilarImage{
+			ID:       m.image.ID,
+			Filename: m.image.Filename,
+			URL:      urls.URL,
+			Distance: m.distance,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Found %d similar image(s)", len(similar)),
+		"data":    similar,
+	})
+}
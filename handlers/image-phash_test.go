@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGetSimilarImagesClosestFirst(t *testing.T) {
+	mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "images"`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "filename", "p_hash"}).
+			AddRow(5, 1, "photo.png", "00000000000000ff"))
+
+	// Only the compared columns are loaded, in batches
+	mock.ExpectQuery(`SELECT "id","filename","original_url","p_hash","private" FROM "images" WHERE .*user_id = \$1 AND id != \$2.* ORDER BY "images"."id" LIMIT \$3`).
+		WithArgs(1, 5, SimilarScanBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "filename", "original_url", "p_hash", "private"}).
+			AddRow(6, "far.png", "https://storage.googleapis.com/test-bucket/users/1/far.png", "000000000000ffff", false).
+			AddRow(7, "close.png", "https://storage.googleapis.com/test-bucket/users/1/close.png", "00000000000000fe", false).
+			AddRow(8, "other.png", "https://storage.googleapis.com/test-bucket/users/1/other.png", "ffffffffffffff00", false))
+
+	app := fiber.New()
+	app.Get("/image/:id/similar", asUser(1), GetSimilarImages)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/image/5/similar", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+
+	var result struct {
+		Data []struct {
+			ID       uint `json:"id"`
+			Distance int  `json:"distance"`
+		} `json:"data"`
+	}
+	decodeResponse(t, resp, &result)
+
+	if len(result.Data) != 2 || result.Data[0].ID != 7 || result.Data[0].Distance != 1 || result.Data[1].ID != 6 || result.Data[1].Distance != 8 {
+		t.Fatalf("similar = %+v, want image 7 at distance 1, then 6 at distance 8", result.Data)
+	}
+}
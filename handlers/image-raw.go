@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+// rawImageMaxAge is how long clients may cache proxied image bytes. Stored
+// objects only change through a content replace, which changes the ETag.
+const rawImageMaxAge = 3600
+
+// rawDownloadTimeout bounds streaming one image through the API, so a client
+// that stops reading can't hold the storage download open forever
+var rawDownloadTimeout = time.Duration(config.ConfigInt("RAW_DOWNLOAD_TIMEOUT_SECONDS", 300)) * time.Second
+
+// rawReader is a stored object being streamed into a response. Closing it,
+// which the response does once the body is sent, also releases its context.
+type rawReader struct {
+	*storage.Reader
+	cancel context.CancelFunc
+}
+
+func (r *rawReader) Close() error {
+	defer r.cancel()
+	return r.Reader.Close()
+}
+
+// openRawReader opens a stored object for a response with open, bounded by
+// the request's context and rawDownloadTimeout
+func openRawReader(c *fiber.Ctx, open func(ctx context.Context) (*storage.Reader, error)) (*rawReader, error) {
+	ctx, cancel := context.WithTimeout(c.UserContext(), rawDownloadTimeout)
+	reader, err := open(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &rawReader{Reader: reader, cancel: cancel}, nil
+}
+
+// GetImageRaw streams the bytes of one of the user's images through the API,
+// so clients never see the storage URL and private objects need no signing.
+// Browsers save it under the image's original filename, or display it with
+// ?disposition=inline.
+func GetImageRaw(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	disposition, err := parseDisposition(c.Query("disposition"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Image has no stored object",
+			"data":    nil,
+		})
+	}
+
+	reader, err := openRawReader(c, func(ctx context.Context) (*storage.Reader, error) {
+		return uploader.DownloadStream(ctx, objectPath)
+	})
+	if err != nil {
+		return storageErrorResponse(c, err, "Failed to read image")
+	}
+
+	// The object generation changes on every overwrite, making it a strong ETag
+	etag := fmt.Sprintf(`"%d"`, reader.Attrs.Generation)
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderCacheControl, "private, max-age="+strconv.Itoa(rawImageMaxAge))
+	c.Set(fiber.HeaderLastModified, reader.Attrs.LastModified.UTC().Format(http.TimeFormat))
+
+	imageAccesses.record(img.ID)
+
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		reader.Close()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	// Only allowed raster types are served as images. Anything else, such as
+	// an object stored before uploads were validated, is forced to download
+	// as opaque bytes so a browser never renders it.
+	contentType := reader.Attrs.ContentType
+	if !contentTypeAllowed(contentType) {
+		contentType = "application/octet-stream"
+		disposition = DispositionAttachment
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentDisposition, contentDisposition(disposition, downloadFilename(img.Filename, contentType)))
+
+	// A Range is only honored while the client's copy is still current, as
+	// told by If-Range; otherwise it gets the whole new image
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader != "" {
+		if ifRange := c.Get(fiber.HeaderIfRange); ifRange == "" || ifRange == etag {
+			return sendImageRange(c, reader, objectPath, rangeHeader)
+		}
+	}
+
+	// The response closes the reader once the body has been sent
+	return c.SendStream(reader, int(reader.Attrs.Size))
+}
+
+// sendImageRange answers a Range request with 206 and the requested bytes,
+// or 416 when the range lies past the end of the image. Ranges it doesn't
+// support get the whole image.
+func sendImageRange(c *fiber.Ctx, full *rawReader, objectPath, rangeHeader string) error {
+	size := full.Attrs.Size
+	byteRange, err := parseRange(rangeHeader, size)
+	if errors.Is(err, errRangeNotSatisfiable) {
+		full.Close()
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+		return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Requested range not satisfiable",
+			"data":    nil,
+		})
+	}
+	if byteRange == nil {
+		return c.SendStream(full, int(size))
+	}
+
+	// The open reader already covers a range of the whole image
+	reader := full
+	if byteRange.length < size {
+		full.Close()
+		reader, err = openRawReader(c, func(ctx context.Context) (*storage.Reader, error) {
+			return uploader.DownloadRangeStream(ctx, objectPath, full.Attrs.Generation, byteRange.start, byteRange.length)
+		})
+		if err != nil {
+			return storageErrorResponse(c, err, "Failed to read image")
+		}
+	}
+
+	c.Set(fiber.HeaderContentRange, byteRange.contentRange(size))
+	return c.Status(fiber.StatusPartialContent).SendStream(reader, int(byteRange.length))
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	"github.com/disintegration/gift"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+const (
+	// Saliency is measured on a copy scaled to fit in this many pixels a side
+	roiAnalysisSize = 256
+	// Candidate crops are tried every this many analysis pixels
+	roiStep = 4
+)
+
+// DefaultROIAspects are the aspect ratios suggested when none are asked for
+var DefaultROIAspects = []string{"1:1", "4:3", "3:4", "16:9", "9:16"}
+
+// ROI is a suggested crop of an image in its own pixel coordinates. Score is
+// the share of the image's detail the crop keeps, from 0 to 1.
+type ROI struct {
+	Aspect   string  `json:"aspect"`
+	X        int     `json:"x"`
+	Y        int     `json:"y"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Score    float64 `json:"score"`
+	CropRect string  `json:"crop_rect"`
+}
+
+// parseAspect parses "width:height" into a ratio
+func parseAspect(aspect string) (float64, error) {
+	parts := strings.Split(aspect, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("aspect %q must be in format 'width:height'", aspect)
+	}
+
+	width, err := parseIntParam(parts[0], "aspect width")
+	if err != nil || width == 0 {
+		return 0, fmt.Errorf("invalid aspect %q", aspect)
+	}
+	height, err := parseIntParam(parts[1], "aspect height")
+	if err != nil || height == 0 {
+		return 0, fmt.Errorf("invalid aspect %q", aspect)
+	}
+
+	return float64(width) / float64(height), nil
+}
+
+// saliencyMap scores every pixel of a scaled down grayscale copy of img by
+// its edge strength, and returns it as a summed-area table so the detail
+// inside any rectangle can be read in constant time
+func saliencyMap(img image.Image) (table [][]float64, scale float64) {
+	g := gift.New(
+		gift.ResizeToFit(roiAnalysisSize, roiAnalysisSize, gift.LinearResampling),
+		gift.Grayscale(),
+	)
+	small := image.NewGray(g.Bounds(img.Bounds()))
+	g.Draw(small, img)
+
+	width, height := small.Bounds().Dx(), small.Bounds().Dy()
+	scale = float64(img.Bounds().Dx()) / float64(width)
+
+	table = make([][]float64, height+1)
+	for y := range table {
+		table[y] = make([]float64, width+1)
+	}
+
+	at := func(x, y int) float64 {
+		x = min(max(x, 0), width-1)
+		y = min(max(y, 0), height-1)
+		return float64(small.GrayAt(x, y).Y)
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx := at(x+1, y) - at(x-1, y)
+			dy := at(x, y+1) - at(x, y-1)
+			table[y+1][x+1] = math.Hypot(dx, dy) + table[y][x+1] + table[y+1][x] - table[y][x]
+		}
+	}
+
+	return table, scale
+}
+
+func rectSaliency(table [][]float64, x, y, width, height int) float64 {
+	return table[y+height][x+width] - table[y][x+width] - table[y+height][x] + table[y][x]
+}
+
+// suggestCrop finds the largest crop with the given aspect ratio that keeps
+// the most detail, by sliding it along the axis it doesn't fill
+func suggestCrop(table [][]float64, scale float64, bounds image.Rectangle, aspectName string, aspect float64) ROI {
+	height, width := len(table)-1, len(table[0])-1
+
+	cropWidth, cropHeight := width, int(math.Round(float64(width)/aspect))
+	if cropHeight > height {
+		cropWidth, cropHeight = int(math.Round(float64(height)*aspect)), height
+	}
+	cropWidth, cropHeight = max(cropWidth, 1), max(cropHeight, 1)
+
+	total := rectSaliency(table, 0, 0, width, height)
+	bestX, bestY, best := 0, 0, -1.0
+	for y := 0; y+cropHeight <= height; y += roiStep {
+		for x := 0; x+cropWidth <= width; x += roiStep {
+			if score := rectSaliency(table, x, y, cropWidth, cropHeight); score > best {
+				bestX, bestY, best = x, y, score
+			}
+		}
+	}
+
+	roi := ROI{Aspect: aspectName, Score: 1}
+	if total > 0 {
+		roi.Score = math.Round(best/total*1000) / 1000
+	}
+
+	// Map back onto the original image, keeping the crop inside it
+	roi.Width = min(int(math.Round(float64(cropWidth)*scale)), bounds.Dx())
+	roi.Height = min(int(math.Round(float64(cropHeight)*scale)), bounds.Dy())
+	roi.X = min(int(math.Round(float64(bestX)*scale)), bounds.Dx()-roi.Width)
+	roi.Y = min(int(math.Round(float64(bestY)*scale)), bounds.Dy()-roi.Height)
+	roi.CropRect = fmt.Sprintf("%d:%d:%d:%d", roi.X, roi.Y, roi.Width, roi.Height)
+
+	return roi
+}
+
+// GetImageROI suggests crops of an image for a set of aspect ratios, based on
+// where its detail is, without changing the image. Each suggestion can be
+// applied with the crop_rect filter.
+func GetImageROI(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	aspects := DefaultROIAspects
+	if param := c.Query("aspects"); param != "" {
+		aspects = strings.Split(param, ",")
+	}
+
+	ratios := make([]float64, len(aspects))
+	for i, aspect := range aspects {
+		if ratios[i], err = parseAspect(strings.TrimSpace(aspect)); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": err.Error(),
+				"data":    nil,
+			})
+		}
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	decoded, _, err := decodeStoredImage(img)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to load image",
+			"data":    nil,
+		})
+	}
+
+	regions := make([]ROI, len(aspects))
+	processingPool.run(func() {
+		table, scale := saliencyMap(decoded)
+		for i, aspect := range aspects {
+			regions[i] = suggestCrop(table, scale, decoded.Bounds(), strings.TrimSpace(aspect), ratios[i])
+		}
+	})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Suggested crops",
+		"data": fiber.Map{
+			"width":   decoded.Bounds().Dx(),
+			"height":  decoded.Bounds().Dy(),
+			"regions": regions,
+		},
+	})
+}
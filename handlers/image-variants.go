@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+)
+
+// GetImageVariants lists the processed images derived from one of your
+// images, newest first
+func GetImageVariants(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	pagination, err := parsePagination(c)
+	if err != nil {
+		return paginationError(c, err)
+	}
+
+	source, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	db := database.GetDB()
+	query := db.Model(&models.Image{}).Where("source_image_id = ? AND user_id = ?", source.ID, userID)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	var variants []models.Image
+	if err := pagination.apply(query.Order("created_at DESC, id DESC")).Find(&variants).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image variants retrieved",
+		"data": fiber.Map{
+			"source":     source,
+			"variants":   variants,
+			"pagination": pagination.meta(total),
+		},
+	})
+}
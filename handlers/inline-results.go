@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendInlineImages answers a filter request with the processed images
+// themselves instead of storing them, for previews: a single image as is,
+// several as a zip archive. Nothing is uploaded or recorded.
+func sendInlineImages(c *fiber.Ctx, images []LoadedImage, opts OutputOptions) error {
+	if len(images) == 1 {
+		var reader *bytes.Reader
+		var err error
+		processingPool.run(func() {
+			reader, err = tracedEncodeImage(c.UserContext(), images[0].Image, opts)
+		})
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to encode image",
+				"data":    nil,
+			})
+		}
+
+		filename := "processed_image" + opts.extension()
+		c.Set(fiber.HeaderContentType, contentTypeOf(filename))
+		c.Set(fiber.HeaderContentDisposition, contentDisposition(DispositionInline, filename))
+		return c.Status(fiber.StatusOK).SendStream(reader, int(reader.Size()))
+	}
+
+	// Each entry is encoded to memory on the pool and written outside it, so
+	// a slow download never holds a pool worker, and only one entry is kept
+	// in memory at a time. The images are already compressed, so they're
+	// stored as is.
+	ctx := c.UserContext()
+	pr, pw := io.Pipe()
+	go func() {
+		zw := zip.NewWriter(pw)
+		var err error
+		for i := 0; i < len(images) && err == nil; i++ {
+			var reader *bytes.Reader
+			processingPool.run(func() {
+				reader, err = tracedEncodeImage(ctx, images[i].Image, opts)
+			})
+			if err != nil {
+				break
+			}
+
+			var w io.Writer
+			w, err = zw.CreateHeader(&zip.FileHeader{
+				Name:     fmt.Sprintf("processed_image_%d%s", i+1, opts.extension()),
+				Method:   zip.Store,
+				Modified: time.Now(),
+			})
+			if err == nil {
+				_, err = io.Copy(w, reader)
+			}
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	c.Set(fiber.HeaderContentType, "application/zip")
+	c.Set(fiber.HeaderContentDisposition, contentDisposition(DispositionAttachment, "processed_images.zip"))
+	return c.Status(fiber.StatusOK).SendStream(pr)
+}
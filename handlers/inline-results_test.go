@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+// A zip that isn't being downloaded doesn't keep a pool worker busy
+func TestSendInlineImagesZipReleasesPool(t *testing.T) {
+	pool := processingPool
+	processingPool = newWorkerPool(1)
+	t.Cleanup(func() { processingPool = pool })
+
+	// Noise doesn't compress, so every entry is larger than the pipe buffers
+	noise := image.NewNRGBA(image.Rect(0, 0, 128, 128))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < 128; y++ {
+		for x := 0; x < 128; x++ {
+			noise.Set(x, y, color.NRGBA{R: uint8(rng.Intn(256)), G: uint8(rng.Intn(256)), B: uint8(rng.Intn(256)), A: 255})
+		}
+	}
+
+	app := fiber.New()
+	c := app.AcquireCtx(&fasthttp.RequestCtx{})
+	defer app.ReleaseCtx(c)
+
+	images := []LoadedImage{{Image: noise}, {Image: noise}}
+	if err := sendInlineImages(c, images, OutputOptions{Format: FormatPNG}); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Response().CloseBodyStream()
+
+	// The first byte is only sent once encoding is under way
+	if _, err := io.ReadFull(c.Response().BodyStream(), make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go processingPool.run(func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unread zip holds the processing pool")
+	}
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+
+	// Finished jobs are kept around this long so clients can read the result
+	JobRetention = time.Hour
+)
+
+// Job tracks the progress of a long-running background operation
+type Job struct {
+	mu sync.Mutex
+
+	ID        string
+	UserID    uint
+	Kind      string
+	Status    string
+	Total     int
+	Completed int
+	Failed    int
+	Errors    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// ctx is cancelled along with the job. Workers stop taking new items
+	// once it's done; items already in progress still finish.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+var jobs sync.Map
+
+func newJob(kind string, userID uint, total int) *Job {
+	pruneJobs()
+
+	now := time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        uuid.NewString(),
+		UserID:    userID,
+		Kind:      kind,
+		Status:    JobRunning,
+		Total:     total,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+	jobs.Store(job.ID, job)
+
+	return job
+}
+
+func getJob(id string) (*Job, bool) {
+	value, ok := jobs.Load(id)
+	if !ok {
+		return nil, false
+	}
+	return value.(*Job), true
+}
+
+func pruneJobs() {
+	jobs.Range(func(key, value any) bool {
+		job := value.(*Job)
+		job.mu.Lock()
+		expired := job.Status != JobRunning && time.Since(job.UpdatedAt) > JobRetention
+		job.mu.Unlock()
+
+		if expired {
+			jobs.Delete(key)
+		}
+		return true
+	})
+}
+
+func (j *Job) recordSuccess() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Completed++
+	j.UpdatedAt = time.Now()
+}
+
+func (j *Job) recordFailure(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Failed++
+	j.Errors = append(j.Errors, err.Error())
+	j.UpdatedAt = time.Now()
+}
+
+func (j *Job) finish() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.Status == JobRunning {
+		j.Status = JobCompleted
+	}
+	j.UpdatedAt = time.Now()
+	j.cancel()
+}
+
+// cancelJob marks a running job cancelled and signals its workers, returning
+// false when it already finished
+func (j *Job) cancelJob() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.Status != JobRunning {
+		return false
+	}
+	j.Status = JobCancelled
+	j.UpdatedAt = time.Now()
+	j.cancel()
+	return true
+}
+
+// skipped counts the items a cancelled job never got to. While the workers
+// wind down it shrinks as items in progress finish. Callers hold j.mu.
+func (j *Job) skipped() int {
+	if j.Status != JobCancelled {
+		return 0
+	}
+	return j.Total - j.Completed - j.Failed
+}
+
+func (j *Job) toMap() fiber.Map {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return fiber.Map{
+		"id":         j.ID,
+		"kind":       j.Kind,
+		"status":     j.Status,
+		"total":      j.Total,
+		"completed":  j.Completed,
+		"failed":     j.Failed,
+		"skipped":    j.skipped(),
+		"errors":     append([]string{}, j.Errors...),
+		"created_at": j.CreatedAt,
+		"updated_at": j.UpdatedAt,
+	}
+}
+
+func GetJob(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	job, ok := getJob(c.Params("id"))
+	if !ok || job.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Job not found",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Job found",
+		"data":    job.toMap(),
+	})
+}
+
+// CancelJob stops one of the user's running jobs. Items not yet started are
+// skipped, while those in progress finish, so the job's completed count
+// keeps growing briefly after cancelling. Each item is replaced atomically,
+// so there are no partial outputs to clean up.
+func CancelJob(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	job, ok := getJob(c.Params("id"))
+	if !ok || job.UserID != userID {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Job not found",
+			"data":    nil,
+		})
+	}
+
+	if !job.cancelJob() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Job already finished",
+			"data":    job.toMap(),
+		})
+	}
+
+	data := job.toMap()
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Job cancelled after %d of %d item(s) completed", data["completed"], data["total"]),
+		"data":    data,
+	})
+}
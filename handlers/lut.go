@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+const (
+	MinLUTSize = 2
+	MaxLUTSize = 65
+)
+
+// lutDir holds the .cube files the lut filter can reference by name
+var lutDir = config.ConfigDefault("LUT_DIR", "./luts")
+
+var lutNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// LUTs are parsed once and kept for the lifetime of the process
+var loadedLUTs sync.Map
+
+// lut3D is a 3D color lookup table. Entries are ordered with red changing
+// fastest, then green, then blue, as in the .cube format.
+type lut3D struct {
+	size      int
+	table     [][3]float32
+	domainMin [3]float32
+	domainMax [3]float32
+}
+
+// loadLUT returns the named LUT from lutDir
+func loadLUT(name string) (*lut3D, error) {
+	if !lutNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid LUT name")
+	}
+
+	if cached, ok := loadedLUTs.Load(name); ok {
+		return cached.(*lut3D), nil
+	}
+
+	file, err := os.Open(filepath.Join(lutDir, name+".cube"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("LUT '%s' not found", name)
+		}
+		return nil, fmt.Errorf("failed to open LUT: %v", err)
+	}
+	defer file.Close()
+
+	lut, err := parseCubeLUT(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LUT '%s': %v", name, err)
+	}
+
+	loadedLUTs.Store(name, lut)
+	return lut, nil
+}
+
+// parseCubeLUT reads a 3D LUT in the Adobe/Resolve .cube format
+func parseCubeLUT(r io.Reader) (*lut3D, error) {
+	lut := &lut3D{domainMax: [3]float32{1, 1, 1}}
+	scanner := bufio.NewScanner(r)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "TITLE":
+			continue
+
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("1D LUTs are not supported")
+
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: malformed LUT_3D_SIZE", lineNo)
+			}
+			size, err := strconv.Atoi(fields[1])
+			if err != nil || size < MinLUTSize || size > MaxLUTSize {
+				return nil, fmt.Errorf("line %d: LUT_3D_SIZE must be between %d and %d", lineNo, MinLUTSize, MaxLUTSize)
+			}
+			lut.size = size
+			lut.table = make([][3]float32, 0, size*size*size)
+
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			values, err := parseLUTTriple(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				lut.domainMin = values
+			} else {
+				lut.domainMax = values
+			}
+
+		default:
+			if lut.size == 0 {
+				return nil, fmt.Errorf("line %d: data before LUT_3D_SIZE", lineNo)
+			}
+			values, err := parseLUTTriple(fields)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if len(lut.table) == cap(lut.table) {
+				return nil, fmt.Errorf("line %d: more entries than LUT_3D_SIZE allows", lineNo)
+			}
+			lut.table = append(lut.table, values)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if lut.size == 0 {
+		return nil, fmt.Errorf("missing LUT_3D_SIZE")
+	}
+	if len(lut.table) != lut.size*lut.size*lut.size {
+		return nil, fmt.Errorf("expected %d entries, got %d", lut.size*lut.size*lut.size, len(lut.table))
+	}
+	for i := 0; i < 3; i++ {
+		if lut.domainMax[i] <= lut.domainMin[i] {
+			return nil, fmt.Errorf("DOMAIN_MAX must be greater than DOMAIN_MIN")
+		}
+	}
+
+	return lut, nil
+}
+
+func parseLUTTriple(fields []string) ([3]float32, error) {
+	var values [3]float32
+	if len(fields) != 3 {
+		return values, fmt.Errorf("expected 3 values, got %d", len(fields))
+	}
+
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return values, fmt.Errorf("invalid number %q", field)
+		}
+		values[i] = float32(value)
+	}
+
+	return values, nil
+}
+
+func (l *lut3D) at(r, g, b int) [3]float32 {
+	return l.table[r+g*l.size+b*l.size*l.size]
+}
+
+// lookup maps a color through the LUT with trilinear interpolation
+func (l *lut3D) lookup(r, g, b float32) [3]float32 {
+	in := [3]float32{r, g, b}
+	var idx [3]int
+	var frac [3]float32
+
+	last := float32(l.size - 1)
+	for i := 0; i < 3; i++ {
+		pos := (in[i] - l.domainMin[i]) / (l.domainMax[i] - l.domainMin[i]) * last
+		pos = max(0, min(pos, last))
+		idx[i] = min(int(pos), l.size-2)
+		frac[i] = pos - float32(idx[i])
+	}
+
+	var out [3]float32
+	for corner := 0; corner < 8; corner++ {
+		weight := float32(1)
+		var pos [3]int
+		for i := 0; i < 3; i++ {
+			if corner&(1<<i) != 0 {
+				weight *= frac[i]
+				pos[i] = idx[i] + 1
+			} else {
+				weight *= 1 - frac[i]
+				pos[i] = idx[i]
+			}
+		}
+		if weight == 0 {
+			continue
+		}
+
+		entry := l.at(pos[0], pos[1], pos[2])
+		for i := 0; i < 3; i++ {
+			out[i] += entry[i] * weight
+		}
+	}
+
+	return out
+}
+
+// lutFilter grades an image through a LUT, blended with the original colors
+// by strength (0-1)
+func lutFilter(lut *lut3D, strength float32) gift.Filter {
+	return gift.ColorFunc(func(r0, g0, b0, a0 float32) (float32, float32, float32, float32) {
+		graded := lut.lookup(r0, g0, b0)
+		r := r0 + (graded[0]-r0)*strength
+		g := g0 + (graded[1]-g0)*strength
+		b := b0 + (graded[2]-b0)*strength
+		return r, g, b, a0
+	})
+}
+
+// parseLUTParam reads a lut filter value in the form 'name' or 'name:strength'
+func parseLUTParam(param string) (gift.Filter, error) {
+	name, strengthParam, hasStrength := strings.Cut(param, ":")
+
+	strength := float32(MaxPercentage)
+	if hasStrength {
+		value, err := parseFloatParam(strengthParam, "strength", 0, MaxPercentage)
+		if err != nil {
+			return nil, err
+		}
+		strength = value
+	}
+
+	lut, err := loadLUT(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return lutFilter(lut, strength/MaxPercentage), nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// MaxUploadBytes caps the size of a whole request body. Larger requests are
+// rejected with 413 before they're read.
+var MaxUploadBytes = config.ConfigInt("MAX_UPLOAD_BYTES", 50<<20)
+
+// multipartMemoryBytes is how much of a multipart form is kept in memory
+// while parsing it; files beyond that are spooled to temporary files
+var multipartMemoryBytes = int64(config.ConfigInt("MULTIPART_MEMORY_BYTES", 8<<20))
+
+var errUploadTooLarge = errors.New("upload too large")
+
+// parseMultipartForm parses the request's multipart form within
+// multipartMemoryBytes of memory, instead of copying every file into memory
+// like c.MultipartForm does. Call RemoveAll on the form once done with it.
+func parseMultipartForm(c *fiber.Ctx) (*multipart.Form, error) {
+	if c.Request().Header.ContentLength() > MaxUploadBytes {
+		return nil, errUploadTooLarge
+	}
+
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, errors.New("request is not a multipart form")
+	}
+
+	form, err := multipart.NewReader(bytes.NewReader(c.Body()), boundary).ReadForm(multipartMemoryBytes)
+	if errors.Is(err, multipart.ErrMessageTooLarge) {
+		return nil, errUploadTooLarge
+	}
+
+	return form, err
+}
+
+func uploadTooLargeResponse(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+		"status":  "error",
+		"message": fmt.Sprintf("Upload exceeds the maximum size of %d bytes", MaxUploadBytes),
+		"data":    nil,
+	})
+}
+
+// ErrorHandler answers errors that never reach a handler, like a body over
+// MaxUploadBytes, in the same format as every other response
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var fiberErr *fiber.Error
+	if !errors.As(err, &fiberErr) {
+		fiberErr = fiber.ErrInternalServerError
+	}
+
+	if fiberErr.Code == fiber.StatusRequestEntityTooLarge {
+		return uploadTooLargeResponse(c)
+	}
+
+	return c.Status(fiberErr.Code).JSON(fiber.Map{
+		"status":  "error",
+		"message": fiberErr.Message,
+		"data":    nil,
+	})
+}
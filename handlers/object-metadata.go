@@ -0,0 +1,47 @@
+package handler
+
+import "strconv"
+
+// Sources recorded in the metadata of stored objects and on their image
+// records
+const (
+	SourceUpload       = "upload"
+	SourceUploadURL    = "upload-url"
+	SourceDirectUpload = "direct-upload"
+	SourceGenerate     = "generate"
+	SourceFilter       = "filter"
+	SourceCompare      = "compare"
+)
+
+var imageSources = []string{SourceUpload, SourceUploadURL, SourceDirectUpload, SourceGenerate, SourceFilter, SourceCompare}
+
+// ObjectMetadata is written as custom metadata on every stored object, so
+// lifecycle rules and tooling outside the service can tell who stored an
+// object and how without going through the database
+type ObjectMetadata struct {
+	OwnerID          uint
+	Source           string
+	OriginalFilename string
+	SourceImageID    uint
+	// Visibility is the visibility the uploader chose, empty when it's left
+	// to the bucket
+	Visibility string
+}
+
+func (m ObjectMetadata) toMap() map[string]string {
+	metadata := map[string]string{
+		"owner-id": strconv.FormatUint(uint64(m.OwnerID), 10),
+		"source":   m.Source,
+	}
+	if m.OriginalFilename != "" {
+		metadata["original-filename"] = m.OriginalFilename
+	}
+	if m.Visibility != "" {
+		metadata["visibility"] = m.Visibility
+	}
+	if m.SourceImageID != 0 {
+		metadata["source-image-id"] = strconv.FormatUint(uint64(m.SourceImageID), 10)
+	}
+
+	return metadata
+}
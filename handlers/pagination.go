@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"gorm.io/gorm"
+)
+
+// Page sizes shared by every list endpoint
+var (
+	defaultPageSize = config.ConfigInt("DEFAULT_PAGE_SIZE", 20)
+	maxPageSize     = config.ConfigInt("MAX_PAGE_SIZE", 100)
+)
+
+func init() {
+	if maxPageSize < 1 || defaultPageSize < 1 || defaultPageSize > maxPageSize {
+		log.Fatalf("DEFAULT_PAGE_SIZE must be between 1 and MAX_PAGE_SIZE (%d)", maxPageSize)
+	}
+}
+
+// Pagination is the page of results requested through ?page= and ?limit=
+type Pagination struct {
+	Page  int
+	Limit int
+}
+
+// parsePagination reads the pagination parameters every list endpoint
+// (images, image variants and audit logs) takes
+func parsePagination(c *fiber.Ctx) (Pagination, error) {
+	p := Pagination{
+		Page:  c.QueryInt("page", 1),
+		Limit: c.QueryInt("limit", defaultPageSize),
+	}
+
+	if p.Page < 1 {
+		return p, fmt.Errorf("page must be at least 1")
+	}
+	if p.Limit < 1 || p.Limit > maxPageSize {
+		return p, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+
+	return p, nil
+}
+
+// parseCursorParam reads ?cursor= on the endpoints that support cursors. It
+// returns nil without one, and the zero Cursor for the first page when it's
+// empty.
+func parseCursorParam(c *fiber.Ctx) (*Cursor, error) {
+	if !c.Context().QueryArgs().Has("cursor") {
+		return nil, nil
+	}
+	if c.Context().QueryArgs().Has("page") {
+		return nil, fmt.Errorf("cursor can't be combined with page")
+	}
+
+	cursor, err := parseCursor(c.Query("cursor"))
+	if err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
+
+// apply limits a query to the requested page
+func (p Pagination) apply(query *gorm.DB) *gorm.DB {
+	return query.Limit(p.Limit).Offset((p.Page - 1) * p.Limit)
+}
+
+// meta describes the page within total results for the response
+func (p Pagination) meta(total int64) fiber.Map {
+	return fiber.Map{
+		"total":       total,
+		"page":        p.Page,
+		"limit":       p.Limit,
+		"total_pages": (total + int64(p.Limit) - 1) / int64(p.Limit),
+	}
+}
+
+// Cursor marks the last row of a page for keyset pagination over rows
+// ordered by created_at and id, newest first. Unlike an offset it stays
+// cheap however deep a client pages.
+type Cursor struct {
+	CreatedAt time.Time
+	ID        uint
+}
+
+func (cur Cursor) String() string {
+	raw := cur.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.FormatUint(uint64(cur.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor decodes a cursor from ?cursor=, the zero Cursor for an empty
+// one, which starts at the newest row
+func parseCursor(param string) (Cursor, error) {
+	if param == "" {
+		return Cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(param)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+
+	var cur Cursor
+	if cur.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor")
+	}
+	cur.ID = uint(parsedID)
+
+	return cur, nil
+}
+
+// after limits a query ordered newest first to the rows past the cursor
+func (cur Cursor) after(query *gorm.DB) *gorm.DB {
+	if cur.ID == 0 {
+		return query
+	}
+	return query.Where("(created_at, id) < (?, ?)", cur.CreatedAt, cur.ID)
+}
+
+// paginationError writes the response for an error from parsePagination
+func paginationError(c *fiber.Ctx, err error) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		"status":  "error",
+		"message": err.Error(),
+		"data":    nil,
+	})
+}
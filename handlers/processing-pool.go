@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// processingPool runs all CPU-bound image work (decoding, filtering and
+// encoding) on a fixed number of workers, so a burst of requests queues up
+// instead of fanning out across every core the HTTP server also needs.
+var processingPool = newWorkerPool(config.ConfigInt("IMAGE_PROCESSING_WORKERS", runtime.NumCPU()))
+
+// pipelineConcurrency caps how many images of one request each stage of the
+// filter pipeline works on at once, so a large batch doesn't open a fetch or
+// upload per image all at the same time
+var pipelineConcurrency = config.ConfigInt("IMAGE_PIPELINE_CONCURRENCY", 8)
+
+// forEachBounded calls fn with every index below n from at most limit
+// goroutines at a time, and returns once all calls are done
+func forEachBounded(n, limit int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < min(max(limit, 1), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+type workerPool struct {
+	tasks   chan func()
+	workers int
+}
+
+func newWorkerPool(size int) *workerPool {
+	if size < 1 {
+		size = 1
+	}
+
+	pool := &workerPool{tasks: make(chan func()), workers: size}
+	for i := 0; i < size; i++ {
+		go func() {
+			for task := range pool.tasks {
+				task()
+			}
+		}()
+	}
+
+	return pool
+}
+
+// run submits task to the pool and waits for it to finish. Tasks must not
+// submit further work to the pool themselves.
+func (p *workerPool) run(task func()) {
+	done := make(chan struct{})
+	p.tasks <- func() {
+		defer close(done)
+		task()
+	}
+	<-done
+}
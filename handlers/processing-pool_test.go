@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// concurrencyGauge tracks how many calls run at once and the most that ever
+// did
+type concurrencyGauge struct {
+	current atomic.Int32
+	highest atomic.Int32
+}
+
+// enter marks a call as running until the returned func is called
+func (g *concurrencyGauge) enter() func() {
+	current := g.current.Add(1)
+	for {
+		highest := g.highest.Load()
+		if current <= highest || g.highest.CompareAndSwap(highest, current) {
+			break
+		}
+	}
+	return func() { g.current.Add(-1) }
+}
+
+func TestForEachBoundedNeverExceedsLimit(t *testing.T) {
+	const n, limit = 50, 4
+
+	var gauge concurrencyGauge
+	var calls [n]atomic.Int32
+	forEachBounded(n, limit, func(i int) {
+		defer gauge.enter()()
+		calls[i].Add(1)
+		time.Sleep(time.Millisecond)
+	})
+
+	if got := gauge.highest.Load(); got > limit {
+		t.Errorf("%d calls ran at once, limit is %d", got, limit)
+	}
+	for i := range calls {
+		if got := calls[i].Load(); got != 1 {
+			t.Errorf("index %d called %d times, want once", i, got)
+		}
+	}
+}
+
+func TestForEachBoundedWithoutWork(t *testing.T) {
+	forEachBounded(0, 4, func(int) {
+		t.Fatal("fn called with n = 0")
+	})
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// returnObjectPaths hands clients object paths instead of public URLs, for
+// deployments whose CDN layer builds the final URL itself
+var returnObjectPaths = config.ConfigBool("RETURN_OBJECT_PATHS", false)
+
+// gcsURLBase is the bucket's own public URL base, which images stored before
+// PUBLIC_URL_BASE was set still point at
+func (c *ClientUploader) gcsURLBase() string {
+	return fmt.Sprintf("https://storage.googleapis.com/%s", c.bucketName)
+}
+
+// publicURL is the URL objectPath is served at, under PUBLIC_URL_BASE when a
+// CDN fronts the bucket. The path is escaped, so filenames with spaces or
+// '?' still make a working URL.
+func (c *ClientUploader) publicURL(objectPath string) string {
+	escaped := (&url.URL{Path: objectPath}).EscapedPath()
+	return normalizeImageURL(c.publicURLBase + "/" + escaped)
+}
+
+// objectPathFromURL returns the object path behind a public URL of the
+// bucket, or "" if the URL points elsewhere
+func (c *ClientUploader) objectPathFromURL(rawURL string) string {
+	rawURL = normalizeImageURL(rawURL)
+	for _, base := range []string{c.publicURLBase, c.gcsURLBase()} {
+		objectPath, ok := strings.CutPrefix(rawURL, normalizeImageURL(base)+"/")
+		if !ok {
+			continue
+		}
+
+		path, query, hasQuery := strings.Cut(objectPath, "?")
+		if unescaped, err := url.PathUnescape(path); err == nil {
+			path = unescaped
+		}
+		if hasQuery {
+			return path + "?" + query
+		}
+		return path
+	}
+
+	return ""
+}
+
+// normalizeImageURL puts an image URL in the form it's stored and looked up
+// in, so the same image always compares equal: lowercase scheme and host,
+// duplicate and trailing slashes removed, no fragment, and the path escaped
+// one consistent way ("a b", "a%20b" and "%61%20b" all become "a%20b"). The
+// query is kept as is since signed URLs carry their signature there. Values
+// that aren't absolute URLs, like object paths, are only trimmed.
+func normalizeImageURL(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	for strings.Contains(u.Path, "//") {
+		u.Path = strings.ReplaceAll(u.Path, "//", "/")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	return u.String()
+}
+
+// imageURLCandidates are the stored forms a client's image URL can match: the
+// URL as given and normalized, each without the query of a signed URL, and
+// the unescaped form records stored before URLs were normalized have
+func imageURLCandidates(rawURL string) []string {
+	unsigned, _, _ := strings.Cut(rawURL, "?")
+	normalized, _, _ := strings.Cut(normalizeImageURL(rawURL), "?")
+	candidates := []string{rawURL, unsigned, normalized}
+
+	if u, err := url.Parse(normalized); err == nil && u.Scheme != "" {
+		candidates = append(candidates, u.Scheme+"://"+u.Host+u.Path)
+	}
+
+	slices.Sort(candidates)
+	return slices.Compact(candidates)
+}
+
+// clientURL is how a stored URL is handed back to clients: as is, or as its
+// object path when RETURN_OBJECT_PATHS is set. Signed and external URLs are
+// always returned as is.
+func clientURL(rawURL string) string {
+	if !returnObjectPaths {
+		return rawURL
+	}
+	if objectPath := uploader.objectPathFromURL(rawURL); objectPath != "" {
+		return objectPath
+	}
+	return rawURL
+}
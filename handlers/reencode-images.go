@@ -0,0 +1,232 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+// ReencodeBatchSize is how many images a re-encode job loads at a time
+const ReencodeBatchSize = 500
+
+// reencodeWorkers is how many images a re-encode job works on at once
+var reencodeWorkers = config.ConfigInt("REENCODE_WORKERS", 4)
+
+func init() {
+	if reencodeWorkers < 1 {
+		log.Fatalf("REENCODE_WORKERS must be at least 1")
+	}
+}
+
+// ReencodeRequest selects the images of a re-encode job. Every filter is
+// optional; without any, every stored image is re-encoded.
+type ReencodeRequest struct {
+	ImageIDs []uint `json:"image_ids"`
+	UserID   *uint  `json:"user_id"`
+	Status   string `json:"status"`
+	Quality  int    `json:"quality"`
+}
+
+// errNotJPEG reports an object named like a JPEG that holds another format
+var errNotJPEG = errors.New("not a JPEG")
+
+// reencodeQuery selects the images matched by input. Only JPEGs can be
+// re-encoded at a quality, and objects are named after their format, so the
+// rest are left out without downloading them.
+func reencodeQuery(db *gorm.DB, input ReencodeRequest) *gorm.DB {
+	query := db.Model(&models.Image{}).
+		Select("id", "object_path", "original_url").
+		Where(`LOWER(CASE WHEN object_path <> '' THEN object_path ELSE original_url END) ~ ?`, `\.jpe?g$`)
+	if input.UserID != nil {
+		query = query.Where("user_id = ?", *input.UserID)
+	}
+	if len(input.ImageIDs) > 0 {
+		query = query.Where("id IN ?", input.ImageIDs)
+	}
+	if input.Status != "" {
+		query = query.Where("status = ?", input.Status)
+	}
+	return query
+}
+
+// objectPathFor returns the storage path of an image, deriving it from the
+// public URL for records created before object paths were stored
+func (c *ClientUploader) objectPathFor(img models.Image) string {
+	if img.ObjectPath != "" {
+		return img.ObjectPath
+	}
+
+	return c.objectPathFromURL(img.OriginalURL)
+}
+
+// reencodeJPEG decodes data and encodes it again at quality. The encoder
+// writes no EXIF, so the image is decoded upright first; otherwise a photo
+// relying on its orientation tag would be stored rotated for good.
+func reencodeJPEG(data []byte, quality int) (*bytes.Reader, error) {
+	src, format, err := decodeImage(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if format != "jpeg" {
+		return nil, fmt.Errorf("%w: %s", errNotJPEG, format)
+	}
+
+	return encodeJPEG(src, quality)
+}
+
+func reencodeStoredImage(img models.Image, quality int) error {
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return fmt.Errorf("image %d: storage object unknown", img.ID)
+	}
+
+	data, err := uploader.Download(objectPath)
+	if err != nil {
+		return fmt.Errorf("image %d: %v", img.ID, err)
+	}
+
+	var reader *bytes.Reader
+	processingPool.run(func() {
+		reader, err = reencodeJPEG(data, quality)
+	})
+	// Left as it is, like an encoding that wouldn't save space
+	if errors.Is(err, errNotJPEG) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("image %d: %v", img.ID, err)
+	}
+
+	// Only overwrite when the new encoding actually saves space
+	if reader.Size() >= int64(len(data)) {
+		return nil
+	}
+
+	attrs, err := uploader.ReplaceFile(reader, objectPath, "")
+	if err != nil {
+		return fmt.Errorf("image %d: %v", img.ID, err)
+	}
+
+	db := database.GetDB()
+	if err := db.Model(&img).Updates(models.Image{ObjectPath: objectPath, SizeBytes: attrs.Size}).Error; err != nil {
+		return fmt.Errorf("image %d: failed to update record: %v", img.ID, err)
+	}
+
+	return nil
+}
+
+// runReencodeJob re-encodes the selected images in batches, so the whole
+// table is never loaded at once
+func runReencodeJob(job *Job, input ReencodeRequest) {
+	queue := make(chan models.Image)
+	var wg sync.WaitGroup
+
+	for i := 0; i < reencodeWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range queue {
+				if err := reencodeStoredImage(img, input.Quality); err != nil {
+					job.recordFailure(err)
+				} else {
+					job.recordSuccess()
+				}
+			}
+		}()
+	}
+
+	var batch []models.Image
+	db := database.GetDB().WithContext(job.ctx)
+	err := reencodeQuery(db, input).FindInBatches(&batch, ReencodeBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, img := range batch {
+			select {
+			case queue <- img:
+			case <-job.ctx.Done():
+				return job.ctx.Err()
+			}
+		}
+		return nil
+	}).Error
+	close(queue)
+	wg.Wait()
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		job.recordFailure(fmt.Errorf("failed to list images: %v", err))
+	}
+	job.finish()
+}
+
+// ReencodeImages starts an admin job re-compressing stored JPEG images. The
+// job belongs to the admin who started it.
+func ReencodeImages(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input ReencodeRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if input.Quality < 1 || input.Quality > 100 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "quality must be between 1 and 100",
+			"data":    nil,
+		})
+	}
+
+	if input.Status != "" && !imageStatuses[input.Status] {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "status must be one of pending, processing, completed or failed",
+			"data":    nil,
+		})
+	}
+
+	var total int64
+	if err := reencodeQuery(middleware.DB(c), input).Count(&total).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+
+	if total == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No matching JPEG images found",
+			"data":    nil,
+		})
+	}
+
+	job := newJob("reencode", userID, int(total))
+	go runReencodeJob(job, input)
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Re-encoding %d image(s)", total),
+		"data":    job.toMap(),
+	})
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestReencodeJobSkipsObjectsThatAreNotJPEG(t *testing.T) {
+	// Named like a JPEG, but holding a PNG
+	const objectPath = "users/1/photo.jpg"
+	original := encodePNG(t, testImage(8, 8))
+	fs := newFakeStorage(t)
+	fs.put(objectPath, original, nil)
+
+	// Only JPEG objects are selected, in batches
+	mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT "id","object_path","original_url" FROM "images" WHERE .*~ \$1.* ORDER BY "images"."id" LIMIT \$2`).
+		WithArgs(`\.jpe?g$`, ReencodeBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "object_path", "original_url"}).
+			AddRow(5, objectPath, ""))
+
+	job := newJob("reencode", 1, 1)
+	runReencodeJob(job, ReencodeRequest{Quality: 50})
+
+	if job.Failed != 0 || job.Completed != 1 {
+		t.Fatalf("completed = %d, failed = %d (%v), want the image left as it is", job.Completed, job.Failed, job.Errors)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !bytes.Equal(fs.objects[objectPath].data, original) {
+		t.Fatal("the stored object was overwritten")
+	}
+}
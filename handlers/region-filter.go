@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/gift"
+)
+
+// regionFilter applies a filter to one rectangle of the image and leaves the
+// rest untouched, e.g. to blur or pixelate a face for redaction. The
+// rectangle is clipped to the image it is applied to.
+type regionFilter struct {
+	rect   image.Rectangle
+	filter gift.Filter
+}
+
+func (f regionFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f regionFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	region := f.rect.Add(src.Bounds().Min).Intersect(src.Bounds())
+	if region.Empty() {
+		return
+	}
+
+	crop := gift.New(gift.Crop(region))
+	part := image.NewRGBA(crop.Bounds(src.Bounds()))
+	crop.Draw(part, src)
+
+	g := gift.New(f.filter)
+	filtered := image.NewRGBA(g.Bounds(part.Bounds()))
+	g.Draw(filtered, part)
+
+	at := region.Sub(src.Bounds().Min).Add(dst.Bounds().Min)
+	draw.Draw(dst, at, filtered, filtered.Bounds().Min, draw.Src)
+}
+
+// sizeChangingFilters can't be limited to a region, their output wouldn't fit
+// back into it
+var sizeChangingFilters = map[string]bool{
+	"resize":       true,
+	"crop_to_size": true,
+	"crop_rect":    true,
+	"rotate":       true,
+	"autotrim":     true,
+}
+
+// withRegion restricts filter to the "x:y:width:height" rectangle in param
+func withRegion(filterName string, filter gift.Filter, param string) (gift.Filter, error) {
+	rect, err := parseCropRect(param)
+	if err != nil {
+		return nil, FilterError{filterName, "region " + err.Error()}
+	}
+
+	if sizeChangingFilters[filterName] || filter.Bounds(rect).Size() != rect.Size() {
+		return nil, FilterError{filterName, "can't be applied to a region since it changes the image size"}
+	}
+
+	return regionFilter{rect: rect, filter: filter}, nil
+}
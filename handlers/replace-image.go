@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+// cdnPurgeURL receives the URLs of replaced objects as {"urls": [...]}, so a
+// CDN in front of the bucket can drop its cached copies. Nothing is purged
+// when it isn't set.
+var cdnPurgeURL = config.ConfigDefault("CDN_PURGE_URL", "")
+
+var cdnPurgeClient = &http.Client{Timeout: 10 * time.Second}
+
+// purgeCDN asks the CDN to forget urls in the background; a failed purge
+// only means clients see the old content until the cache expires
+func purgeCDN(urls ...string) {
+	if cdnPurgeURL == "" || len(urls) == 0 {
+		return
+	}
+
+	go func() {
+		body, err := json.Marshal(fiber.Map{"urls": urls})
+		if err != nil {
+			log.Printf("Failed to build CDN purge request: %v", err)
+			return
+		}
+
+		res, err := cdnPurgeClient.Post(cdnPurgeURL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("CDN purge failed: %v", err)
+			return
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode >= http.StatusBadRequest {
+			log.Printf("CDN purge failed with status %d", res.StatusCode)
+		}
+	}()
+}
+
+// ReplaceImageContent overwrites the stored object of an image with a new
+// file, keeping its ID, path and URL. The processed copy no longer matches
+// the new content, so it's cleared.
+func ReplaceImageContent(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Image has no known storage object",
+			"data":    nil,
+		})
+	}
+
+	file, err := c.FormFile("document")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "No file provided",
+			"data":    nil,
+		})
+	}
+
+	blobFile, err := file.Open()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error opening the file",
+			"data":    nil,
+		})
+	}
+	defer blobFile.Close()
+
+	// The new content passes the same checks as an upload
+	var src image.Image
+	var format string
+	processingPool.run(func() {
+		src, format, err = tracedDecodeImage(c.UserContext(), blobFile)
+		err = checkUploadFormat(format, err)
+	})
+	if err != nil {
+		return storageErrorResponse(c, err, "File is not a supported image")
+	}
+
+	if _, err := blobFile.Seek(0, io.SeekStart); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error reading the file",
+			"data":    nil,
+		})
+	}
+
+	attrs, err := uploader.ReplaceFile(blobFile, objectPath, rasterContentTypes[format])
+	if err != nil {
+		return storageErrorResponse(c, err, "Error replacing the file")
+	}
+
+	staleURLs := []string{img.OriginalURL}
+	if img.ProcessedURL != "" {
+		staleURLs = append(staleURLs, img.ProcessedURL)
+	}
+
+	img.ObjectPath = objectPath
+	img.SizeBytes = attrs.Size
+	processingPool.run(func() {
+		img.PHash = perceptualHash(src)
+		img.BlurHash = blurHash(src)
+	})
+	img.ProcessedURL = ""
+
+	db := middleware.DB(c)
+	if err := db.Model(&img).Select("ObjectPath", "SizeBytes", "PHash", "BlurHash", "ProcessedURL").Updates(&img).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update image",
+			"data":    nil,
+		})
+	}
+
+	purgeCDN(staleURLs...)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image content replaced",
+		"data":    img,
+	})
+}
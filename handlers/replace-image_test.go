@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestReplaceImageContentRejectsDisallowedFormat(t *testing.T) {
+	allowed := allowedContentTypes
+	allowedContentTypes = []string{"image/jpeg"}
+	t.Cleanup(func() { allowedContentTypes = allowed })
+
+	original := encodePNG(t, testImage(4, 4))
+	fs := newFakeStorage(t)
+	fs.put(deleteTestObject, original, nil)
+
+	mock := newMockDB(t)
+	expectImage(mock, 1)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("document", "edited.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(encodePNG(t, testImage(8, 8)))
+	form.Close()
+
+	app := fiber.New()
+	app.Put("/image/:id/content", asUser(1), ReplaceImageContent)
+
+	req := httptest.NewRequest("PUT", "/image/5/content", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, want 415", resp.StatusCode)
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if !bytes.Equal(fs.objects[deleteTestObject].data, original) {
+		t.Fatal("the stored object was replaced with a disallowed format")
+	}
+}
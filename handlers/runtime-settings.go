@@ -0,0 +1,370 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/auth"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Settings admins can change at runtime through /api/admin/settings
+const (
+	SettingGenerationDailyLimit = "generation_daily_limit"
+	SettingMaxBatchSize         = "max_batch_size"
+	SettingMaxImageWidth        = "max_image_width"
+	SettingMaxImageHeight       = "max_image_height"
+	SettingUploadDefaultFilters = "upload_default_filters"
+)
+
+// settingJWTSecrets is the settings row holding the JWT secrets rotated
+// through the admin API. It isn't a runtime setting, so the settings API
+// never shows or changes it.
+const settingJWTSecrets = "jwt_secrets"
+
+// storedSecrets is the value of the settingJWTSecrets row
+type storedSecrets struct {
+	Current  string   `json:"current"`
+	Previous []string `json:"previous"`
+}
+
+// runtimeSetting is a setting that can be overridden in the settings table.
+// Without an override it comes from envVar, or fallback when that's unset.
+// parse validates a value and converts it to what the handlers use.
+type runtimeSetting struct {
+	envVar   string
+	fallback string
+	parse    func(string) (any, error)
+}
+
+// runtimeSettings is the whitelist of settings the admin API accepts.
+// MaxImageWidth and MaxImageHeight stay hard ceilings, the settings can only
+// lower them.
+var runtimeSettings = map[string]runtimeSetting{
+	SettingGenerationDailyLimit: {"GENERATION_DAILY_LIMIT", "20", intSetting(0, math.MaxInt32)},
+	SettingMaxBatchSize:         {"MAX_BATCH_SIZE", "50", intSetting(1, math.MaxInt32)},
+	SettingMaxImageWidth:        {"MAX_IMAGE_WIDTH", strconv.Itoa(MaxImageWidth), intSetting(1, MaxImageWidth)},
+	SettingMaxImageHeight:       {"MAX_IMAGE_HEIGHT", strconv.Itoa(MaxImageHeight), intSetting(1, MaxImageHeight)},
+	SettingUploadDefaultFilters: {"UPLOAD_DEFAULT_FILTERS", "", func(raw string) (any, error) {
+		return parseFilterQuery(raw)
+	}},
+}
+
+func intSetting(min, max int) func(string) (any, error) {
+	return func(raw string) (any, error) {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < min || value > max {
+			return nil, fmt.Errorf("must be an integer between %d and %d", min, max)
+		}
+		return value, nil
+	}
+}
+
+// settingValue is the current value of a runtime setting
+type settingValue struct {
+	raw       string
+	parsed    any
+	updatedAt *time.Time
+}
+
+var (
+	settingDefaults = loadSettingDefaults()
+
+	// settingOverrides caches the settings table, keyed by setting
+	settingOverrides   = map[string]settingValue{}
+	settingOverridesMu sync.RWMutex
+
+	// How often the cache is reloaded, so changes made on another instance
+	// apply here too. 0 only loads it at startup.
+	settingsRefreshInterval = time.Duration(config.ConfigInt("SETTINGS_REFRESH_SECONDS", 30)) * time.Second
+)
+
+func loadSettingDefaults() map[string]settingValue {
+	defaults := make(map[string]settingValue, len(runtimeSettings))
+	for key, setting := range runtimeSettings {
+		raw := config.ConfigDefault(setting.envVar, setting.fallback)
+		parsed, err := setting.parse(raw)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", setting.envVar, err)
+		}
+		defaults[key] = settingValue{raw: raw, parsed: parsed}
+	}
+	return defaults
+}
+
+// currentSetting returns a setting's override, or its default without one
+func currentSetting(key string) settingValue {
+	settingOverridesMu.RLock()
+	defer settingOverridesMu.RUnlock()
+
+	if value, ok := settingOverrides[key]; ok {
+		return value
+	}
+	return settingDefaults[key]
+}
+
+// Maximum generations per user per UTC day, 0 disables the cap
+func dailyGenerationLimit() int {
+	return currentSetting(SettingGenerationDailyLimit).parsed.(int)
+}
+
+// maxBatchSize caps how many images one filter request or batch upload takes
+func maxBatchSize() int {
+	return currentSetting(SettingMaxBatchSize).parsed.(int)
+}
+
+// maxImageWidth and maxImageHeight limit the size of images accepted for
+// upload and processing
+func maxImageWidth() int {
+	return currentSetting(SettingMaxImageWidth).parsed.(int)
+}
+
+func maxImageHeight() int {
+	return currentSetting(SettingMaxImageHeight).parsed.(int)
+}
+
+// refreshSettings replaces the cached overrides with the settings table.
+// Rows that no longer parse, e.g. after a ceiling was lowered, are skipped so
+// the setting falls back to its default.
+func refreshSettings(db *gorm.DB) error {
+	var rows []models.Setting
+	if err := db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	overrides := make(map[string]settingValue, len(rows))
+	for _, row := range rows {
+		if row.Key == settingJWTSecrets {
+			applyStoredSecrets(row.Value)
+			continue
+		}
+
+		setting, ok := runtimeSettings[row.Key]
+		if !ok {
+			continue
+		}
+		parsed, err := setting.parse(row.Value)
+		if err != nil {
+			log.Printf("Ignoring invalid setting %s=%q: %v", row.Key, row.Value, err)
+			continue
+		}
+		overrides[row.Key] = settingValue{raw: row.Value, parsed: parsed, updatedAt: &row.UpdatedAt}
+	}
+
+	settingOverridesMu.Lock()
+	settingOverrides = overrides
+	settingOverridesMu.Unlock()
+	return nil
+}
+
+// applyStoredSecrets switches to the JWT secrets last rotated on any
+// instance. Until one is, JWT_SECRET and JWT_PREVIOUS_SECRETS apply.
+func applyStoredSecrets(value string) {
+	var stored storedSecrets
+	if err := json.Unmarshal([]byte(value), &stored); err != nil || len(stored.Current) < auth.MinSecretLength {
+		log.Printf("Ignoring invalid stored JWT secrets")
+		return
+	}
+	auth.SetSecrets(stored.Current, stored.Previous)
+}
+
+// StartRuntimeSettings loads the settings table and keeps reloading it in
+// the background. Until it loads, settings use their defaults.
+func StartRuntimeSettings() {
+	db := database.GetDB()
+	if err := refreshSettings(db); err != nil {
+		log.Printf("Failed to load settings, using defaults: %v", err)
+	}
+
+	if settingsRefreshInterval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(settingsRefreshInterval) {
+			if err := refreshSettings(db); err != nil {
+				log.Printf("Failed to refresh settings: %v", err)
+			}
+		}
+	}()
+}
+
+// SettingView is a runtime setting as shown to admins
+type SettingView struct {
+	Key        string     `json:"key"`
+	Value      string     `json:"value"`
+	Default    string     `json:"default"`
+	EnvVar     string     `json:"env_var"`
+	Overridden bool       `json:"overridden"`
+	UpdatedAt  *time.Time `json:"updated_at"`
+}
+
+func settingView(key string) SettingView {
+	current := currentSetting(key)
+	return SettingView{
+		Key:        key,
+		Value:      current.raw,
+		Default:    settingDefaults[key].raw,
+		EnvVar:     runtimeSettings[key].envVar,
+		Overridden: current.updatedAt != nil,
+		UpdatedAt:  current.updatedAt,
+	}
+}
+
+// setSettingOverride updates the cache after a change, so it applies on
+// this instance without waiting for the next refresh
+func setSettingOverride(key string, value *settingValue) {
+	settingOverridesMu.Lock()
+	defer settingOverridesMu.Unlock()
+
+	overrides := make(map[string]settingValue, len(settingOverrides)+1)
+	for k, v := range settingOverrides {
+		overrides[k] = v
+	}
+	if value != nil {
+		overrides[key] = *value
+	} else {
+		delete(overrides, key)
+	}
+	settingOverrides = overrides
+}
+
+func unknownSetting(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+		"status":  "error",
+		"message": "Unknown setting",
+		"data":    nil,
+	})
+}
+
+// GetSettings lists every runtime setting with its current value and the
+// default it falls back to
+func GetSettings(c *fiber.Ctx) error {
+	if err := refreshSettings(middleware.DB(c)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to load settings",
+			"data":    nil,
+		})
+	}
+
+	keys := make([]string, 0, len(runtimeSettings))
+	for key := range runtimeSettings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	settings := make([]SettingView, len(keys))
+	for i, key := range keys {
+		settings[i] = settingView(key)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Runtime settings",
+		"data":    settings,
+	})
+}
+
+// UpdateSetting overrides a runtime setting. Other instances pick the change
+// up on their next refresh.
+func UpdateSetting(c *fiber.Ctx) error {
+	type SettingInput struct {
+		Value *string `json:"value"`
+	}
+
+	key := c.Params("key")
+	setting, ok := runtimeSettings[key]
+	if !ok {
+		return unknownSetting(c)
+	}
+
+	var input SettingInput
+	if err := c.BodyParser(&input); err != nil || input.Value == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "value is required",
+			"data":    nil,
+		})
+	}
+
+	parsed, err := setting.parse(*input.Value)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": fmt.Sprintf("%s %v", key, err),
+			"data":    nil,
+		})
+	}
+
+	// The cache only changes once the row and its audit entry are committed
+	row := models.Setting{Key: key, Value: *input.Value, UpdatedBy: auditActor(c)}
+	err = middleware.DB(c).WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at", "updated_by"}),
+		}).Create(&row).Error
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, c, auditActor(c), models.AuditSettingUpdate, fmt.Sprintf("%s:%s", key, *input.Value))
+	})
+	if err != nil {
+		log.Printf("Failed to save setting %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to save setting",
+			"data":    nil,
+		})
+	}
+
+	setSettingOverride(key, &settingValue{raw: row.Value, parsed: parsed, updatedAt: &row.UpdatedAt})
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Setting updated",
+		"data":    settingView(key),
+	})
+}
+
+// ResetSetting removes a setting's override, returning it to its default
+func ResetSetting(c *fiber.Ctx) error {
+	key := c.Params("key")
+	if _, ok := runtimeSettings[key]; !ok {
+		return unknownSetting(c)
+	}
+
+	err := middleware.DB(c).WithContext(c.UserContext()).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Setting{}, "key = ?", key).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, auditActor(c), models.AuditSettingReset, key)
+	})
+	if err != nil {
+		log.Printf("Failed to reset setting %s: %v", key, err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to reset setting",
+			"data":    nil,
+		})
+	}
+
+	setSettingOverride(key, nil)
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Setting reset to its default",
+		"data":    settingView(key),
+	})
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/auth"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+func adminRequest(t *testing.T, app *fiber.App, method, target, body string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestUpdateSettingAppliesAfterCommit(t *testing.T) {
+	t.Cleanup(func() { setSettingOverride(SettingMaxBatchSize, nil) })
+
+	app := fiber.New()
+	app.Put("/settings/:key", asUser(1), UpdateSetting)
+
+	// The audit entry fails, so the saved row is rolled back and the cache
+	// must keep the default
+	mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "settings"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnError(errors.New("insert failed"))
+	mock.ExpectRollback()
+
+	if status := adminRequest(t, app, "PUT", "/settings/"+SettingMaxBatchSize, `{"value":"7"}`); status != fiber.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	if got, want := maxBatchSize(), settingDefaults[SettingMaxBatchSize].parsed.(int); got != want {
+		t.Fatalf("max batch size = %d after a rolled back update, want the default %d", got, want)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "settings"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	if status := adminRequest(t, app, "PUT", "/settings/"+SettingMaxBatchSize, `{"value":"7"}`); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if got := maxBatchSize(); got != 7 {
+		t.Fatalf("max batch size = %d after a committed update, want 7", got)
+	}
+}
+
+func TestSetMaintenanceModeNeedsAuditEntry(t *testing.T) {
+	t.Cleanup(func() { middleware.SetReadOnly(false) })
+
+	app := fiber.New()
+	app.Put("/maintenance", asUser(1), SetMaintenanceMode)
+
+	mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnError(errors.New("insert failed"))
+	mock.ExpectRollback()
+
+	if status := adminRequest(t, app, "PUT", "/maintenance", `{"read_only":true}`); status != fiber.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", status)
+	}
+	if middleware.IsReadOnly() {
+		t.Fatal("maintenance mode was enabled without an audit entry")
+	}
+}
+
+// secretsArg matches a stored JWT secrets row value
+type secretsArg storedSecrets
+
+func (a secretsArg) Match(v driver.Value) bool {
+	value, ok := v.(string)
+	if !ok {
+		return false
+	}
+	var stored storedSecrets
+	return json.Unmarshal([]byte(value), &stored) == nil &&
+		stored.Current == a.Current && slices.Equal(stored.Previous, a.Previous)
+}
+
+func restoreSecrets(t *testing.T) {
+	current, previous := auth.Secrets()
+	t.Cleanup(func() { auth.SetSecrets(current, previous) })
+}
+
+func TestRotateJWTSecretStoresSecrets(t *testing.T) {
+	restoreSecrets(t)
+	original, _ := auth.Secrets()
+	secret := strings.Repeat("n", auth.MinSecretLength)
+
+	app := fiber.New()
+	app.Post("/rotate", asUser(1), RotateJWTSecret)
+
+	// Without a stored row, the rotation starts from the environment secrets
+	mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "settings" WHERE key = \$1 LIMIT \$2 FOR UPDATE`).
+		WithArgs(settingJWTSecrets, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}))
+	mock.ExpectExec(`INSERT INTO "settings"`).
+		WithArgs(settingJWTSecrets, secretsArg{Current: secret, Previous: []string{original}}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`INSERT INTO "audit_logs"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	if status := adminRequest(t, app, "POST", "/rotate", `{"secret":"`+secret+`"}`); status != fiber.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if current, previous := auth.Secrets(); current != secret || !slices.Equal(previous, []string{original}) {
+		t.Fatalf("secrets = %q, %q after rotating", current, previous)
+	}
+}
+
+func TestRefreshSettingsAppliesStoredSecrets(t *testing.T) {
+	restoreSecrets(t)
+	stored := storedSecrets{Current: strings.Repeat("s", auth.MinSecretLength), Previous: []string{strings.Repeat("p", auth.MinSecretLength)}}
+	value, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "settings"`).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow(settingJWTSecrets, string(value)))
+
+	if err := refreshSettings(database.GetDB()); err != nil {
+		t.Fatal(err)
+	}
+	if current, previous := auth.Secrets(); current != stored.Current || !slices.Equal(previous, stored.Previous) {
+		t.Fatalf("secrets = %q, %q, want the stored ones", current, previous)
+	}
+
+	// The secrets never show up as a runtime setting
+	settingOverridesMu.RLock()
+	_, ok := settingOverrides[settingJWTSecrets]
+	settingOverridesMu.RUnlock()
+	if ok {
+		t.Fatal("the stored secrets were cached as a setting override")
+	}
+}
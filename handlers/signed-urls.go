@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+)
+
+// V4 signed URLs can't be valid for longer than a week
+const MaxSignedURLExpiry = 7 * 24 * time.Hour
+
+var signedURLExpiry = loadSignedURLExpiry()
+
+// privateUploads makes UploadImage hand out signed URLs instead of public
+// ones, for deployments that keep the bucket private
+var privateUploads = config.ConfigBool("PRIVATE_UPLOADS", false)
+
+// Visibilities an upload can ask for
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// objectACLs gives uploaded objects an ACL matching their visibility. Only
+// buckets with fine-grained access control accept object ACLs; with uniform
+// bucket-level access the visibility just picks public or signed URLs.
+var objectACLs = config.ConfigBool("OBJECT_ACLS", false)
+
+// parseVisibility validates an upload's ?visibility=, which defaults to
+// private under PRIVATE_UPLOADS and public otherwise
+func parseVisibility(param string) (string, error) {
+	switch param {
+	case "":
+		if privateUploads {
+			return VisibilityPrivate, nil
+		}
+		return VisibilityPublic, nil
+	case VisibilityPublic, VisibilityPrivate:
+		return param, nil
+	}
+	return "", fmt.Errorf("visibility must be %s or %s", VisibilityPublic, VisibilityPrivate)
+}
+
+// predefinedACL is the object ACL for a visibility, or "" to leave access to
+// the bucket's policy
+func predefinedACL(visibility string) string {
+	if !objectACLs {
+		return ""
+	}
+	switch visibility {
+	case VisibilityPublic:
+		return "publicRead"
+	case VisibilityPrivate:
+		return "projectPrivate"
+	}
+	return ""
+}
+
+func loadSignedURLExpiry() time.Duration {
+	expiry := time.Duration(config.ConfigInt("SIGNED_URL_EXPIRY_MINUTES", 24*60)) * time.Minute
+	if expiry <= 0 || expiry > MaxSignedURLExpiry {
+		log.Fatalf("SIGNED_URL_EXPIRY_MINUTES must be between 1 and %d", int(MaxSignedURLExpiry.Minutes()))
+	}
+	return expiry
+}
+
+// SignedURL returns a time-limited GET URL for a stored object
+func (c *ClientUploader) SignedURL(objectPath string) (string, error) {
+	return c.signedURLFor(objectPath, signedURLExpiry)
+}
+
+// signedURLFor returns a GET URL for a stored object valid for expiry
+func (c *ClientUploader) signedURLFor(objectPath string, expiry time.Duration) (string, error) {
+	opts := &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  "GET",
+		Expires: time.Now().Add(expiry),
+	}
+
+	bucket, err := c.bucket()
+	if err != nil {
+		return "", err
+	}
+
+	signedURL, err := bucket.SignedURL(objectPath, opts)
+	if err != nil {
+		return "", classifyStorageError("SignedURL", err)
+	}
+
+	return signedURL, nil
+}
+
+// signURL turns a stored public URL into a signed one. URLs outside the
+// bucket are returned unchanged.
+func (c *ClientUploader) signURL(url string) (string, error) {
+	objectPath := c.objectPathFromURL(url)
+	if objectPath == "" {
+		return url, nil
+	}
+
+	return c.SignedURL(objectPath)
+}
+
+// signed returns a copy of the result with signed URLs for the response. The
+// database keeps the unsigned URLs so they can be signed again later.
+func (r UploadResult) signed() (UploadResult, error) {
+	var err error
+	if r.URL, err = uploader.signURL(r.URL); err != nil {
+		return r, err
+	}
+	if r.ProcessedURL != "" {
+		if r.ProcessedURL, err = uploader.signURL(r.ProcessedURL); err != nil {
+			return r, err
+		}
+	}
+
+	return r, nil
+}
+
+// responseURLs returns a stored image's URLs as clients get them: freshly
+// signed when the image is private, through clientURL otherwise
+func responseURLs(img models.Image) (UploadResult, error) {
+	urls := UploadResult{URL: img.OriginalURL, ProcessedURL: img.ProcessedURL}
+	if img.Private {
+		return urls.signed()
+	}
+
+	urls.URL = clientURL(urls.URL)
+	if urls.ProcessedURL != "" {
+		urls.ProcessedURL = clientURL(urls.ProcessedURL)
+	}
+	return urls, nil
+}
+
+// withResponseURLs replaces the stored URLs of images about to be sent with
+// their responseURLs
+func withResponseURLs(images []models.Image) error {
+	for i := range images {
+		urls, err := responseURLs(images[i])
+		if err != nil {
+			return err
+		}
+		images[i].OriginalURL, images[i].ProcessedURL = urls.URL, urls.ProcessedURL
+	}
+	return nil
+}
+
+// GetImageURL returns URLs to access one of your images, freshly signed when
+// the image was stored privately
+func GetImageURL(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	urls := UploadResult{URL: img.OriginalURL, ProcessedURL: img.ProcessedURL}
+	data := fiber.Map{"private": img.Private}
+
+	if img.Private {
+		urls, err = urls.signed()
+		if err != nil {
+			return storageErrorResponse(c, err, "Failed to sign image URL")
+		}
+		data["expires_at"] = time.Now().Add(signedURLExpiry).UTC()
+	}
+
+	data["url"] = urls.URL
+	if urls.ProcessedURL != "" {
+		data["processed_url"] = urls.ProcessedURL
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image URL retrieved",
+		"data":    data,
+	})
+}
@@ -0,0 +1,212 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ReconcileWorkers   = 8
+	ReconcileBatchSize = 500
+)
+
+// How often every image's size is checked against storage in the
+// background, 0 only reconciles when an admin asks
+var sizeReconcileInterval = time.Duration(config.ConfigInt("SIZE_RECONCILE_INTERVAL_HOURS", 0)) * time.Hour
+
+// reconcilingSizes keeps two reconciliations from walking the table at once
+var reconcilingSizes atomic.Bool
+
+// sizeReconcileQuery selects the images to check: only those without a size
+// unless all is set, which also catches objects replaced out of band
+func sizeReconcileQuery(db *gorm.DB, all bool) *gorm.DB {
+	query := db.Model(&models.Image{}).Select("id", "object_path", "original_url", "size_bytes")
+	if !all {
+		query = query.Where("size_bytes = 0")
+	}
+	return query
+}
+
+// reconcileImageSize reads an image's object from storage and corrects the
+// stored size, and the object path of records created before it was kept
+func reconcileImageSize(img models.Image) error {
+	objectPath := uploader.objectPathFor(img)
+	if objectPath == "" {
+		return fmt.Errorf("image %d: storage object unknown", img.ID)
+	}
+
+	attrs, err := uploader.Attrs(objectPath)
+	if err != nil {
+		return fmt.Errorf("image %d: %v", img.ID, err)
+	}
+
+	if attrs.Size == img.SizeBytes && img.ObjectPath != "" {
+		return nil
+	}
+
+	db := database.GetDB()
+	updates := map[string]interface{}{"size_bytes": attrs.Size, "object_path": objectPath}
+	if err := db.Model(&models.Image{}).Where("id = ?", img.ID).UpdateColumns(updates).Error; err != nil {
+		return fmt.Errorf("image %d: failed to update record: %v", img.ID, err)
+	}
+	if attrs.Size != img.SizeBytes {
+		log.Printf("Reconciled size of image %d: %d -> %d bytes", img.ID, img.SizeBytes, attrs.Size)
+	}
+
+	return nil
+}
+
+// runSizeReconcileJob checks the selected images in batches, so the whole
+// table is never loaded at once
+func runSizeReconcileJob(job *Job, all bool) {
+	defer reconcilingSizes.Store(false)
+
+	queue := make(chan models.Image)
+	var wg sync.WaitGroup
+
+	for i := 0; i < ReconcileWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for img := range queue {
+				if err := reconcileImageSize(img); err != nil {
+					job.recordFailure(err)
+				} else {
+					job.recordSuccess()
+				}
+			}
+		}()
+	}
+
+	var batch []models.Image
+	db := database.GetDB().WithContext(job.ctx)
+	err := sizeReconcileQuery(db, all).FindInBatches(&batch, ReconcileBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, img := range batch {
+			select {
+			case queue <- img:
+			case <-job.ctx.Done():
+				return job.ctx.Err()
+			}
+		}
+		return nil
+	}).Error
+	close(queue)
+	wg.Wait()
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		job.recordFailure(fmt.Errorf("failed to list images: %v", err))
+	}
+	job.finish()
+}
+
+// startSizeReconcile counts the images to check and starts the job, or
+// returns nil when one is already running
+func startSizeReconcile(db *gorm.DB, userID uint, all bool) (*Job, error) {
+	if !reconcilingSizes.CompareAndSwap(false, true) {
+		return nil, nil
+	}
+
+	var total int64
+	if err := sizeReconcileQuery(db, all).Count(&total).Error; err != nil {
+		reconcilingSizes.Store(false)
+		return nil, err
+	}
+
+	job := newJob("reconcile_sizes", userID, int(total))
+	go runSizeReconcileJob(job, all)
+	return job, nil
+}
+
+// StartSizeReconciliation checks every image's size against storage each
+// SIZE_RECONCILE_INTERVAL_HOURS, when set
+func StartSizeReconciliation() {
+	if sizeReconcileInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(sizeReconcileInterval) {
+			job, err := startSizeReconcile(database.GetDB(), 0, true)
+			if err != nil {
+				log.Printf("Failed to start size reconciliation: %v", err)
+			} else if job == nil {
+				log.Printf("Skipping size reconciliation, one is still running")
+			}
+		}
+	}()
+}
+
+// ReconcileImageSizes backfills SizeBytes from storage for images without
+// one, or with {"all": true} checks every image and corrects any that drifted.
+// It runs as a job whose progress is read from /api/jobs/:id.
+func ReconcileImageSizes(c *fiber.Ctx) error {
+	type ReconcileInput struct {
+		All bool `json:"all"`
+	}
+
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input ReconcileInput
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Invalid request body",
+				"data":    nil,
+			})
+		}
+	}
+
+	if _, err := uploader.bucket(); err != nil {
+		return storageErrorResponse(c, err, "Storage is unavailable")
+	}
+
+	job, err := startSizeReconcile(middleware.DB(c), userID, input.All)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Database error",
+			"data":    nil,
+		})
+	}
+	if job == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"status":  "error",
+			"message": "A size reconciliation is already running",
+			"data":    nil,
+		})
+	}
+
+	target := "missing"
+	if input.All {
+		target = "all"
+	}
+	if err := recordAudit(middleware.DB(c), c, &userID, models.AuditSizeReconcile, target); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Reconciling the size of %d image(s)", job.Total),
+		"data":    job.toMap(),
+	})
+}
@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+)
+
+// confirmationTTL is how long a confirmation token for a destructive admin
+// action stays usable
+const confirmationTTL = 5 * time.Minute
+
+type confirmation struct {
+	userID  uint
+	action  string
+	expires time.Time
+}
+
+// confirmations holds the tokens handed out for destructive admin actions.
+// Each token is single use and only valid for the admin and action it was
+// issued for.
+var confirmations = struct {
+	sync.Mutex
+	tokens map[string]confirmation
+}{tokens: map[string]confirmation{}}
+
+func issueConfirmation(userID uint, action string) (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	confirmations.Lock()
+	defer confirmations.Unlock()
+
+	now := time.Now()
+	for t, pending := range confirmations.tokens {
+		if now.After(pending.expires) {
+			delete(confirmations.tokens, t)
+		}
+	}
+	confirmations.tokens[token] = confirmation{userID: userID, action: action, expires: now.Add(confirmationTTL)}
+
+	return token, nil
+}
+
+// redeemConfirmation consumes a token, reporting whether it was issued to
+// userID for action and hasn't expired
+func redeemConfirmation(token string, userID uint, action string) bool {
+	confirmations.Lock()
+	defer confirmations.Unlock()
+
+	pending, ok := confirmations.tokens[token]
+	if !ok {
+		return false
+	}
+	delete(confirmations.tokens, token)
+
+	return pending.userID == userID && pending.action == action && time.Now().Before(pending.expires)
+}
+
+// MakeBucketPublic grants everyone read access to the bucket. It takes two
+// calls: the first returns a confirmation token, and only a second call
+// passing that token within five minutes changes the bucket policy.
+func MakeBucketPublic(c *fiber.Ctx) error {
+	type MakePublicInput struct {
+		Confirm string `json:"confirm"`
+	}
+
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "You are not authorized!",
+			"data":    nil,
+		})
+	}
+
+	var input MakePublicInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	if input.Confirm == "" {
+		token, err := issueConfirmation(userID, models.AuditBucketPublic)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Failed to issue confirmation token",
+				"data":    nil,
+			})
+		}
+
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"status":  "success",
+			"message": fmt.Sprintf("This makes every object in bucket %s publicly readable. Repeat the request with this confirmation token to proceed.", uploader.bucketName),
+			"data": fiber.Map{
+				"confirm":    token,
+				"expires_at": time.Now().Add(confirmationTTL).UTC(),
+			},
+		})
+	}
+
+	if !redeemConfirmation(input.Confirm, userID, models.AuditBucketPublic) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid or expired confirmation token",
+			"data":    nil,
+		})
+	}
+
+	if err := uploader.MakeBucketPublic(); err != nil {
+		return storageErrorResponse(c, err, "Failed to make bucket public")
+	}
+	if err := recordAudit(middleware.DB(c), c, &userID, models.AuditBucketPublic, "bucket:"+uploader.bucketName); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Bucket is now publicly readable",
+		"data":    fiber.Map{"bucket": uploader.bucketName},
+	})
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// Storage failures are classified so handlers can pick a status code and
+// callers can tell whether an operation is worth retrying
+var (
+	ErrStorageTransient   = errors.New("storage temporarily unavailable")
+	ErrStorageUnavailable = errors.New("storage client unavailable")
+	ErrStorageAuth        = errors.New("storage authentication failed")
+	ErrStorageQuota       = errors.New("storage quota exceeded")
+	ErrStorageNotFound    = errors.New("storage object not found")
+	ErrStorageFailed      = errors.New("storage operation failed")
+)
+
+// StorageError is returned by ClientUploader methods. It matches both its
+// classification and the underlying error with errors.Is.
+type StorageError struct {
+	Kind error
+	Op   string
+	Err  error
+}
+
+func (e *StorageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Op, e.Err)
+}
+
+func (e *StorageError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+var quotaReasons = map[string]bool{
+	"rateLimitExceeded":     true,
+	"userRateLimitExceeded": true,
+	"quotaExceeded":         true,
+}
+
+func classifyStorageError(op string, err error) error {
+	kind := ErrStorageFailed
+
+	var apiErr *googleapi.Error
+	var netErr net.Error
+	switch {
+	case errors.Is(err, storage.ErrObjectNotExist), errors.Is(err, storage.ErrBucketNotExist):
+		kind = ErrStorageNotFound
+	case errors.As(err, &apiErr):
+		kind = classifyAPIError(apiErr)
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr):
+		kind = ErrStorageTransient
+	}
+
+	return &StorageError{Kind: kind, Op: op, Err: err}
+}
+
+func classifyAPIError(apiErr *googleapi.Error) error {
+	for _, item := range apiErr.Errors {
+		if quotaReasons[item.Reason] {
+			return ErrStorageQuota
+		}
+	}
+
+	switch {
+	case apiErr.Code == http.StatusTooManyRequests:
+		return ErrStorageQuota
+	case apiErr.Code == http.StatusUnauthorized, apiErr.Code == http.StatusForbidden:
+		return ErrStorageAuth
+	case apiErr.Code == http.StatusNotFound:
+		return ErrStorageNotFound
+	case apiErr.Code == http.StatusRequestTimeout, apiErr.Code >= 500:
+		return ErrStorageTransient
+	}
+
+	return ErrStorageFailed
+}
+
+// IsRetryableStorageError reports whether a storage error is likely to
+// succeed if the operation is tried again
+func IsRetryableStorageError(err error) bool {
+	return errors.Is(err, ErrStorageTransient)
+}
+
+// storageErrorResponse writes the response for a failed storage operation
+func storageErrorResponse(c *fiber.Ctx, err error, message string) error {
+	status := fiber.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, errUnsupportedImage):
+		status = fiber.StatusUnsupportedMediaType
+		message = err.Error()
+	case errors.Is(err, errImageTooLarge):
+		status = fiber.StatusRequestEntityTooLarge
+		message = err.Error()
+	case errors.Is(err, ErrStorageTransient):
+		status = fiber.StatusServiceUnavailable
+		message = message + ", please try again"
+	case errors.Is(err, ErrStorageUnavailable):
+		status = fiber.StatusServiceUnavailable
+		message = message + ": storage is not configured"
+	case errors.Is(err, ErrStorageQuota):
+		status = fiber.StatusInsufficientStorage
+		message = message + ": storage quota exceeded"
+	case errors.Is(err, ErrStorageNotFound):
+		status = fiber.StatusNotFound
+		message = message + ": object not found"
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"status":  "error",
+		"message": message,
+		"data":    nil,
+	})
+}
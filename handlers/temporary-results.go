@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+	"google.golang.org/api/iterator"
+)
+
+// temporaryResultTTL is how long a throwaway filter result stays available:
+// both the lifetime of its signed URL and the age after which it's deleted
+var temporaryResultTTL = loadTemporaryResultTTL()
+
+// How often expired temporary results are deleted. 0 disables the cleanup,
+// for buckets with a lifecycle rule on the temporary prefix instead.
+var temporaryCleanupInterval = time.Duration(config.ConfigInt("TEMPORARY_RESULT_CLEANUP_MINUTES", 15)) * time.Minute
+
+func loadTemporaryResultTTL() time.Duration {
+	ttl := time.Duration(config.ConfigInt("TEMPORARY_RESULT_TTL_MINUTES", 60)) * time.Minute
+	if ttl <= 0 || ttl > MaxSignedURLExpiry {
+		log.Fatalf("TEMPORARY_RESULT_TTL_MINUTES must be between 1 and %d", int(MaxSignedURLExpiry.Minutes()))
+	}
+	return ttl
+}
+
+// temporaryPrefix is where throwaway results are stored, apart from the
+// objects images are recorded for
+func (c *ClientUploader) temporaryPrefix() string {
+	return c.uploadPath + "tmp/"
+}
+
+// UploadTemporaryFile stores a processed image that gets no image record and
+// returns a signed URL valid for temporaryResultTTL. The object is private
+// and deleted once it expires.
+func (c *ClientUploader) UploadTemporaryFile(ctx context.Context, file io.Reader, object string, metadata ObjectMetadata) (string, *storage.ObjectAttrs, error) {
+	objectPath := fmt.Sprintf("%s%d/%d_%s", c.temporaryPrefix(), metadata.OwnerID, time.Now().UnixNano(), object)
+	metadata.Visibility = VisibilityPrivate
+
+	attrs, err := c.writeProcessedObject(ctx, file, objectPath, metadata)
+	if err != nil {
+		return "", nil, err
+	}
+
+	signedURL, err := c.signedURLFor(objectPath, temporaryResultTTL)
+	if err != nil {
+		if err := c.Delete(objectPath); err != nil {
+			log.Printf("Failed to delete %s: %v", objectPath, err)
+		}
+		return "", nil, err
+	}
+
+	return signedURL, attrs, nil
+}
+
+// cleanTemporaryResults deletes temporary results older than
+// temporaryResultTTL and returns how many it removed
+func cleanTemporaryResults(ctx context.Context) (int, error) {
+	bucket, err := uploader.bucket()
+	if err != nil {
+		return 0, err
+	}
+
+	query := &storage.Query{Prefix: uploader.temporaryPrefix()}
+	if err := query.SetAttrSelection([]string{"Name", "Created"}); err != nil {
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-temporaryResultTTL)
+	deleted := 0
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, classifyStorageError("Objects", err)
+		}
+		if !attrs.Created.Before(cutoff) {
+			continue
+		}
+
+		if err := uploader.Delete(attrs.Name); err != nil {
+			log.Printf("Failed to delete expired temporary result %s: %v", attrs.Name, err)
+			continue
+		}
+		deleted++
+	}
+}
+
+// StartTemporaryResultCleanup deletes expired temporary results every
+// TEMPORARY_RESULT_CLEANUP_MINUTES
+func StartTemporaryResultCleanup() {
+	if temporaryCleanupInterval <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(temporaryCleanupInterval) {
+			deleted, err := cleanTemporaryResults(context.Background())
+			if err != nil {
+				log.Printf("Failed to clean up temporary results: %v", err)
+			}
+			if deleted > 0 {
+				log.Printf("Deleted %d expired temporary result(s)", deleted)
+			}
+		}
+	}()
+}
+
+// sendTemporaryResults answers a ?temporary=true filter request with the
+// signed URLs of its results and when they expire
+func sendTemporaryResults(c *fiber.Ctx, results []UploadResult) error {
+	expiresAt := time.Now().Add(temporaryResultTTL).UTC()
+	responseData := make([]fiber.Map, len(results))
+	for i, result := range results {
+		responseData[i] = fiber.Map{
+			"url":        result.URL,
+			"filename":   result.Filename,
+			"width":      result.Width,
+			"height":     result.Height,
+			"format":     result.Format,
+			"expires_at": expiresAt,
+		}
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": fmt.Sprintf("Successfully processed %d temporary image(s)", len(results)),
+		"data":    responseData,
+	})
+}
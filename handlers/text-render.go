@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Positions an overlay can be anchored to within an image
+var overlayPositions = map[string]bool{
+	"top-left":     true,
+	"top-right":    true,
+	"bottom-left":  true,
+	"bottom-right": true,
+	"center":       true,
+}
+
+var bundledFont = mustParseFont(goregular.TTF)
+
+func mustParseFont(ttf []byte) *opentype.Font {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		panic(fmt.Sprintf("failed to parse bundled font: %v", err))
+	}
+	return f
+}
+
+// renderText draws text onto a transparent image just large enough to hold it
+func renderText(text string, size float64, col color.Color) (*image.RGBA, error) {
+	face, err := opentype.NewFace(bundledFont, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %v", err)
+	}
+	defer face.Close()
+
+	metrics := face.Metrics()
+	width := font.MeasureString(face, text).Ceil()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+
+	img := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(col),
+		Face: face,
+		Dot:  fixed.Point26_6{X: 0, Y: metrics.Ascent},
+	}
+	drawer.DrawString(text)
+
+	return img, nil
+}
+
+// placeOverlay returns where an overlay of the given size goes inside bounds
+func placeOverlay(bounds image.Rectangle, size image.Point, position string, margin int) image.Rectangle {
+	x := bounds.Min.X + margin
+	y := bounds.Min.Y + margin
+
+	switch position {
+	case "top-right":
+		x = bounds.Max.X - size.X - margin
+	case "bottom-left":
+		y = bounds.Max.Y - size.Y - margin
+	case "bottom-right":
+		x = bounds.Max.X - size.X - margin
+		y = bounds.Max.Y - size.Y - margin
+	case "center":
+		x = bounds.Min.X + (bounds.Dx()-size.X)/2
+		y = bounds.Min.Y + (bounds.Dy()-size.Y)/2
+	}
+
+	return image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+size.X, y+size.Y)}
+}
+
+// drawOverlay composites overlay onto dst at rect with the given opacity (0-1)
+func drawOverlay(dst draw.Image, rect image.Rectangle, overlay image.Image, opacity float64) {
+	mask := image.NewUniform(color.Alpha{A: uint8(opacity * 255)})
+	draw.DrawMask(dst, rect, overlay, overlay.Bounds().Min, mask, image.Point{}, draw.Over)
+}
@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+	"github.com/krishkalaria12/snap-serve/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	MaxTags      = 20
+	MaxTagLength = 32
+)
+
+var imageStatuses = map[string]bool{
+	models.ImageStatusPending:    true,
+	models.ImageStatusProcessing: true,
+	models.ImageStatusCompleted:  true,
+	models.ImageStatusFailed:     true,
+}
+
+// UpdateImageRequest holds the fields an owner may change on an image. Fields
+// left out of the request body are not touched.
+type UpdateImageRequest struct {
+	Status       *string   `json:"status"`
+	ProcessedURL *string   `json:"processed_url"`
+	Tags         *[]string `json:"tags"`
+}
+
+// normalizeTags trims, lowercases and de-duplicates tags, keeping their order
+func normalizeTags(tags []string) (models.Tags, error) {
+	normalized := models.Tags{}
+	seen := map[string]bool{}
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			return nil, fmt.Errorf("tags can't be empty")
+		}
+		if len(tag) > MaxTagLength {
+			return nil, fmt.Errorf("tag %q is too long (max %d characters)", tag, MaxTagLength)
+		}
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MaxTags {
+		return nil, fmt.Errorf("too many tags (max %d)", MaxTags)
+	}
+
+	return normalized, nil
+}
+
+// updates validates the request and returns the columns to change
+func (r UpdateImageRequest) updates() (map[string]interface{}, error) {
+	updates := map[string]interface{}{}
+
+	if r.Status != nil {
+		if !imageStatuses[*r.Status] {
+			return nil, fmt.Errorf("status must be one of pending, processing, completed or failed")
+		}
+		updates["status"] = *r.Status
+	}
+
+	if r.ProcessedURL != nil {
+		// An empty URL clears it
+		if *r.ProcessedURL != "" {
+			parsed, err := url.ParseRequestURI(*r.ProcessedURL)
+			if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return nil, fmt.Errorf("processed_url must be an http or https URL")
+			}
+		}
+		updates["processed_url"] = normalizeImageURL(*r.ProcessedURL)
+	}
+
+	if r.Tags != nil {
+		tags, err := normalizeTags(*r.Tags)
+		if err != nil {
+			return nil, err
+		}
+		updates["tags"] = tags
+	}
+
+	if len(updates) == 0 {
+		return nil, fmt.Errorf("nothing to update, expected status, processed_url or tags")
+	}
+
+	return updates, nil
+}
+
+func UpdateImage(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input UpdateImageRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	updates, err := input.updates()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	db := middleware.DB(c)
+	if err := db.Model(&img).Updates(updates).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update image",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Image updated",
+		"data":    img,
+	})
+}
+
+// ToggleFavorite stars an image, or un-stars it if it was already starred
+func ToggleFavorite(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	img, err := getOwnedImage(middleware.DB(c), c.Params("id"), userID)
+	if err != nil {
+		return imageLookupError(c, err)
+	}
+
+	// Flipped in SQL, so concurrent toggles can't both read the same value
+	db := middleware.DB(c)
+	err = db.Model(&img).Clauses(clause.Returning{Columns: []clause.Column{{Name: "favorite"}}}).
+		Update("favorite", gorm.Expr("NOT favorite")).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Failed to update image",
+			"data":    nil,
+		})
+	}
+
+	favorite := img.Favorite
+	message := "Image removed from favorites"
+	if favorite {
+		message = "Image added to favorites"
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": message,
+		"data": fiber.Map{
+			"id":       img.ID,
+			"favorite": favorite,
+		},
+	})
+}
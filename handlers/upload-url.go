@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"net/url"
+	"path"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/middleware"
+)
+
+type UploadURLRequest struct {
+	URL string `json:"url"`
+}
+
+// remoteFilename picks a filename for a fetched image from its URL path
+func remoteFilename(u *url.URL) string {
+	name := path.Base(u.Path)
+	if name == "" || name == "." || name == "/" {
+		return "remote_image"
+	}
+	return name
+}
+
+// fetchRemoteImage downloads an image from a URL with the same checks the
+// filter pipeline applies to its sources
+func fetchRemoteImage(ctx context.Context, imageURL string) ([]byte, error) {
+	res, err := openImageURL(ctx, imageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := readImageBody(res)
+	if err != nil {
+		return nil, err
+	}
+
+	var img image.Image
+	processingPool.run(func() {
+		img, _, err = tracedDecodeImage(ctx, bytes.NewReader(data))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkImageDimensions(img); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func UploadImageFromURL(c *fiber.Ctx) error {
+	userID, err := middleware.CheckUserLoggedIn(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Authentication required",
+			"data":    nil,
+		})
+	}
+
+	var input UploadURLRequest
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Invalid request body",
+			"data":    nil,
+		})
+	}
+
+	parsed, err := url.Parse(input.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": "url must be a valid http or https URL",
+			"data":    nil,
+		})
+	}
+
+	data, err := fetchRemoteImage(c.UserContext(), parsed.String())
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "error",
+			"message": err.Error(),
+			"data":    nil,
+		})
+	}
+
+	result, err := storeUploadedFile(c.UserContext(), bytes.NewReader(data), remoteFilename(parsed), userID, SourceUploadURL, "")
+	if err != nil {
+		return storageErrorResponse(c, err, "Error uploading the file")
+	}
+
+	if err := uploadImageToDB(c.UserContext(), result, userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Error saving to database",
+			"data":    nil,
+		})
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":  "success",
+		"message": "Successfully uploaded the image",
+		"data":    result.displayURL(),
+	})
+}
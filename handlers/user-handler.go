@@ -2,18 +2,20 @@ package handler
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/auth"
 	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/middleware"
 	"github.com/krishkalaria12/snap-serve/models"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 func hashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), 10)
-	return string(hashed), err
+	return auth.HashPassword(password)
 }
 
 func GetUser(c *fiber.Ctx) error {
@@ -49,20 +51,32 @@ func CreateUser(c *fiber.Ctx) error {
 		FullName string `json:"name"`
 	}
 
-	db := database.GetDB()
+	db := middleware.DB(c)
 	user := new(models.User)
 
 	if err := c.BodyParser(user); err != nil {
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "Wrong Input Data Format", "data": err})
 	}
 
+	if err := validateUserFields(&user.Username, &user.FullName); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"status": "error", "message": err.Error(), "data": fieldErrorData(err)})
+	}
+
+	var existingUser models.User
+	if err := db.Where("LOWER(username) = LOWER(?)", user.Username).First(&existingUser).Error; err == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"status": "error", "message": "Username already taken", "data": nil})
+	}
+
+	// Roles are only ever granted by an admin, never at sign up
+	user.Role = models.RoleUser
+
 	hash, err := hashPassword(user.Password)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "Failed to hash password", "data": err})
 	}
 	user.Password = hash
 
-	if err := db.Create(&user); err != nil {
+	if err := db.Create(user).Error; err != nil {
 		return c.Status(500).JSON(fiber.Map{"status": "error", "message": "Failed to create user", "data": err})
 	}
 
@@ -107,7 +121,7 @@ func UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	db := database.GetDB()
+	db := middleware.DB(c)
 	var user models.User
 
 	if err := db.First(&user, id).Error; err != nil {
@@ -126,18 +140,17 @@ func UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
-	// Optional: Add validation for input fields
-	if userInput.Username == "" || userInput.FullName == "" {
+	if err := validateUserFields(&userInput.Username, &userInput.FullName); err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"message": "Username and name are required",
+			"message": err.Error(),
 			"status":  "error",
-			"data":    nil,
+			"data":    fieldErrorData(err),
 		})
 	}
 
-	// Optional: Check if username already exists (if username should be unique)
+	// Usernames are unique regardless of case
 	var existingUser models.User
-	if err := db.Where("username = ? AND id != ?", userInput.Username, id).First(&existingUser).Error; err == nil {
+	if err := db.Where("LOWER(username) = LOWER(?) AND id != ?", userInput.Username, id).First(&existingUser).Error; err == nil {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
 			"message": "Username already taken",
 			"status":  "error",
@@ -182,7 +195,7 @@ func DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
-	db := database.GetDB()
+	db := middleware.DB(c)
 	var user models.User
 
 	if err := db.First(&user, id).Error; err != nil {
@@ -201,14 +214,21 @@ func DeleteUser(c *fiber.Ctx) error {
 		})
 	}
 
-	// Delete the user and handle errors properly
-	if err := db.Delete(&user).Error; err != nil {
+	// Delete the user and record who did it together
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&user).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, c, auditActor(c), models.AuditUserDelete, fmt.Sprintf("user:%d", user.ID))
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"message": "Failed to delete user",
 			"status":  "error",
 			"data":    nil,
 		})
 	}
+	auth.RevokeUserTokens(strconv.FormatUint(uint64(user.ID), 10))
 
 	// Clear JWT cookie (fix the expiry time)
 	c.Cookie(&fiber.Cookie{
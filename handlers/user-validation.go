@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MaxFullNameLength = 100
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// FieldError is a validation error tied to one field of a request body
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// validateUsername trims a username and checks its length and characters
+func validateUsername(username string) (string, error) {
+	username = strings.TrimSpace(username)
+
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return "", FieldError{"username", fmt.Sprintf("must be between %d and %d characters", MinUsernameLength, MaxUsernameLength)}
+	}
+	if !usernamePattern.MatchString(username) {
+		return "", FieldError{"username", "may only contain letters, digits, '.', '_' and '-'"}
+	}
+
+	return username, nil
+}
+
+// validateFullName trims a display name, collapses inner whitespace and
+// rejects control characters
+func validateFullName(name string) (string, error) {
+	for _, r := range name {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return "", FieldError{"name", "must not contain control characters"}
+		}
+	}
+
+	name = strings.Join(strings.Fields(name), " ")
+
+	if name == "" {
+		return "", FieldError{"name", "is required"}
+	}
+	if utf8.RuneCountInString(name) > MaxFullNameLength {
+		return "", FieldError{"name", fmt.Sprintf("must be at most %d characters", MaxFullNameLength)}
+	}
+
+	return name, nil
+}
+
+// validateUserFields normalizes the username and name in place
+func validateUserFields(username, name *string) error {
+	var err error
+	if *username, err = validateUsername(*username); err != nil {
+		return err
+	}
+	if *name, err = validateFullName(*name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fieldErrorData is the response data naming the field that failed validation
+func fieldErrorData(err error) fiber.Map {
+	var fieldErr FieldError
+	if errors.As(err, &fieldErr) {
+		return fiber.Map{"field": fieldErr.Field}
+	}
+	return nil
+}
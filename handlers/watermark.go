@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"os"
+
+	"github.com/disintegration/gift"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+// The default watermark is branding applied to every processed and generated
+// image. It's either an image (WATERMARK_IMAGE, a path to a PNG file) or text
+// (WATERMARK_TEXT), scaled relative to the image it's drawn on.
+var defaultWatermark = loadWatermark()
+
+type watermarkFilter struct {
+	mark     image.Image
+	text     string
+	position string
+	opacity  float64
+	// Watermark width as a percentage of the target image width
+	scale float64
+}
+
+func loadWatermark() *watermarkFilter {
+	wm := &watermarkFilter{
+		text:     config.ConfigDefault("WATERMARK_TEXT", ""),
+		position: config.ConfigDefault("WATERMARK_POSITION", "bottom-right"),
+		opacity:  float64(config.ConfigInt("WATERMARK_OPACITY", 50)) / 100,
+		scale:    float64(config.ConfigInt("WATERMARK_SCALE", 20)),
+	}
+
+	if !overlayPositions[wm.position] {
+		log.Fatalf("Invalid WATERMARK_POSITION %q", wm.position)
+	}
+	if wm.opacity <= 0 || wm.opacity > 1 {
+		log.Fatalf("WATERMARK_OPACITY must be between 1 and 100")
+	}
+	if wm.scale <= 0 || wm.scale > 100 {
+		log.Fatalf("WATERMARK_SCALE must be between 1 and 100")
+	}
+
+	if path := config.ConfigDefault("WATERMARK_IMAGE", ""); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open watermark image: %v", err)
+		}
+		defer file.Close()
+
+		mark, _, err := image.Decode(file)
+		if err != nil {
+			log.Fatalf("Failed to decode watermark image: %v", err)
+		}
+		wm.mark = mark
+	}
+
+	if wm.mark == nil && wm.text == "" {
+		return nil
+	}
+
+	return wm
+}
+
+// withWatermark appends the default watermark as the last filter so it's
+// drawn on the final image. Passing "none" skips it for a single request.
+func withWatermark(filters []gift.Filter, override string) []gift.Filter {
+	if defaultWatermark == nil || override == "none" {
+		return filters
+	}
+
+	return append(filters[:len(filters):len(filters)], defaultWatermark)
+}
+
+func (f *watermarkFilter) Bounds(srcBounds image.Rectangle) image.Rectangle {
+	return srcBounds
+}
+
+func (f *watermarkFilter) Draw(dst draw.Image, src image.Image, options *gift.Options) {
+	draw.Draw(dst, dst.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	mark := f.render(dst.Bounds())
+	if mark == nil {
+		return
+	}
+
+	bounds := dst.Bounds()
+	margin := min(bounds.Dx(), bounds.Dy()) / 50
+	rect := placeOverlay(bounds, mark.Bounds().Size(), f.position, margin)
+	drawOverlay(dst, rect, mark, f.opacity)
+}
+
+// render produces the watermark scaled to the target image
+func (f *watermarkFilter) render(target image.Rectangle) image.Image {
+	mark := f.mark
+	if mark == nil {
+		rendered, err := renderText(f.text, 48, color.White)
+		if err != nil {
+			return nil
+		}
+		mark = rendered
+	}
+
+	width := int(float64(target.Dx()) * f.scale / 100)
+	if width < 1 {
+		return nil
+	}
+
+	g := gift.New(gift.Resize(width, 0, gift.LanczosResampling))
+	scaled := image.NewRGBA(g.Bounds(mark.Bounds()))
+	g.Draw(scaled, mark)
+
+	return scaled
+}
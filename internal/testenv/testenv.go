@@ -0,0 +1,25 @@
+// Package testenv fills in the settings the server requires at startup, so
+// packages that read them while being initialized can be tested without a
+// .env file. Test files import it for its side effect:
+//
+//	import _ "github.com/krishkalaria12/snap-serve/internal/testenv"
+//
+// Imported packages are initialized first, so the settings are in place
+// before the package under test reads them. Values already set are kept.
+package testenv
+
+import "os"
+
+var settings = map[string]string{
+	"GSC_PROJECT_ID":  "test-project",
+	"GSC_BUCKET_NAME": "test-bucket",
+	"JWT_SECRET":      "test-secret-that-is-at-least-32-characters",
+}
+
+func init() {
+	for key, value := range settings {
+		if os.Getenv(key) == "" {
+			os.Setenv(key, value)
+		}
+	}
+}
@@ -1,31 +1,60 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/krishkalaria12/snap-serve/auth"
+	"github.com/krishkalaria12/snap-serve/config"
 	"github.com/krishkalaria12/snap-serve/database"
+	handler "github.com/krishkalaria12/snap-serve/handlers"
+	"github.com/krishkalaria12/snap-serve/middleware"
 	"github.com/krishkalaria12/snap-serve/models"
 	"github.com/krishkalaria12/snap-serve/router"
+	"github.com/krishkalaria12/snap-serve/tracing"
 )
 
 func main() {
 	_ = database.GetDB()
 
 	// Run migrations
-	err := database.MigrateModels(&models.User{}, &models.Image{})
+	err := database.MigrateModels(&models.User{}, &models.Image{}, &models.GenerationUsage{}, &models.AuditLog{}, &models.APIKey{}, &models.Setting{}, &models.GenerationFeedback{})
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
+	if err := models.MigrateUsernameIndex(database.GetDB()); err != nil {
+		log.Fatalf("Failed to migrate usernames: %v", err)
+	}
+	if err := database.RunMigrations(models.OnlineMigrations(config.Config("GSC_BUCKET_NAME"))); err != nil {
+		log.Fatalf("Failed to run migrations: %v", err)
+	}
+	// Auth loads JWT_SECRET first, so secrets rotated through the admin API
+	// and stored with the runtime settings replace it
+	auth.SetupAuthService()
+	handler.StartRuntimeSettings()
+	handler.StartSizeReconciliation()
+	handler.StartTemporaryResultCleanup()
+	handler.StartDirectUploadCleanup()
 
-	app := fiber.New()
-	app.Use(cors.New())
+	shutdownTracing, err := tracing.Setup()
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("Failed to flush traces: %v", err)
+		}
+	}()
 
-	// Initialize auth service
-	auth.SetupAuthService()
+	app := fiber.New(fiber.Config{
+		BodyLimit:    handler.MaxUploadBytes,
+		ErrorHandler: handler.ErrorHandler,
+	})
+	app.Use(cors.New())
+	app.Use(middleware.TracingMiddleware())
 
 	// close the database connection
 	defer func() {
@@ -36,5 +65,18 @@ func main() {
 	}()
 
 	router.SetupRoutes(app)
-	log.Fatal(app.Listen(":3000"))
+
+	// Serve HTTPS directly when a certificate is configured, otherwise plain
+	// HTTP for running behind a TLS-terminating proxy
+	addr := config.ConfigDefault("LISTEN_ADDR", ":3000")
+	certFile := config.ConfigDefault("TLS_CERT_FILE", "")
+	keyFile := config.ConfigDefault("TLS_KEY_FILE", "")
+	if (certFile == "") != (keyFile == "") {
+		log.Fatal("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	if certFile != "" {
+		log.Fatal(app.ListenTLS(addr, certFile, keyFile))
+	}
+	log.Fatal(app.Listen(addr))
 }
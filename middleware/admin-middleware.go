@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"github.com/krishkalaria12/snap-serve/models"
+)
+
+// AdminMiddleware only lets admins through. It must run after AuthMiddleware.
+// The role is read from the database so a demotion applies immediately.
+func AdminMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := CheckUserLoggedIn(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"status":  "error",
+				"message": "You are not authorized!",
+				"data":    nil,
+			})
+		}
+
+		db := database.GetDB()
+		var user models.User
+		if err := db.Select("id", "role").First(&user, userID).Error; err != nil || user.Role != models.RoleAdmin {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Admin access required",
+				"data":    nil,
+			})
+		}
+
+		return c.Next()
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"errors"
 	"log"
 	"strconv"
 
@@ -11,15 +12,25 @@ import (
 
 func AuthMiddleware() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		var tokenStr string
+		// Server-to-server clients authenticate with an API key instead of a JWT
+		if apiKey := c.Get("X-API-Key"); apiKey != "" {
+			user, err := auth.ResolveAPIKey(apiKey)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidAPIKey) {
+					log.Printf("Failed to resolve API key: %v", err)
+				}
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"message": "Invalid API key",
+					"status":  "error",
+					"data":    nil,
+				})
+			}
 
-		if authHeader != "" && len(authHeader) > 7 && authHeader[:7] == "Bearer " {
-			tokenStr = authHeader[7:]
-		} else {
-			tokenStr = c.Cookies("JWT")
+			c.Locals("user", *user)
+			return c.Next()
 		}
 
+		tokenStr := TokenFromRequest(c)
 		if tokenStr == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"status":  "error",
@@ -28,8 +39,8 @@ func AuthMiddleware() fiber.Handler {
 			})
 		}
 
-		// Validate token using go-pkgz/auth
-		claims, err := auth.GetAuthService().TokenService().Parse(tokenStr)
+		// Validate token using go-pkgz/auth, accepting recently rotated secrets
+		claims, err := auth.ParseToken(tokenStr)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"message": "Invalid token",
@@ -46,6 +57,16 @@ func AuthMiddleware() fiber.Handler {
 	}
 }
 
+// TokenFromRequest returns the JWT of a request, from the Authorization
+// bearer header or else the JWT cookie
+func TokenFromRequest(c *fiber.Ctx) string {
+	authHeader := c.Get("Authorization")
+	if authHeader != "" && len(authHeader) > 7 && authHeader[:7] == "Bearer " {
+		return authHeader[7:]
+	}
+	return c.Cookies("JWT")
+}
+
 func CheckUserLoggedIn(c *fiber.Ctx) (uint, error) {
 	user := c.Locals("user").(token.User)
 
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"encoding/json"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireBody rejects requests to routes that need a body with a uniform 400
+// before the handler runs, when the body is missing or isn't valid JSON.
+// Form encoded bodies are left for the handler's BodyParser to read.
+func RequireBody() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		body := c.Body()
+		if len(body) == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Request body is required",
+				"data":    nil,
+			})
+		}
+
+		isForm := c.Is("urlencoded") || len(c.Request().Header.MultipartFormBoundary()) > 0
+		if !isForm && !json.Valid(body) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Request body must be valid JSON",
+				"data":    nil,
+			})
+		}
+
+		return c.Next()
+	}
+}
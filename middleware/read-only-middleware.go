@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/config"
+)
+
+var readOnly atomic.Bool
+
+func init() {
+	readOnly.Store(config.ConfigBool("READ_ONLY_MODE", false))
+}
+
+// SetReadOnly switches maintenance (read-only) mode on or off
+func SetReadOnly(enabled bool) {
+	readOnly.Store(enabled)
+}
+
+func IsReadOnly() bool {
+	return readOnly.Load()
+}
+
+// ReadOnlyMiddleware rejects mutating requests with 503 while the service is
+// in maintenance mode. Reads, and any paths in allowed, keep working.
+func ReadOnlyMiddleware(allowed ...string) fiber.Handler {
+	exempt := make(map[string]bool, len(allowed))
+	for _, path := range allowed {
+		exempt[path] = true
+	}
+
+	return func(c *fiber.Ctx) error {
+		if !readOnly.Load() || exempt[c.Path()] {
+			return c.Next()
+		}
+
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"status":  "error",
+			"message": "Service is in read-only maintenance mode",
+			"data":    nil,
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/krishkalaria12/snap-serve/database"
+	"gorm.io/gorm"
+)
+
+const txLocalsKey = "tx"
+
+// TransactionMiddleware runs the rest of the request in a database
+// transaction, available to handlers through DB. It commits when the handler
+// succeeds and rolls back when it returns an error, responds with a 4xx/5xx
+// status or panics, so a multi-step write never lands half done.
+//
+// The transaction holds a connection for the whole request, so keep it off
+// routes that wait on storage uploads or Gemini.
+func TransactionMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		tx := database.GetDB().WithContext(c.UserContext()).Begin()
+		if tx.Error != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Database error",
+				"data":    nil,
+			})
+		}
+		c.Locals(txLocalsKey, tx)
+
+		defer func() {
+			if r := recover(); r != nil {
+				tx.Rollback()
+				panic(r)
+			}
+		}()
+
+		err = c.Next()
+		if err != nil || c.Response().StatusCode() >= fiber.StatusBadRequest {
+			tx.Rollback()
+			return err
+		}
+
+		if commitErr := tx.Commit().Error; commitErr != nil {
+			log.Printf("Failed to commit request transaction: %v", commitErr)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"status":  "error",
+				"message": "Database error",
+				"data":    nil,
+			})
+		}
+
+		return nil
+	}
+}
+
+// DB returns the request's transaction when TransactionMiddleware is in use,
+// and the shared connection pool otherwise
+func DB(c *fiber.Ctx) *gorm.DB {
+	if tx, ok := c.Locals(txLocalsKey).(*gorm.DB); ok {
+		return tx
+	}
+	return database.GetDB()
+}
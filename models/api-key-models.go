@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// APIKey lets a user's backend authenticate without the login flow. Only a
+// SHA-256 hash of the key is stored; the key itself is shown once at creation.
+type APIKey struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `json:"-" gorm:"not null;index"`
+	Name      string    `json:"name"`
+	// Prefix is the start of the key, kept so users can tell keys apart
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// Audited actions
+const (
+	AuditLogin          = "auth.login"
+	AuditLoginFailed    = "auth.login_failed"
+	AuditUserDelete     = "user.delete"
+	AuditImageDelete    = "image.delete"
+	AuditMaintenanceSet = "admin.maintenance"
+	AuditJWTRotate      = "admin.jwt_rotate"
+	AuditRoleChange     = "admin.role_change"
+	AuditBucketPublic   = "admin.bucket_public"
+	AuditAPIKeyCreate   = "api_key.create"
+	AuditAPIKeyRevoke   = "api_key.revoke"
+	AuditSettingUpdate  = "admin.setting_update"
+	AuditSettingReset   = "admin.setting_reset"
+	AuditSizeReconcile  = "admin.size_reconcile"
+)
+
+// AuditLog records who performed a sensitive operation. Entries are never
+// updated or soft-deleted.
+type AuditLog struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+	// ActorID is empty when the actor isn't known, e.g. a failed login
+	ActorID *uint  `json:"actor_id" gorm:"index"`
+	Action  string `json:"action" gorm:"not null;index"`
+	// Target identifies what the action was performed on, e.g. "user:42"
+	Target string `json:"target"`
+	IP     string `json:"ip"`
+}
@@ -1,17 +1,79 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Image statuses
+const (
+	ImageStatusPending    = "pending"
+	ImageStatusProcessing = "processing"
+	ImageStatusCompleted  = "completed"
+	ImageStatusFailed     = "failed"
+)
+
 type Image struct {
 	gorm.Model
 	UserID       uint   `json:"user_id" gorm:"not null;index"`
 	Filename     string `json:"filename" gorm:"not null"`
 	OriginalURL  string `json:"original_url" gorm:"not null"`
 	ProcessedURL string `json:"processed_url,omitempty"`
+	ObjectPath   string `json:"object_path"`
+	SizeBytes    int64  `json:"size_bytes"`
+	PHash        string `json:"phash,omitempty" gorm:"index"`
+	BlurHash     string `json:"blurhash,omitempty"`
 	Status       string `json:"status" gorm:"not null;default:'pending'"`
+	Tags         Tags   `json:"tags" gorm:"type:jsonb;not null;default:'[]'"`
+	Favorite     bool   `json:"favorite" gorm:"not null;default:false;index"`
+	// Private images are only reachable through signed URLs
+	Private bool `json:"private" gorm:"not null;default:false"`
+	// Source is how the image came to be stored (upload, generate, filter,
+	// ...), empty for images stored before it was recorded
+	Source string `json:"source"`
+	// ViewCount and LastAccessedAt track reads through the raw endpoint
+	ViewCount      int64      `json:"view_count" gorm:"not null;default:0"`
+	LastAccessedAt *time.Time `json:"last_accessed_at"`
+	// SourceImageID links a processed variant to the image it was made from
+	SourceImageID *uint `json:"source_image_id,omitempty" gorm:"index"`
 
 	// Relationship
-	User User `gorm:"foreignKey:UserID" json:"user"`
+	User        User   `gorm:"foreignKey:UserID" json:"user"`
+	SourceImage *Image `gorm:"foreignKey:SourceImageID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+// Tags is a list of labels stored as a JSON array
+type Tags []string
+
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+
+	data, err := json.Marshal([]string(t))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (t *Tags) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*t = Tags{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Tags", value)
+	}
+
+	return json.Unmarshal(data, (*[]string)(t))
 }
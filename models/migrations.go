@@ -0,0 +1,45 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/krishkalaria12/snap-serve/database"
+	"gorm.io/gorm"
+)
+
+// OnlineMigrations are the changes to large tables that must not block
+// writes. Append new migrations to the end and never change one that shipped.
+func OnlineMigrations(bucketName string) []database.Migration {
+	return []database.Migration{
+		{
+			ID: "001_images_user_created_index",
+			Migrate: func(db *gorm.DB) error {
+				return database.CreateIndexConcurrently(db, "idx_images_user_created", "images", "(user_id, created_at DESC)")
+			},
+		},
+		{
+			// Images stored before object paths were recorded only have
+			// their public URL
+			ID: "002_backfill_image_object_path",
+			Migrate: func(db *gorm.DB) error {
+				prefix := fmt.Sprintf("https://storage.googleapis.com/%s/", bucketName)
+				return database.BackfillInBatches(db, "images",
+					"object_path = substring(original_url from ?)",
+					"COALESCE(object_path, '') = '' AND starts_with(original_url, ?)",
+					1000, len(prefix)+1, prefix)
+			},
+		},
+		{
+			// Source is always filtered within one user's images. Replaces
+			// the single column index AutoMigrate created, which a
+			// plain CREATE INDEX built while holding a write lock.
+			ID: "003_images_user_source_index",
+			Migrate: func(db *gorm.DB) error {
+				if err := database.CreateIndexConcurrently(db, "idx_images_user_source", "images", "(user_id, source)"); err != nil {
+					return err
+				}
+				return db.Exec("DROP INDEX CONCURRENTLY IF EXISTS idx_images_source").Error
+			},
+		},
+	}
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Setting overrides a runtime-adjustable setting. Settings without a row use
+// their environment value or built-in default.
+type Setting struct {
+	Key       string    `json:"key" gorm:"primaryKey"`
+	Value     string    `json:"value" gorm:"not null"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UpdatedBy *uint     `json:"updated_by"`
+}
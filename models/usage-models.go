@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GenerationUsage counts a user's image generations for a single UTC day
+type GenerationUsage struct {
+	gorm.Model
+	UserID uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_generation_usage_user_day"`
+	Day    time.Time `json:"day" gorm:"type:date;not null;uniqueIndex:idx_generation_usage_user_day"`
+	Count  int       `json:"count" gorm:"not null;default:0"`
+}
+
+// GenerationFeedback is what Gemini reported about one generation: why it
+// stopped and which safety categories it flagged. It's kept for generations
+// that produced no image too, which is when it matters most.
+type GenerationFeedback struct {
+	gorm.Model
+	UserID        uint   `json:"user_id" gorm:"not null;index"`
+	ImageURL      string `json:"image_url,omitempty"`
+	FinishReason  string `json:"finish_reason,omitempty"`
+	FinishMessage string `json:"finish_message,omitempty"`
+	BlockReason   string `json:"block_reason,omitempty"`
+	BlockMessage  string `json:"block_message,omitempty"`
+	// SafetyFlags are the categories rated above negligible, as
+	// "CATEGORY:PROBABILITY", or marked blocked
+	SafetyFlags Tags `json:"safety_flags" gorm:"type:jsonb;not null;default:'[]'"`
+}
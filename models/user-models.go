@@ -1,6 +1,19 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Roles lists every role a user can be given
+var Roles = []string{RoleUser, RoleAdmin}
 
 type User struct {
 	gorm.Model
@@ -8,6 +21,34 @@ type User struct {
 	Email    string `gorm:"uniqueIndex;not null" json:"email"`
 	Password string `gorm:"not null" json:"password"`
 	FullName string `gorm:"not null" json:"name"`
+	Role     string `gorm:"not null;default:'user'" json:"role"`
 
 	Images []Image `json:"images,omitempty" gorm:"foreignKey:UserID"`
 }
+
+// MigrateUsernameIndex makes usernames unique regardless of case. Existing
+// accounts whose usernames only differ by case are renamed first, keeping the
+// oldest account's name and suffixing the others with their ID.
+func MigrateUsernameIndex(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var duplicates []User
+		err := tx.Raw(`SELECT * FROM users u WHERE deleted_at IS NULL AND EXISTS (
+			SELECT 1 FROM users o
+			WHERE o.deleted_at IS NULL AND LOWER(o.username) = LOWER(u.username) AND o.id < u.id
+		) ORDER BY id`).Scan(&duplicates).Error
+		if err != nil {
+			return err
+		}
+
+		for _, user := range duplicates {
+			renamed := fmt.Sprintf("%s_%d", user.Username, user.ID)
+			log.Printf("Renaming user %d from %q to %q, the username is taken in another case", user.ID, user.Username, renamed)
+			if err := tx.Model(&user).Update("username", renamed).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_lower
+			ON users (LOWER(username)) WHERE deleted_at IS NULL`).Error
+	})
+}
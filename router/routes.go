@@ -9,21 +9,74 @@ import (
 
 func SetupRoutes(app *fiber.App) {
 	api := app.Group("/api", logger.New())
+
+	// Login and the maintenance toggle itself must keep working in read-only mode
+	api.Use(middleware.ReadOnlyMiddleware("/api/auth/login", "/api/admin/maintenance"))
+
 	api.Get("/hello", handler.Hello)
+	api.Get("/health", handler.Health)
+	api.Get("/capabilities", handler.GetCapabilities)
 
 	// Auth
 	auth := api.Group("/auth")
-	auth.Post("/login", handler.Login)
+	auth.Post("/login", middleware.RequireBody(), handler.Login)
+	auth.Get("/verify-token", handler.VerifyToken)
 
 	// User
 	user := api.Group("/user")
+	user.Get("/api-keys", middleware.AuthMiddleware(), handler.ListAPIKeys)
+	user.Post("/api-keys", middleware.AuthMiddleware(), middleware.RequireBody(), middleware.TransactionMiddleware(), handler.CreateAPIKey)
+	user.Delete("/api-keys/:id", middleware.AuthMiddleware(), middleware.TransactionMiddleware(), handler.RevokeAPIKey)
 	user.Get("/:id", handler.GetUser)
-	user.Post("/", handler.CreateUser)
-	user.Put("/:id", middleware.AuthMiddleware(), handler.UpdateUser)
-	user.Delete("/:id", middleware.AuthMiddleware(), handler.DeleteUser)
+	user.Post("/", middleware.RequireBody(), handler.CreateUser)
+	user.Put("/:id", middleware.AuthMiddleware(), middleware.RequireBody(), middleware.TransactionMiddleware(), handler.UpdateUser)
+	user.Delete("/:id", middleware.AuthMiddleware(), middleware.TransactionMiddleware(), handler.DeleteUser)
 
 	image := api.Group("/image")
+	image.Get("/", middleware.AuthMiddleware(), handler.ListImages)
 	image.Post("/upload", middleware.AuthMiddleware(), handler.UploadImage)
-	image.Post("/generate", middleware.AuthMiddleware(), handler.GenerateImage)
-	image.Post("/filter", middleware.AuthMiddleware(), handler.ApplyFilterToImage)
+	image.Post("/upload/batch", middleware.AuthMiddleware(), handler.UploadMultipleImages)
+	image.Post("/upload-url", middleware.AuthMiddleware(), middleware.RequireBody(), handler.UploadImageFromURL)
+	image.Post("/upload/direct", middleware.AuthMiddleware(), middleware.RequireBody(), handler.CreateDirectUpload)
+	image.Post("/upload/direct/confirm", middleware.AuthMiddleware(), middleware.RequireBody(), handler.ConfirmDirectUpload)
+	image.Post("/generate", middleware.AuthMiddleware(), middleware.RequireBody(), handler.GenerateImage)
+	image.Post("/filter", middleware.AuthMiddleware(), middleware.RequireBody(), handler.ApplyFilterToImage)
+	image.Post("/filter/estimate", middleware.AuthMiddleware(), middleware.RequireBody(), handler.EstimateFilters)
+	image.Post("/compare", middleware.AuthMiddleware(), middleware.RequireBody(), handler.CompareImage)
+	image.Post("/reencode", middleware.AuthMiddleware(), middleware.AdminMiddleware(), middleware.RequireBody(), handler.ReencodeImages)
+	image.Post("/tags", middleware.AuthMiddleware(), middleware.RequireBody(), middleware.TransactionMiddleware(), handler.BulkTagImages)
+	image.Patch("/:id", middleware.AuthMiddleware(), middleware.RequireBody(), middleware.TransactionMiddleware(), handler.UpdateImage)
+	image.Delete("/:id", middleware.AuthMiddleware(), handler.DeleteImage)
+	image.Put("/:id/content", middleware.AuthMiddleware(), handler.ReplaceImageContent)
+	image.Get("/:id/variants", middleware.AuthMiddleware(), handler.GetImageVariants)
+	image.Get("/:id/metadata", middleware.AuthMiddleware(), handler.GetImageMetadata)
+	image.Get("/:id/url", middleware.AuthMiddleware(), handler.GetImageURL)
+	image.Get("/:id/raw", middleware.AuthMiddleware(), handler.GetImageRaw)
+	image.Post("/:id/favorite", middleware.AuthMiddleware(), middleware.TransactionMiddleware(), handler.ToggleFavorite)
+	image.Get("/:id/palette", middleware.AuthMiddleware(), handler.GetImagePalette)
+	image.Get("/:id/histogram", middleware.AuthMiddleware(), handler.GetImageHistogram)
+	image.Get("/:id/roi", middleware.AuthMiddleware(), handler.GetImageROI)
+	image.Get("/:id/exif", middleware.AuthMiddleware(), handler.GetImageExif)
+	image.Get("/:id/similar", middleware.AuthMiddleware(), handler.GetSimilarImages)
+
+	// Jobs
+	jobs := api.Group("/jobs")
+	jobs.Get("/:id", middleware.AuthMiddleware(), handler.GetJob)
+	jobs.Delete("/:id", middleware.AuthMiddleware(), handler.CancelJob)
+
+	// Admin. Only routes that write several rows run in a transaction;
+	// storage routes would hold a pooled connection for the whole call.
+	// Routes that also change in-memory state save their rows themselves and
+	// only apply the change once those are committed.
+	admin := api.Group("/admin", middleware.AuthMiddleware(), middleware.AdminMiddleware())
+	admin.Get("/maintenance", handler.GetMaintenanceMode)
+	admin.Put("/maintenance", middleware.RequireBody(), handler.SetMaintenanceMode)
+	admin.Post("/jwt-secret/rotate", middleware.RequireBody(), handler.RotateJWTSecret)
+	admin.Get("/audit-logs", handler.GetAuditLogs)
+	admin.Put("/users/:id/role", middleware.RequireBody(), middleware.TransactionMiddleware(), handler.SetUserRole)
+	admin.Post("/storage/make-public", middleware.RequireBody(), handler.MakeBucketPublic)
+	admin.Post("/storage/reconcile-sizes", handler.ReconcileImageSizes)
+	admin.Get("/settings", handler.GetSettings)
+	admin.Put("/settings/:key", middleware.RequireBody(), handler.UpdateSetting)
+	admin.Delete("/settings/:key", handler.ResetSetting)
 }